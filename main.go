@@ -2,86 +2,225 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
-	"text/template"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rewind-moe/launcher/pkg/api"
+	"github.com/rewind-moe/launcher/pkg/launcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 func main() {
 	var err error
-	var config *rest.Config
 
 	var kubeconfig = flag.String("kubeconfig", "", "(optional) absolute path to the kubeconfig file")
 	var namespaceFlag = flag.String("namespace", "", "(optional) namespace to use")
-	var jobSpecPath = flag.String("job-spec", "", "path to job spec file")
+	var jobSpecPath = flag.String("job-spec", "", "path to job spec file, required without a default profile")
 	var serviceSpecPath = flag.String("service-spec", "", "(optional) path to service spec file")
 	var ingressSpecPath = flag.String("ingress-spec", "", "(optional) path to ingress spec file")
+	var pdbSpecPath = flag.String("pdb-spec", "", "(optional) path to pod disruption budget spec file")
+	var networkPolicySpecPath = flag.String("networkpolicy-spec", "", "(optional) path to network policy spec file")
+	var httpRouteSpecPath = flag.String("httproute-spec", "", "(optional) path to Gateway API HTTP route spec file")
+	var virtualServiceSpecPath = flag.String("virtualservice-spec", "", "(optional) path to Istio virtual service spec file")
+	var destinationRuleSpecPath = flag.String("destinationrule-spec", "", "(optional) path to Istio destination rule spec file")
+	var ingressBaseDomain = flag.String("ingress-base-domain", "", "(optional) domain the .IngressHost of each video is created under")
+	var ingressHostPrefix = flag.String("ingress-host-prefix", launcher.Hostnames.Prefix, "(optional) prefix of the .IngressHost label")
+	var ingressPathPattern = flag.String("ingress-path-pattern", launcher.Hostnames.PathPattern, "(optional) pattern of .IngressPath, with {name} and {videoId} placeholders")
+	var serviceType = flag.String("service-type", "", "(optional) type the rendered services are switched to: ClusterIP, NodePort or LoadBalancer")
+	var nameServicePorts = flag.Bool("name-service-ports", false, "(optional) name unnamed service ports after their protocol and port, e.g. tcp-80")
+	var builtinProfile = flag.String("builtin-profile", "", "(optional) profile compiled into the binary used as the default profile instead of -job-spec: "+strings.Join(launcher.BuiltinProfiles(), ", "))
+	var profilesDir = flag.String("profiles-dir", "", "(optional) path to a directory with a subdirectory of templates per profile")
+	var profilesSource = flag.String("profiles-source", "", "(optional) OCI artifact, oci://<registry>/<repository>[:<tag>|@<digest>], or Git repository, git+<url>[#<branch, tag or commit>], the profiles directory is fetched from instead of -profiles-dir")
+	var profilesSourcePath = flag.String("profiles-source-path", "", "(optional) path of the profiles directory within the -profiles-source artifact or repository")
+	var profilesRefresh = flag.Duration("profiles-refresh", 5*time.Minute, "(optional) interval at which an unpinned -profiles-source is checked for changes")
+	var maxProfileVersions = flag.Int("max-profile-versions", launcher.MaxProfileVersions, "(optional) number of versions kept of each profile, the oldest inactive dropped first, 0 keeps them all")
+	var preHookSpecPath = flag.String("pre-hook-spec", "", "(optional) path to spec file of resources created before the job")
+	var postHookSpecPath = flag.String("post-hook-spec", "", "(optional) path to spec file of a job launched after the job completes")
+	var fakeCluster = flag.Bool("fake-cluster", false, "(optional) run against an in-memory cluster instead of a real one, for local development")
+	var kubeQPS = flag.Float64("kube-qps", 0, "(optional) maximum queries per second to the API server, 0 uses the client-go default")
+	var kubeBurst = flag.Int("kube-burst", 0, "(optional) maximum burst of queries to the API server, 0 uses the client-go default")
+	var watchTimeout = flag.Duration("watch-timeout", 0, "(optional) timeout of each job watch, 0 leaves it to the API server")
+	var relistInterval = flag.Duration("relist-interval", 10*time.Minute, "(optional) interval of full job relists, 0 disables them")
+	var resyncPeriod = flag.Duration("resync-period", 0, "(optional) resync period of the job cache, 0 disables resyncs")
+	var cleanupWorkers = flag.Int("cleanup-workers", 4, "(optional) number of completed jobs cleaned up in parallel, 0 cleans up in the watcher")
+	var cleanupRetries = flag.Int("cleanup-retries", 5, "(optional) retries of a failed cleanup, with exponential backoff")
+	var cleanupHistorySize = flag.Int("cleanup-history-size", 1000, "(optional) number of cleanup actions kept in memory")
+	var cleanupHistoryPath = flag.String("cleanup-history-file", "", "(optional) path to a file cleanup actions are persisted to")
+	var launchHistoryPath = flag.String("launch-history-file", "", "(optional) path to a file launch records are persisted to")
+	var launchHistoryMaxAge = flag.Duration("launch-history-max-age", 0, "(optional) how long launch records are kept after the job finished, 0 keeps them forever")
+	var launchHistoryMaxRecords = flag.Int("launch-history-max-records", 0, "(optional) number of launch records kept, oldest dropped first, 0 keeps them all")
+	var storageBackend = flag.String("storage", "", "(optional) storage of launch and cleanup history, scheduled launches, cleanup deduplication and the rollout audit log: memory, bolt or postgres")
+	var storagePath = flag.String("storage-path", "launcher.db", "(optional) path of the bolt storage")
+	var storageDSN = flag.String("storage-dsn", "", "(optional) connection string of the postgres storage")
+	var nameHashLength = flag.Int("name-hash-length", launcher.NameHashLength, "(optional) number of hex characters of the video ID hash in .UniqueName")
+	var tenantsConfigPath = flag.String("tenants-config", "", "(optional) path to tenants config file")
+	var tenantHeader = flag.String("tenant-header", "", "(optional) trusted request header that selects a tenant by name")
+	var overlaysDir = flag.String("overlays-dir", "", "(optional) path to the directory holding per-environment overlays")
+	var environment = flag.String("environment", "", "(optional) environment whose overlays are applied to rendered manifests")
+	var dryRunValidate = flag.Bool("dry-run-validate", false, "(optional) validate launches with a server-side dry run before creating anything")
+	var fieldValidation = flag.String("field-validation", launcher.FieldValidationStrict, "(optional) server-side field validation of created objects: Strict, Warn or Ignore")
+	var returnWarnings = flag.Bool("return-warnings", false, "(optional) include API server warnings in launch responses")
+	var credentialsURL = flag.String("credentials-url", "", "(optional) URL of an HTTP endpoint issuing credentials per launch, authenticated with $CREDENTIALS_TOKEN")
+	var vaultPath = flag.String("vault-credentials-path", "", "(optional) Vault path issuing credentials per launch, e.g. database/creds/recorder, using $VAULT_ADDR and $VAULT_TOKEN")
+	var deliveryAttempts = flag.Int("delivery-attempts", 4, "(optional) attempts of each outbound call to credentials and artifacts endpoints, including the first")
+	var deliveryMaxBackoff = flag.Duration("delivery-max-backoff", 10*time.Second, "(optional) longest wait between attempts of an outbound call")
+	var deadLetterSize = flag.Int("dead-letter-size", 1000, "(optional) number of undeliverable payloads kept in memory")
+	var deadLetterPath = flag.String("dead-letter-file", "", "(optional) path to a file undeliverable payloads are appended to")
+	var paramsFilePath = flag.String("params-file", "", "(optional) path to a YAML file of global default template parameters, available as .Params")
+	var artifactsSpecPath = flag.String("artifacts-spec", "", "(optional) path to spec file listing the artifacts of a succeeded job")
+	var artifactsURL = flag.String("artifacts-url", "", "(optional) URL artifacts of succeeded jobs are registered at, authenticated with $ARTIFACTS_TOKEN")
+	var artifactsKind = flag.String("artifacts-kind", "", "(optional) kind of the objects artifacts of succeeded jobs are registered as, e.g. Recording.v1alpha1.example.com")
+	var schedulingConfigPath = flag.String("scheduling-config", "", "(optional) path to affinities and topology spread constraints injected into jobs that lack them")
+	var securityDefaults = flag.Bool("security-defaults", false, "(optional) inject restricted securityContext defaults into jobs whose templates leave them unset")
+	var checkResourceQuotas = flag.Bool("check-resource-quotas", false, "(optional) reject jobs that would exceed the namespace's resource quotas")
+	var capacityThreshold = flag.Duration("capacity-threshold", 0, "(optional) reject launches while pods of launched jobs are unschedulable for longer than this, 0 disables it")
+	var heartbeatWindow = flag.Duration("heartbeat-window", 0, "(optional) mark active launches stale that sent no heartbeat for this long, 0 disables it")
+	var heartbeatTeardown = flag.Bool("heartbeat-teardown", false, "(optional) tear down stale launches instead of only marking them")
+	var heartbeatAlertURL = flag.String("heartbeat-alert-url", "", "(optional) URL stale launches are POSTed to, authenticated with $ALERT_TOKEN")
+	var queueStore = flag.String("queue-store", "memory", "(optional) where scheduled and queued launches are kept: memory, configmap to survive restarts and share them between replicas, or storage")
+	var queueInterval = flag.Duration("queue-interval", 10*time.Second, "(optional) interval at which scheduled and queued launches are started")
+	var permissionCheck = flag.String("permission-check", "warn", "(optional) check the service account's RBAC permissions on startup: fail, warn or off")
+	var ingressReadinessTimeout = flag.Duration("ingress-readiness-timeout", 0, "(optional) probe the hosts of created ingresses until they are reachable for up to this long, and report it in the launch status, 0 disables it")
+	var ingressReadinessScheme = flag.String("ingress-readiness-scheme", "http", "(optional) scheme of ingress readiness probes: http or https")
+	var retentionInterval = flag.Duration("retention-interval", 10*time.Minute, "(optional) interval at which PVCs and Secrets retained by profiles are deleted once expired or consumed")
+	var restoreWindow = flag.Duration("restore-window", 0, "(optional) retire the services, ingresses and other resources of completed jobs, and only delete them after this long, 0 deletes them right away")
+	var allowChaos = flag.Bool("allow-chaos", false, "(optional) let admin tenants simulate the completion of launches, for staging")
+	var allowDebug = flag.Bool("allow-debug", false, "(optional) return rendered manifests to permitted tenants sending X-Debug: true")
 	flag.Parse()
 
-	var (
-		jobTemplate     *template.Template
-		serviceTemplate *template.Template
-		ingressTemplate *template.Template
-	)
+	if *nameHashLength < 8 || *nameHashLength > 40 {
+		log.Fatalf("name-hash-length must be between 8 and 40")
+	}
+	launcher.NameHashLength = *nameHashLength
+	launcher.MaxProfileVersions = *maxProfileVersions
 
-	// Read template files
-	if *jobSpecPath != "" {
-		jobTemplateStr, err := ReadToString(*jobSpecPath)
-		if err != nil {
-			log.Fatalf("error reading job spec file: %v", err)
-		}
-		if jobTemplate, err = template.New("job").Parse(jobTemplateStr); err != nil {
-			log.Fatalf("error parsing job template: %v", err)
-		}
-	} else {
-		log.Fatalf("job-spec flag is required")
+	switch *permissionCheck {
+	case "fail", "warn", "off":
+	default:
+		log.Fatalf("permission-check must be fail, warn or off")
 	}
 
-	if *serviceSpecPath != "" {
-		serviceTemplateStr, err := ReadToString(*serviceSpecPath)
-		if err != nil {
-			log.Fatalf("error reading service spec file: %v", err)
-		}
-		if serviceTemplate, err = template.New("service").Parse(serviceTemplateStr); err != nil {
-			log.Fatalf("error parsing service template: %v", err)
-		}
+	switch *fieldValidation {
+	case launcher.FieldValidationStrict, launcher.FieldValidationWarn, launcher.FieldValidationIgnore:
+	default:
+		log.Fatalf("field-validation must be Strict, Warn or Ignore")
+	}
+
+	launcher.Hostnames = launcher.HostnameConfig{
+		BaseDomain:  *ingressBaseDomain,
+		Prefix:      *ingressHostPrefix,
+		PathPattern: *ingressPathPattern,
 	}
 
-	if *ingressSpecPath != "" {
-		ingressTemplateStr, err := ReadToString(*ingressSpecPath)
+	if err := launcher.ValidateServiceType(corev1.ServiceType(*serviceType)); err != nil {
+		log.Fatalf("service-type: %v", err)
+	}
+	var remoteProfiles *launcher.RemoteProfiles
+	if *profilesSource != "" {
+		if *profilesDir != "" {
+			log.Fatalf("profiles-dir and profiles-source flags cannot be combined")
+		}
+		var err error
+		remoteProfiles, err = launcher.NewRemoteProfiles(*profilesSource, *profilesSourcePath, os.Getenv("PROFILES_SOURCE_CREDENTIALS"))
 		if err != nil {
-			log.Fatalf("error reading ingress spec file: %v", err)
+			log.Fatalf("%v", err)
 		}
-		if ingressTemplate, err = template.New("ingress").Parse(ingressTemplateStr); err != nil {
-			log.Fatalf("error parsing ingress template: %v", err)
+		if _, err := remoteProfiles.Refresh(context.Background()); err != nil {
+			log.Fatalf("%v", err)
 		}
 	}
+	// Read template profiles, again on every reload
+	loadProfiles := func() (map[string]*launcher.Profile, error) {
+		profiles := map[string]*launcher.Profile{}
+		dir := *profilesDir
+		if remoteProfiles != nil {
+			dir = remoteProfiles.Dir()
+		}
+		if dir != "" {
+			var err error
+			if profiles, err = launcher.LoadProfilesDir(dir); err != nil {
+				return nil, fmt.Errorf("error loading profiles: %w", err)
+			}
+		}
+		if _, ok := profiles[launcher.DefaultProfileName]; !ok && *builtinProfile != "" {
+			if *jobSpecPath != "" {
+				return nil, fmt.Errorf("job-spec and builtin-profile flags cannot be combined")
+			}
+			profile, err := launcher.LoadBuiltinProfile(*builtinProfile, launcher.DefaultProfileName)
+			if err != nil {
+				return nil, fmt.Errorf("error loading builtin profile: %w", err)
+			}
+			profiles[launcher.DefaultProfileName] = profile
+		} else if !ok {
+			if *jobSpecPath == "" {
+				return nil, fmt.Errorf("job-spec or builtin-profile flag is required without a %s profile", launcher.DefaultProfileName)
+			}
+			profile, err := launcher.LoadProfile(launcher.DefaultProfileName, map[string]string{
+				"job":             *jobSpecPath,
+				"service":         *serviceSpecPath,
+				"ingress":         *ingressSpecPath,
+				"pdb":             *pdbSpecPath,
+				"networkpolicy":   *networkPolicySpecPath,
+				"httproute":       *httpRouteSpecPath,
+				"virtualservice":  *virtualServiceSpecPath,
+				"destinationrule": *destinationRuleSpecPath,
+				"pre-hook":        *preHookSpecPath,
+				"post-hook":       *postHookSpecPath,
+				"artifacts":       *artifactsSpecPath,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error loading templates: %w", err)
+			}
+			profiles[launcher.DefaultProfileName] = profile
+		}
 
-	// Get the kubeconfig file path from flag, or use the in-cluster config
-	if *kubeconfig == "" {
-		log.Printf("Reading in-cluster configuration because kubeconfig flag is not set")
-		config, err = rest.InClusterConfig()
-	} else {
-		log.Printf("Reading configuration from file: %s", *kubeconfig)
-		config, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
+		// Service settings of the flags apply to profiles without their own
+		for _, profile := range profiles {
+			if profile.Settings.ServiceType == "" {
+				profile.Settings.ServiceType = corev1.ServiceType(*serviceType)
+			}
+			profile.Settings.NameServicePorts = profile.Settings.NameServicePorts || *nameServicePorts
+		}
+		return profiles, nil
 	}
+	profiles, err := loadProfiles()
 	if err != nil {
-		panic(fmt.Errorf("error building kubeconfig: %v", err))
+		log.Fatalf("%v", err)
 	}
 
-	// Create the clientset
-	log.Printf("Creating clientset")
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		panic(fmt.Errorf("error building kubernetes clientset: %v", err))
+	// Read overlays
+	var overlays map[string]*launcher.Overlay
+	if *environment != "" {
+		if *overlaysDir == "" {
+			log.Fatalf("overlays-dir flag is required when environment is set")
+		}
+		if overlays, err = launcher.LoadOverlays(*overlaysDir, *environment); err != nil {
+			log.Fatalf("error loading overlays: %v", err)
+		}
+	}
+
+	// Read scheduling hints
+	var scheduling *launcher.SchedulingConfig
+	if *schedulingConfigPath != "" {
+		if scheduling, err = launcher.LoadSchedulingConfig(*schedulingConfigPath); err != nil {
+			log.Fatalf("error loading scheduling config: %v", err)
+		}
 	}
 
 	// Get the current namespace
@@ -89,57 +228,334 @@ func main() {
 	if *namespaceFlag != "" {
 		namespace = *namespaceFlag
 	} else {
-		namespace = GetCurrentNamespaceOrDefault()
+		namespace = launcher.GetCurrentNamespaceOrDefault()
 	}
 	log.Printf("Using namespace: %s", namespace)
 
-	// Create clients
-	jobClient := clientset.BatchV1().Jobs(namespace)
-	serviceClient := clientset.CoreV1().Services(namespace)
-	ingressClient := clientset.NetworkingV1().Ingresses(namespace)
+	// Load tenants
+	tenants, err := launcher.LoadTenantRegistry(*tenantsConfigPath, *tenantHeader)
+	if err != nil {
+		log.Fatalf("error loading tenants: %v", err)
+	}
+
+	// Open storage
+	var storage launcher.Storage
+	switch *storageBackend {
+	case "":
+	case "memory":
+		storage = launcher.NewMemoryStorage()
+	case "bolt":
+		storage, err = launcher.NewBoltStorage(*storagePath)
+	case "postgres":
+		storage, err = openSQLStorage(*storageDSN)
+	default:
+		log.Fatalf("storage must be memory, bolt or postgres")
+	}
+	if err != nil {
+		log.Fatalf("error opening storage: %v", err)
+	}
+	if storage != nil && (*cleanupHistoryPath != "" || *launchHistoryPath != "") {
+		log.Fatalf("history files can't be used with storage")
+	}
+
+	// Load cleanup history
+	var cleanupHistory *launcher.CleanupHistory
+	if storage != nil {
+		cleanupHistory, err = launcher.NewStorageCleanupHistory(context.Background(), *cleanupHistorySize, storage)
+	} else {
+		cleanupHistory, err = launcher.NewCleanupHistory(*cleanupHistorySize, *cleanupHistoryPath)
+	}
+	if err != nil {
+		log.Fatalf("error loading cleanup history: %v", err)
+	}
+
+	// Load launch history
+	var launchHistory *launcher.LaunchHistory
+	if storage != nil {
+		launchHistory, err = launcher.NewStorageLaunchHistory(context.Background(), storage)
+	} else {
+		launchHistory, err = launcher.NewLaunchHistory(*launchHistoryPath)
+	}
+	if err != nil {
+		log.Fatalf("error loading launch history: %v", err)
+	}
+	launchHistory.MaxAge = *launchHistoryMaxAge
+	launchHistory.MaxRecords = *launchHistoryMaxRecords
+
+	// Send outbound calls with retries
+	deadLetters, err := launcher.NewDeadLetterLog(*deadLetterSize, *deadLetterPath)
+	if err != nil {
+		log.Fatalf("error opening dead letter log: %v", err)
+	}
+	delivery := launcher.NewDelivery(deadLetters)
+	delivery.MaxAttempts = *deliveryAttempts
+	delivery.MaxBackoff = *deliveryMaxBackoff
 
 	// Set up services
-	launcherService := NewLauncherService(
-		jobClient,
-		serviceClient,
-		ingressClient,
-		jobTemplate,
-		serviceTemplate,
-		ingressTemplate,
-	)
+	var launcherService *launcher.LauncherService
+	if *fakeCluster {
+		log.Printf("Using an in-memory fake cluster, jobs never finish on their own")
+		launcherService = launcher.NewFakeCluster(namespace, tenants, profiles)
+	} else {
+		launcherService = newClusterLauncherService(*kubeconfig, *kubeQPS, *kubeBurst, namespace, tenants, profiles)
+	}
+	launcherService.DryRunValidate = *dryRunValidate
+	launcherService.FieldValidation = *fieldValidation
+	launcherService.ReturnWarnings = *returnWarnings
+	launcherService.Scheduling = scheduling
+	launcherService.SecurityDefaults = *securityDefaults
+	launcherService.Chaos = *allowChaos
+	launcherService.CheckResourceQuotas = *checkResourceQuotas
+	switch {
+	case *credentialsURL != "" && *vaultPath != "":
+		log.Fatalf("credentials-url and vault-credentials-path are mutually exclusive")
+	case *credentialsURL != "":
+		launcherService.Credentials = &launcher.HTTPCredentialsProvider{
+			URL:      *credentialsURL,
+			Token:    os.Getenv("CREDENTIALS_TOKEN"),
+			Delivery: delivery,
+		}
+	case *vaultPath != "":
+		if os.Getenv("VAULT_ADDR") == "" || os.Getenv("VAULT_TOKEN") == "" {
+			log.Fatalf("VAULT_ADDR and VAULT_TOKEN must be set for vault-credentials-path")
+		}
+		launcherService.Credentials = &launcher.VaultCredentialsProvider{
+			Addr:     os.Getenv("VAULT_ADDR"),
+			Token:    os.Getenv("VAULT_TOKEN"),
+			Path:     *vaultPath,
+			Delivery: delivery,
+		}
+	}
+	switch {
+	case *artifactsURL != "" && *artifactsKind != "":
+		log.Fatalf("artifacts-url and artifacts-kind are mutually exclusive")
+	case *artifactsURL != "":
+		launcherService.Artifacts = &launcher.HTTPArtifactRegistrar{
+			URL:      *artifactsURL,
+			Token:    os.Getenv("ARTIFACTS_TOKEN"),
+			Delivery: delivery,
+		}
+	case *artifactsKind != "":
+		gvk, _ := schema.ParseKindArg(*artifactsKind)
+		if gvk == nil {
+			log.Fatalf("artifacts-kind must be <kind>.<version>.<group>")
+		}
+		launcherService.Artifacts = &launcher.ResourceArtifactRegistrar{
+			Dynamic: launcherService.Dynamic,
+			Mapper:  launcherService.Mapper,
+			Kind:    *gvk,
+		}
+	}
+	if *paramsFilePath != "" {
+		if launcherService.Params, err = launcher.LoadParamsFile(*paramsFilePath); err != nil {
+			log.Fatalf("error loading params: %v", err)
+		}
+	}
+	launcherService.Delivery = delivery
+	launcherService.Overlays = overlays
+	launcherService.Environment = *environment
+	launcherService.ProfileSource = loadProfiles
+	launcherService.RemoteProfiles = remoteProfiles
+	launcherService.CleanupHistory = cleanupHistory
+	launcherService.LaunchHistory = launchHistory
+	if storage != nil {
+		launcherService.Storage = storage
+		if err := launcherService.PruneDedup(context.Background()); err != nil {
+			log.Printf("error pruning cleanup marks: %v", err)
+		}
+		if err := launcherService.RestoreProfiles(context.Background()); err != nil {
+			log.Fatalf("error restoring profile versions: %v", err)
+		}
+	}
+	launcherService.Tuning = launcher.Tuning{
+		WatchTimeout:   *watchTimeout,
+		RelistInterval: *relistInterval,
+		ResyncPeriod:   *resyncPeriod,
+		CleanupWorkers: *cleanupWorkers,
+		CleanupRetries: *cleanupRetries,
+	}
 
-	// Start listening for events
-	go func() {
-		if err := launcherService.CleanupWatcher(context.Background()); err != nil {
-			log.Fatalf("error watching for cleanup events: %v", err)
+	// Hold back launches while the cluster is full
+	if *capacityThreshold > 0 {
+		launcherService.Capacity = &launcher.CapacityMonitor{
+			Threshold: *capacityThreshold,
+			Interval:  15 * time.Second,
 		}
-	}()
+	}
 
-	// Set up webserver
-	r := gin.Default()
+	// Catch recordings of streams that silently died
+	if *heartbeatWindow > 0 {
+		launcherService.Heartbeats = &launcher.HeartbeatMonitor{
+			Window:     *heartbeatWindow,
+			Interval:   *heartbeatWindow / 4,
+			Teardown:   *heartbeatTeardown,
+			AlertURL:   *heartbeatAlertURL,
+			AlertToken: os.Getenv("ALERT_TOKEN"),
+			Delivery:   delivery,
+		}
+	}
 
-	r.GET("/", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "ok",
-			"app":    "live-launcher",
-		})
-	})
+	// Tell when players can connect to a launch
+	if *ingressReadinessTimeout > 0 {
+		if *ingressReadinessScheme != "http" && *ingressReadinessScheme != "https" {
+			log.Fatalf("ingress-readiness-scheme must be http or https")
+		}
+		launcherService.IngressReadiness = &launcher.IngressReadiness{
+			Timeout:  *ingressReadinessTimeout,
+			Interval: 5 * time.Second,
+			Scheme:   *ingressReadinessScheme,
+		}
+	}
 
-	r.PUT("/api/v1/live/:videoId", func(c *gin.Context) {
-		videoId := strings.Trim(c.Param("videoId"), "/")
-		ctx := c.Request.Context()
-		if err := launcherService.Launch(ctx, videoId); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
-			return
+	// Keep networking around for post-processing
+	launcherService.RestoreWindow = *restoreWindow
+
+	// Keep scheduled and queued launches
+	var configMapQueue *launcher.ConfigMapQueue
+	switch *queueStore {
+	case "memory":
+		launcherService.Queue = launcher.NewLaunchQueue()
+	case "configmap":
+		configMapQueue = launcher.NewConfigMapQueue(launcherService.Clientset, namespace)
+		launcherService.Queue = configMapQueue
+	case "storage":
+		if storage == nil {
+			log.Fatalf("queue-store storage needs -storage")
 		}
+		launcherService.Queue = launcher.NewStorageQueue(storage)
+	default:
+		log.Fatalf("queue-store must be memory, configmap or storage")
+	}
+
+	// Find missing permissions now instead of on the first launch
+	if *permissionCheck != "off" {
+		if err := launcherService.CheckPermissions(context.Background()); err != nil && *permissionCheck == "fail" {
+			log.Fatalf("error checking permissions: %v", err)
+		} else if err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	// Start caching jobs for the read endpoints
+	if err := launcherService.StartInformers(context.Background(), tenants.Namespaces(namespace)); err != nil {
+		log.Fatalf("error starting job cache: %v", err)
+	}
+	if launcherService.Capacity != nil {
+		if err := launcherService.StartCapacityMonitor(context.Background(), tenants.Namespaces(namespace)); err != nil {
+			log.Fatalf("error starting capacity monitor: %v", err)
+		}
+	}
+	if configMapQueue != nil {
+		if err := configMapQueue.Start(context.Background()); err != nil {
+			log.Fatalf("error starting launch queue: %v", err)
+		}
+	}
+
+	// Start listening for events in every namespace we launch into
+	launcherService.StartCleanupWorkers(context.Background())
+	for _, ns := range tenants.Namespaces(namespace) {
+		go func(ns string) {
+			if err := launcherService.CleanupWatcher(context.Background(), ns); err != nil {
+				log.Fatalf("error watching for cleanup events in namespace %s: %v", ns, err)
+			}
+		}(ns)
+	}
+
+	if launcherService.Heartbeats != nil {
+		launcherService.StartHeartbeatMonitor(context.Background(), tenants.Namespaces(namespace))
+	}
+	if launcherService.RestoreWindow > 0 {
+		launcherService.StartRetirementReaper(context.Background(), tenants.Namespaces(namespace), launcherService.RestoreWindow/4)
+	}
+	launcherService.StartRetentionReaper(context.Background(), tenants.Namespaces(namespace), *retentionInterval)
+
+	if remoteProfiles != nil {
+		launcherService.StartProfileRefresh(context.Background(), *profilesRefresh)
+	}
+
+	// Start scheduled and queued launches once they are due
+	go launcherService.RunQueue(context.Background(), *queueInterval)
 
+	// Set up webserver
+	server := api.NewServer(launcherService, tenants, *allowDebug)
+	r := gin.Default()
+	r.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status": "ok",
+			"app":    "live-launcher",
 		})
 	})
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	server.RegisterGin(r.Group("/api/v1"))
 
 	log.Printf("Starting webserver")
 	r.Run()
 }
+
+// newClusterLauncherService connects to the cluster of the kubeconfig, or the
+// in-cluster config without one.
+func newClusterLauncherService(
+	kubeconfig string,
+	kubeQPS float64,
+	kubeBurst int,
+	namespace string,
+	tenants *launcher.TenantRegistry,
+	profiles map[string]*launcher.Profile,
+) *launcher.LauncherService {
+	var err error
+	var config *rest.Config
+
+	// Get the kubeconfig file path from flag, or use the in-cluster config
+	if kubeconfig == "" {
+		log.Printf("Reading in-cluster configuration because kubeconfig flag is not set")
+		config, err = rest.InClusterConfig()
+	} else {
+		log.Printf("Reading configuration from file: %s", kubeconfig)
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		panic(fmt.Errorf("error building kubeconfig: %v", err))
+	}
+
+	config.QPS = float32(kubeQPS)
+	config.Burst = kubeBurst
+	config.Wrap(launcher.WarningRecorder)
+
+	// Create the clientset
+	log.Printf("Creating clientset")
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		panic(fmt.Errorf("error building kubernetes clientset: %v", err))
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		panic(fmt.Errorf("error building kubernetes dynamic client: %v", err))
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+
+	launcherService := launcher.NewLauncherService(
+		clientset,
+		namespace,
+		tenants,
+		profiles,
+	)
+	launcherService.Dynamic = dynamicClient
+	launcherService.Mapper = mapper
+	return launcherService
+}
+
+// openSQLStorage opens the postgres storage with the lib/pq driver.
+func openSQLStorage(dsn string) (launcher.Storage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return launcher.NewSQLStorage(ctx, db, "launcher_storage")
+}
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterGin mounts the API on a gin router, e.g. a group at /api/v1.
+func (s *Server) RegisterGin(r gin.IRoutes) {
+	for _, route := range s.Routes() {
+		route := route
+		r.Handle(route.Method, route.Path, func(c *gin.Context) {
+			params := map[string]string{}
+			for _, p := range c.Params {
+				params[p.Key] = p.Value
+			}
+			route.Handler.ServeHTTP(c.Writer, WithParams(c.Request, params))
+		})
+	}
+}
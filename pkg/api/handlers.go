@@ -0,0 +1,584 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rewind-moe/launcher/pkg/launcher"
+)
+
+// query returns a query parameter, or def if it is empty.
+func query(r *http.Request, name string, def string) string {
+	if value := r.URL.Query().Get(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// scopeOf returns the tenant whose resources a read request sees, or nil for
+// every tenant's when an admin asks for ?scope=all. It responds itself and
+// returns false if the scope is not allowed.
+func scopeOf(w http.ResponseWriter, r *http.Request) (*launcher.Tenant, bool) {
+	tenant := TenantOf(r)
+	switch scope := query(r, "scope", "tenant"); scope {
+	case "tenant":
+		return tenant, true
+	case "all":
+		if tenant.Admin {
+			return nil, true
+		}
+		respond(w, r, http.StatusForbidden, map[string]any{
+			"error": "scope all requires an admin tenant",
+		})
+	default:
+		respond(w, r, http.StatusBadRequest, map[string]any{
+			"error": fmt.Sprintf("invalid scope %q, must be tenant or all", scope),
+		})
+	}
+	return nil, false
+}
+
+// scopeName is the tenant name of a scope, empty for all tenants.
+func scopeName(tenant *launcher.Tenant) string {
+	if tenant == nil {
+		return ""
+	}
+	return tenant.Name
+}
+
+func videoIdOf(r *http.Request) string {
+	return strings.Trim(param(r, "videoId"), "/")
+}
+
+// traceParentOf returns the request's W3C traceparent header, if it is valid.
+func traceParentOf(r *http.Request) string {
+	traceParent := r.Header.Get(launcher.TraceParentHeader)
+	if !launcher.ValidTraceParent(traceParent) {
+		return ""
+	}
+	return traceParent
+}
+
+// notModified sets the ETag of the response and answers with 304 Not Modified
+// if the client already has it.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		// Weak comparison, as required for If-None-Match
+		if match == "*" || strings.TrimPrefix(match, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := scopeOf(w, r)
+	if !ok {
+		return
+	}
+	etag, err := s.Launcher.ListETag(r.Context(), tenant)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
+
+	jobs, err := s.Launcher.List(r.Context(), tenant)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, map[string]any{
+		"jobs": jobs,
+	})
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := scopeOf(w, r)
+	if !ok {
+		return
+	}
+	status, err := s.Launcher.Status(r.Context(), tenant, videoIdOf(r))
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if notModified(w, r, status.ETag) {
+		return
+	}
+
+	respond(w, r, http.StatusOK, status)
+}
+
+// statuses answers for many videos at once, for clients reconciling their
+// view of every channel they watch.
+func (s *Server) statuses(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := scopeOf(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		VideoIds []string `json:"videoIds"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respond(w, r, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	statuses, err := s.Launcher.Statuses(r.Context(), tenant, body.VideoIds)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, map[string]any{
+		"statuses": statuses,
+	})
+}
+
+func (s *Server) teardown(w http.ResponseWriter, r *http.Request) {
+	purge := r.URL.Query().Get("purge") == "true"
+	result, err := s.Launcher.Teardown(r.Context(), TenantOf(r), videoIdOf(r), purge)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, result)
+}
+
+func (s *Server) launch(w http.ResponseWriter, r *http.Request) {
+	tenant := TenantOf(r)
+	debug := r.Header.Get("X-Debug") == "true"
+	if debug && !(s.AllowDebug && tenant.AllowDebug) {
+		respond(w, r, http.StatusForbidden, map[string]any{
+			"error": "debug responses are not allowed",
+		})
+		return
+	}
+
+	var at time.Time
+	if value := r.URL.Query().Get("at"); value != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, value); err != nil {
+			respondError(w, r, fmt.Errorf("%w: invalid launch time: %v", launcher.ErrInvalidRequest, err))
+			return
+		}
+	}
+
+	params, err := paramsOf(r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	q := r.URL.Query()
+	result, err := s.Launcher.Launch(r.Context(), &launcher.LaunchRequest{
+		Tenant:     tenant,
+		VideoId:    videoIdOf(r),
+		Channel:    q.Get("channel"),
+		Profile:    q.Get("profile"),
+		Idempotent: q.Get("idempotent") == "true",
+		Debug:      debug,
+		At:         at,
+		Queue:      q.Get("queue") == "true",
+		Params:     params,
+
+		TraceParent: traceParentOf(r),
+		RequestId:   requestIdOf(r),
+	})
+
+	var existsErr *launcher.LaunchExistsError
+	var shortfallErr *launcher.QuotaShortfallError
+	if errors.As(err, &existsErr) {
+		respond(w, r, http.StatusConflict, map[string]any{
+			"error":    err.Error(),
+			"existing": existsErr.Job,
+		})
+		return
+	} else if errors.As(err, &shortfallErr) {
+		respond(w, r, http.StatusTooManyRequests, map[string]any{
+			"error":     err.Error(),
+			"shortfall": shortfallErr.Shortfalls,
+		})
+		return
+	} else if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	if result.Pending != nil {
+		respond(w, r, http.StatusAccepted, map[string]any{
+			"status":  "pending",
+			"pending": result.Pending,
+		})
+		return
+	}
+
+	response := map[string]any{
+		"status":   "ok",
+		"job":      result.Job,
+		"existing": result.Existing,
+	}
+	if result.URL != "" {
+		response["url"] = result.URL
+	}
+	if len(result.Warnings) > 0 {
+		response["warnings"] = result.Warnings
+	}
+	if debug {
+		response["manifests"] = result.Manifests
+		response["timings"] = result.Timings
+	}
+	respond(w, r, http.StatusOK, response)
+}
+
+func (s *Server) extend(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Duration string `json:"duration"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respond(w, r, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	duration, err := time.ParseDuration(body.Duration)
+	if err == nil && duration <= 0 {
+		err = fmt.Errorf("must be positive")
+	}
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, map[string]any{
+			"error": fmt.Sprintf("invalid duration: %v", err),
+		})
+		return
+	}
+
+	result, err := s.Launcher.Extend(r.Context(), TenantOf(r), videoIdOf(r), duration)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, result)
+}
+
+func (s *Server) heartbeat(w http.ResponseWriter, r *http.Request) {
+	result, err := s.Launcher.Heartbeat(r.Context(), TenantOf(r), videoIdOf(r))
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, result)
+}
+
+func (s *Server) finish(w http.ResponseWriter, r *http.Request) {
+	var gracePeriod time.Duration
+	if value := r.URL.Query().Get("gracePeriod"); value != "" {
+		var err error
+		if gracePeriod, err = time.ParseDuration(value); err != nil || gracePeriod < 0 {
+			respond(w, r, http.StatusBadRequest, map[string]any{
+				"error": fmt.Sprintf("invalid gracePeriod %q", value),
+			})
+			return
+		}
+	}
+
+	result, err := s.Launcher.Finish(r.Context(), TenantOf(r), videoIdOf(r), gracePeriod)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, result)
+}
+
+func (s *Server) restore(w http.ResponseWriter, r *http.Request) {
+	if s.Launcher.RestoreWindow == 0 {
+		respond(w, r, http.StatusForbidden, map[string]any{
+			"error": "resources are deleted right away, see -restore-window",
+		})
+		return
+	}
+	result, err := s.Launcher.Restore(r.Context(), TenantOf(r), videoIdOf(r))
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, result)
+}
+
+func (s *Server) simulate(w http.ResponseWriter, r *http.Request) {
+	if !s.Launcher.Chaos {
+		respond(w, r, http.StatusForbidden, map[string]any{
+			"error": "simulations are not allowed, see -allow-chaos",
+		})
+		return
+	}
+	tenant, ok := scopeOf(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Action string `json:"action"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		respond(w, r, http.StatusBadRequest, map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := s.Launcher.Simulate(r.Context(), tenant, videoIdOf(r), body.Action)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, result)
+}
+
+// paramsOf reads the template parameters of a launch from the optional JSON
+// body, {"params": {...}}.
+func paramsOf(r *http.Request) (map[string]any, error) {
+	if r.ContentLength == 0 {
+		return nil, nil
+	}
+	var body struct {
+		Params map[string]any `json:"params"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		return nil, fmt.Errorf("%w: invalid body: %v", launcher.ErrInvalidRequest, err)
+	}
+	return body.Params, nil
+}
+
+func (s *Server) dryRun(w http.ResponseWriter, r *http.Request) {
+	params, err := paramsOf(r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	result, err := s.Launcher.DryRun(r.Context(), &launcher.LaunchRequest{
+		Tenant:  TenantOf(r),
+		VideoId: videoIdOf(r),
+		Channel: r.URL.Query().Get("channel"),
+		Profile: r.URL.Query().Get("profile"),
+		Params:  params,
+
+		TraceParent: traceParentOf(r),
+		RequestId:   requestIdOf(r),
+	})
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	// The manifests show as much as debug responses do
+	if tenant := TenantOf(r); !(s.AllowDebug && tenant.AllowDebug) {
+		result.Manifests = nil
+	}
+
+	respond(w, r, http.StatusOK, result)
+}
+
+func (s *Server) pending(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := scopeOf(w, r)
+	if !ok {
+		return
+	}
+	pending, err := s.Launcher.ListPending(tenant)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, map[string]any{
+		"pending": pending,
+	})
+}
+
+func (s *Server) cleanupHistory(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := scopeOf(w, r)
+	if !ok {
+		return
+	}
+	respond(w, r, http.StatusOK, map[string]any{
+		"actions": s.Launcher.CleanupHistory.List(scopeName(tenant), r.URL.Query().Get("videoId")),
+	})
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := scopeOf(w, r)
+	if !ok {
+		return
+	}
+	key := func(r *launcher.LaunchRecord) string { return r.VideoId }
+	switch by := query(r, "by", "video"); by {
+	case "video":
+	case "channel":
+		key = func(r *launcher.LaunchRecord) string { return r.Channel }
+	default:
+		respond(w, r, http.StatusBadRequest, map[string]any{
+			"error": fmt.Sprintf("cannot group stats by %q", by),
+		})
+		return
+	}
+
+	respond(w, r, http.StatusOK, map[string]any{
+		"stats": launcher.Stats(s.Launcher.LaunchHistory.Records(scopeName(tenant)), key),
+	})
+}
+
+// timeOf parses a query parameter as an RFC 3339 time or a date, an empty
+// parameter is the zero time. With wholeDay, a date is the end of that day,
+// so that a range up to it includes the day.
+func timeOf(r *http.Request, name string, wholeDay bool) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		if wholeDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid %s, must be a date or an RFC 3339 time: %v", launcher.ErrInvalidRequest, name, err)
+	}
+	return t, nil
+}
+
+// exportFormatOf returns the format asked for with ?format=, or else the one
+// the Accept header prefers, with the same negotiation as other endpoints,
+// and CSV by default.
+func exportFormatOf(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	if prefersYAML(r) {
+		return launcher.ExportYAML
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mime, _, _ := strings.Cut(accepted, ";")
+		if strings.TrimSpace(mime) == MIMEJSON {
+			return launcher.ExportJSON
+		}
+	}
+	return launcher.ExportCSV
+}
+
+func (s *Server) exportHistory(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := scopeOf(w, r)
+	if !ok {
+		return
+	}
+	from, err := timeOf(r, "from", false)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	to, err := timeOf(r, "to", true)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	format := exportFormatOf(r)
+	contentType := "text/csv"
+	switch format {
+	case launcher.ExportCSV:
+	case launcher.ExportJSON:
+		contentType = MIMEJSON
+	case launcher.ExportYAML:
+		contentType = MIMEYAML
+	default:
+		respondError(w, r, fmt.Errorf("%w: invalid format %q, must be csv, json or yaml", launcher.ErrInvalidRequest, format))
+		return
+	}
+
+	history := s.Launcher.LaunchHistory
+	if notModified(w, r, history.ExportETag(format, scopeName(tenant), from, to)) {
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=launches.%s", format))
+	w.WriteHeader(http.StatusOK)
+	if err := history.Export(w, format, scopeName(tenant), from, to); err != nil {
+		// The status is sent already, all we can do is stop
+		log.Printf("error exporting launch history: %v", err)
+	}
+}
+
+func (s *Server) config(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, s.Launcher.Config())
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, s.Launcher.Pause(r.URL.Query().Get("reason"), TenantOf(r).Name))
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, s.Launcher.Resume(TenantOf(r).Name))
+}
+
+func (s *Server) deliveries(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, map[string]any{
+		"circuits":    s.Launcher.Delivery.Circuits(),
+		"deadLetters": s.Launcher.Delivery.DeadLetterList(),
+	})
+}
+
+func (s *Server) profiles(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, map[string]any{
+		"profiles": s.Launcher.ProfileVersions(),
+		"rollouts": s.Launcher.Rollouts(),
+	})
+}
+
+func (s *Server) reloadProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := s.Launcher.ReloadProfiles()
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, map[string]any{
+		"profiles": profiles,
+	})
+}
+
+func (s *Server) rolloutProfile(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Version string `json:"version"`
+	}
+	if err := decodeJSON(r, &body); err != nil || body.Version == "" {
+		respondError(w, r, fmt.Errorf("%w: body must be JSON with a version", launcher.ErrInvalidRequest))
+		return
+	}
+
+	record, err := s.Launcher.RolloutProfile(param(r, "profile"), body.Version, TenantOf(r).Name)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respond(w, r, http.StatusOK, record)
+}
@@ -0,0 +1,274 @@
+// Package api serves the launcher's HTTP API as plain net/http handlers, so
+// it can be mounted on any router.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rewind-moe/launcher/pkg/launcher"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	MIMEJSON = "application/json"
+	MIMEYAML = "application/yaml"
+)
+
+type Server struct {
+	Launcher *launcher.LauncherService
+	Tenants  *launcher.TenantRegistry
+
+	// AllowDebug honors X-Debug on launches of permitted tenants
+	AllowDebug bool
+}
+
+func NewServer(launcherService *launcher.LauncherService, tenants *launcher.TenantRegistry, allowDebug bool) *Server {
+	return &Server{
+		Launcher:   launcherService,
+		Tenants:    tenants,
+		AllowDebug: allowDebug,
+	}
+}
+
+// Route is an endpoint of the API, relative to where it is mounted, e.g.
+// /api/v1. Path parameters are written as :name, and passed to the handler
+// with WithParams.
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.Handler
+}
+
+// Routes returns every endpoint of the API, with the request ID,
+// authentication and admin checks applied, for routers that register them
+// one by one.
+func (s *Server) Routes() []Route {
+	admin := func(h http.HandlerFunc) http.Handler {
+		return RequireAdmin(h)
+	}
+	routes := []Route{
+		{http.MethodGet, "/live", http.HandlerFunc(s.list)},
+		{http.MethodPost, "/live/status", http.HandlerFunc(s.statuses)},
+		{http.MethodGet, "/live/:videoId", http.HandlerFunc(s.status)},
+		{http.MethodPut, "/live/:videoId", http.HandlerFunc(s.launch)},
+		{http.MethodDelete, "/live/:videoId", http.HandlerFunc(s.teardown)},
+		{http.MethodPost, "/live/:videoId/extend", http.HandlerFunc(s.extend)},
+		{http.MethodPost, "/live/:videoId/heartbeat", http.HandlerFunc(s.heartbeat)},
+		{http.MethodPost, "/live/:videoId/finish", http.HandlerFunc(s.finish)},
+		{http.MethodPost, "/live/:videoId/simulate", admin(s.simulate)},
+		{http.MethodPost, "/live/:videoId/restore", http.HandlerFunc(s.restore)},
+		{http.MethodPost, "/live/:videoId/dryrun", http.HandlerFunc(s.dryRun)},
+		{http.MethodGet, "/pending", http.HandlerFunc(s.pending)},
+		{http.MethodGet, "/cleanup/history", http.HandlerFunc(s.cleanupHistory)},
+		{http.MethodGet, "/stats", http.HandlerFunc(s.stats)},
+		{http.MethodGet, "/history/export", http.HandlerFunc(s.exportHistory)},
+		{http.MethodGet, "/config", admin(s.config)},
+		{http.MethodPost, "/admin/pause", admin(s.pause)},
+		{http.MethodPost, "/admin/resume", admin(s.resume)},
+		{http.MethodGet, "/deliveries", admin(s.deliveries)},
+		{http.MethodGet, "/profiles", admin(s.profiles)},
+		{http.MethodPost, "/profiles/reload", admin(s.reloadProfiles)},
+		{http.MethodPost, "/profiles/:profile/rollout", admin(s.rolloutProfile)},
+	}
+	for i := range routes {
+		routes[i].Handler = RequestId(s.Authenticate(routes[i].Handler))
+	}
+	return routes
+}
+
+// Handler serves the whole API on its own, for mounting under a prefix, e.g.
+// http.StripPrefix("/api/v1", s.Handler()).
+func (s *Server) Handler() http.Handler {
+	routes := s.Routes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pathFound := false
+		for _, route := range routes {
+			params, ok := matchPath(route.Path, r.URL.Path)
+			if !ok {
+				continue
+			}
+			pathFound = true
+			if route.Method == r.Method {
+				route.Handler.ServeHTTP(w, WithParams(r, params))
+				return
+			}
+		}
+		if pathFound {
+			respond(w, r, http.StatusMethodNotAllowed, map[string]any{
+				"error": "method not allowed",
+			})
+			return
+		}
+		respond(w, r, http.StatusNotFound, map[string]any{
+			"error": "not found",
+		})
+	})
+}
+
+// matchPath matches a path against a route's path, and returns its
+// parameters.
+func matchPath(pattern string, path string) (map[string]string, bool) {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, part := range patternParts {
+		if name, ok := strings.CutPrefix(part, ":"); ok && pathParts[i] != "" {
+			params[name] = pathParts[i]
+		} else if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+type contextKey int
+
+const (
+	paramsKey contextKey = iota
+	tenantKey
+	requestIdKey
+)
+
+// WithParams passes the path parameters a router matched to the handlers of
+// Routes.
+func WithParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), paramsKey, params))
+}
+
+func param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey).(map[string]string)
+	return params[name]
+}
+
+// RequestId identifies every request by its X-Request-Id, or a new ID, and
+// echoes it back.
+func RequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(launcher.RequestIdHeader)
+		if id == "" || len(id) > 128 {
+			id = launcher.NewRequestId()
+		}
+		w.Header().Set(launcher.RequestIdHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdKey, id)))
+	})
+}
+
+func requestIdOf(r *http.Request) string {
+	id, _ := r.Context().Value(requestIdKey).(string)
+	return id
+}
+
+// Authenticate resolves the tenant of a request, see TenantOf.
+func (s *Server) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := s.Tenants.Resolve(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey, tenant)))
+	})
+}
+
+// TenantOf returns the tenant Authenticate resolved.
+func TenantOf(r *http.Request) *launcher.Tenant {
+	return r.Context().Value(tenantKey).(*launcher.Tenant)
+}
+
+// RequireAdmin only lets admin tenants through, after Authenticate.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !TenantOf(r).Admin {
+			respond(w, r, http.StatusForbidden, map[string]any{
+				"error": "this endpoint requires an admin tenant",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// prefersYAML tells whether the first type the client accepts that the API
+// serves is YAML.
+func prefersYAML(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mime, _, _ := strings.Cut(accepted, ";")
+		switch strings.TrimSpace(mime) {
+		case MIMEYAML, "application/x-yaml":
+			return true
+		case MIMEJSON, "application/*", "*/*":
+			return false
+		}
+	}
+	return false
+}
+
+// respond writes obj as YAML when the client prefers it, and as JSON
+// otherwise.
+func respond(w http.ResponseWriter, r *http.Request, code int, obj any) {
+	contentType := MIMEJSON
+	var data []byte
+	var err error
+	if prefersYAML(r) {
+		contentType = MIMEYAML
+		data, err = yaml.Marshal(obj)
+		if err != nil {
+			err = fmt.Errorf("error encoding YAML: %v", err)
+		}
+	} else {
+		data, err = json.Marshal(obj)
+		if err != nil {
+			err = fmt.Errorf("error encoding JSON: %v", err)
+		}
+	}
+	if err != nil {
+		contentType, code = MIMEJSON, http.StatusInternalServerError
+		data, _ = json.Marshal(map[string]any{"error": err.Error()})
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, launcher.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, launcher.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, launcher.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, launcher.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, launcher.ErrJobFinished), errors.Is(err, launcher.ErrNoDeadline):
+		return http.StatusConflict
+	case errors.Is(err, launcher.ErrAtCapacity), errors.Is(err, launcher.ErrPaused):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, launcher.ErrValidation):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func respondError(w http.ResponseWriter, r *http.Request, err error) {
+	respond(w, r, statusForError(err), map[string]any{
+		"error": err.Error(),
+	})
+}
+
+// decodeJSON decodes a JSON request body into v.
+func decodeJSON(r *http.Request, v any) error {
+	if r.Body == nil {
+		return fmt.Errorf("missing body")
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rewind-moe/launcher/pkg/launcher"
+	"sigs.k8s.io/yaml"
+)
+
+const testJobTemplate = `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: recorder-{{ .UniqueName }}
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: recorder
+        image: busybox
+`
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	tenants, err := launcher.NewTenantRegistry(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles := map[string]*launcher.Profile{
+		launcher.DefaultProfileName: {
+			Name: launcher.DefaultProfileName,
+			Job:  template.Must(template.New("job").Parse(testJobTemplate)),
+		},
+	}
+	return NewServer(launcher.NewFakeCluster("test", tenants, profiles), tenants, false)
+}
+
+func TestRouters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestServer(t)
+	engine := gin.New()
+	s.RegisterGin(engine.Group("/api/v1"))
+
+	for name, handler := range map[string]http.Handler{
+		"net/http": http.StripPrefix("/api/v1", s.Handler()),
+		"gin":      engine,
+	} {
+		t.Run(name, func(t *testing.T) {
+			serve := func(method string, path string, header http.Header, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, path, strings.NewReader(body))
+				for k, v := range header {
+					req.Header[k] = v
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w
+			}
+
+			videoId := "abc-" + strings.ReplaceAll(name, "/", "")
+			w := serve(http.MethodPut, "/api/v1/live/"+videoId, http.Header{launcher.RequestIdHeader: {"req-1"}}, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("launch status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get(launcher.RequestIdHeader); got != "req-1" {
+				t.Errorf("request ID = %q, want req-1", got)
+			}
+
+			w = serve(http.MethodGet, "/api/v1/live/"+videoId, http.Header{"Accept": {MIMEYAML}}, "")
+			if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), MIMEYAML) {
+				t.Errorf("status = %d %q, want 200 as YAML", w.Code, w.Header().Get("Content-Type"))
+			}
+			if !strings.Contains(w.Body.String(), "videoId: "+videoId) {
+				t.Errorf("status body = %s, want the video ID", w.Body)
+			}
+
+			w = serve(http.MethodGet, "/api/v1/live/missing", nil, "")
+			if w.Code != http.StatusNotFound {
+				t.Errorf("missing video status = %d, want 404", w.Code)
+			}
+
+			w = serve(http.MethodPost, "/api/v1/live/status", nil, `{"videoIds": ["`+videoId+`", "missing"]}`)
+			var batch struct {
+				Statuses map[string]*launcher.JobSummary `json:"statuses"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &batch); err != nil || w.Code != http.StatusOK {
+				t.Fatalf("batch status = %d %v: %s", w.Code, err, w.Body)
+			}
+			if batch.Statuses[videoId] == nil || batch.Statuses["missing"] != nil || len(batch.Statuses) != 2 {
+				t.Errorf("batch statuses = %v, want only %s found", batch.Statuses, videoId)
+			}
+		})
+	}
+}
+
+func TestDryRunManifests(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.Handler()
+
+	for _, allowDebug := range []bool{false, true} {
+		s.AllowDebug = allowDebug
+		req := httptest.NewRequest(http.MethodPost, "/live/abc/dryrun", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var result struct {
+			Manifests []json.RawMessage `json:"manifests"`
+			Valid     bool              `json:"valid"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("dry run = %d %v: %s", w.Code, err, w.Body)
+		}
+		if !result.Valid || (len(result.Manifests) > 0) != allowDebug {
+			t.Errorf("dry run with debug allowed %v = %s, want manifests only with debug", allowDebug, w.Body)
+		}
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	s := newTestServer(t)
+	history, err := launcher.NewLaunchHistory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Launcher.LaunchHistory = history
+	history.Add(&launcher.LaunchRecord{Namespace: "test", Job: "a", VideoId: "abc", Tenant: launcher.DefaultTenantName, StartedAt: time.Now().UTC(), Outcome: launcher.OutcomeRunning})
+	handler := s.Handler()
+
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/history/export", http.Header{"Accept": {MIMEYAML}})
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), MIMEYAML) {
+		t.Fatalf("export = %d %q, want 200 as YAML", w.Code, w.Header().Get("Content-Type"))
+	}
+	var exported []launcher.ExportedRecord
+	if err := yaml.Unmarshal(w.Body.Bytes(), &exported); err != nil || len(exported) != 1 || exported[0].VideoId != "abc" {
+		t.Errorf("YAML export = %+v, %v, want video abc: %s", exported, err, w.Body)
+	}
+	yamlETag := w.Header().Get("ETag")
+
+	w = serve("/history/export", http.Header{"Accept": {MIMEJSON}})
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), MIMEJSON) || w.Header().Get("ETag") == yamlETag {
+		t.Errorf("JSON export = %q with ETag %q, want JSON with another ETag than YAML", w.Header().Get("Content-Type"), w.Header().Get("ETag"))
+	}
+	if w = serve("/history/export", http.Header{"Accept": {MIMEJSON}, "If-None-Match": {yamlETag}}); w.Code != http.StatusOK {
+		t.Errorf("JSON export matching the YAML ETag = %d, want 200", w.Code)
+	}
+	if w = serve("/history/export", http.Header{"Accept": {MIMEYAML}, "If-None-Match": {yamlETag}}); w.Code != http.StatusNotModified {
+		t.Errorf("YAML export matching its ETag = %d, want 304", w.Code)
+	}
+
+	// A date as the end of the range includes that day
+	today := time.Now().UTC().Format(time.DateOnly)
+	w = serve("/history/export?format=json&from="+today+"&to="+today, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil || len(exported) != 1 {
+		t.Errorf("export of today = %s, want the record started today", w.Body)
+	}
+
+	history.Add(&launcher.LaunchRecord{Namespace: "test", Job: "b", VideoId: "def", Tenant: launcher.DefaultTenantName, StartedAt: time.Now().UTC(), Outcome: launcher.OutcomeRunning})
+	if w = serve("/history/export", http.Header{"Accept": {MIMEYAML}, "If-None-Match": {yamlETag}}); w.Code != http.StatusOK {
+		t.Errorf("YAML export after a launch = %d, want 200", w.Code)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	params, ok := matchPath("/profiles/:profile/rollout", "/profiles/default/rollout")
+	if !ok || params["profile"] != "default" {
+		t.Errorf("matchPath = %v, %v, want profile default", params, ok)
+	}
+	if _, ok := matchPath("/live/:videoId", "/live/"); ok {
+		t.Errorf("empty path parameter matches")
+	}
+	if _, ok := matchPath("/live/:videoId", "/live/abc/extend"); ok {
+		t.Errorf("longer path matches")
+	}
+}
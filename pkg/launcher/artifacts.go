@@ -0,0 +1,215 @@
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// Artifact is something a job produced, e.g. a recording in object storage.
+type Artifact struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// ArtifactRegistration describes the artifacts of a succeeded job.
+type ArtifactRegistration struct {
+	Tenant      string     `json:"tenant"`
+	VideoId     string     `json:"videoId"`
+	Channel     string     `json:"channel,omitempty"`
+	Profile     string     `json:"profile"`
+	Namespace   string     `json:"namespace"`
+	Job         string     `json:"job"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	Artifacts   []Artifact `json:"artifacts"`
+}
+
+// ArtifactRegistrar tells a catalog about the artifacts of succeeded jobs.
+// Registrations are retried, so they should be idempotent.
+type ArtifactRegistrar interface {
+	Register(ctx context.Context, reg *ArtifactRegistration) error
+}
+
+// HTTPArtifactRegistrar POSTs registrations as JSON to URL.
+type HTTPArtifactRegistrar struct {
+	URL      string
+	Token    string
+	Delivery *Delivery
+}
+
+func (r *HTTPArtifactRegistrar) Register(ctx context.Context, reg *ArtifactRegistration) error {
+	header := http.Header{}
+	if r.Token != "" {
+		header.Set("Authorization", "Bearer "+r.Token)
+	}
+	return r.Delivery.Send(ctx, &DeliveryRequest{
+		Destination: "artifacts",
+		Method:      http.MethodPost,
+		URL:         r.URL,
+		Header:      header,
+		Body:        reg,
+		DeadLetter:  true,
+	}, nil)
+}
+
+// ResourceArtifactRegistrar creates an object of Kind per registration, named
+// after the job and holding the registration as its spec, for catalogs that
+// watch the cluster.
+type ResourceArtifactRegistrar struct {
+	Dynamic dynamic.Interface
+	Mapper  meta.RESTMapper
+	Kind    schema.GroupVersionKind
+}
+
+func (r *ResourceArtifactRegistrar) Register(ctx context.Context, reg *ArtifactRegistration) error {
+	mapping, err := r.Mapper.RESTMapping(r.Kind.GroupKind(), r.Kind.Version)
+	if err != nil {
+		return fmt.Errorf("error finding resource for %s: %w", r.Kind, err)
+	}
+
+	data, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	spec := map[string]any{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	obj.SetGroupVersionKind(r.Kind)
+	obj.SetName(reg.Job)
+	SetLaunchMetadata(obj, &TemplateSpec{VideoId: reg.VideoId, Channel: reg.Channel, Tenant: reg.Tenant, Profile: reg.Profile})
+
+	_, err = r.Dynamic.Resource(mapping.Resource).Namespace(reg.Namespace).Create(ctx, obj, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// NewArtifactsFromTemplate renders the artifacts template, a YAML list of
+// Artifacts.
+func NewArtifactsFromTemplate(tmpl *template.Template, spec *TemplateSpec) ([]Artifact, error) {
+	GenTemplateSpec(spec)
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, spec); err != nil {
+		return nil, fmt.Errorf("error executing artifacts template: %w", err)
+	}
+	artifacts := []Artifact{}
+	if err := yaml.UnmarshalStrict(buf.Bytes(), &artifacts); err != nil {
+		return nil, fmt.Errorf("error parsing artifacts YAML: %w", err)
+	}
+	return artifacts, nil
+}
+
+// specFromJob rebuilds the TemplateSpec a job was rendered with.
+func (s *LauncherService) specFromJob(namespace string, job *batchv1.Job, p *Profile) (*TemplateSpec, error) {
+	spec := &TemplateSpec{
+		VideoId:        job.Labels[VideoIdLabel],
+		Channel:        job.Annotations[ChannelAnnotation],
+		Tenant:         job.Labels[TenantLabel],
+		Profile:        job.Labels[ProfileLabel],
+		Namespace:      namespace,
+		ProfileVersion: p.Version,
+		naming:         s.naming,
+		JobName:        job.Name,
+	}
+	if salt, ok := job.Annotations[NameSaltAnnotation]; ok {
+		n, err := strconv.Atoi(salt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing name salt of job %s: %w", job.Name, err)
+		}
+		spec.NameSalt = n
+	}
+	params, err := paramsFromJob(job.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("error reading params of job %s: %w", job.Name, err)
+	}
+	spec.Params = params
+	return spec, nil
+}
+
+// registerArtifacts registers the artifacts of a succeeded job once.
+func (s *LauncherService) registerArtifacts(ctx context.Context, namespace string, job *batchv1.Job) {
+	if _, ok := job.Annotations[ArtifactsAnnotation]; ok {
+		return
+	}
+	// Jobs keep getting updated until the annotation shows up
+	if _, busy := s.registeredArtifacts.LoadOrStore(job.UID, true); busy {
+		return
+	}
+	if err := s.doRegisterArtifacts(ctx, namespace, job); err != nil {
+		// Retried on the next event or relist of the job
+		log.Printf("error registering artifacts of job %s: %v", job.Name, err)
+		s.registeredArtifacts.Delete(job.UID)
+	}
+}
+
+func (s *LauncherService) doRegisterArtifacts(ctx context.Context, namespace string, job *batchv1.Job) error {
+	p, err := s.profileVersion(job.Labels[ProfileLabel], job.Annotations[ProfileVersionAnnotation])
+	if err != nil {
+		return err
+	}
+	spec, err := s.specFromJob(namespace, job, p)
+	if err != nil {
+		return err
+	}
+
+	reg := &ArtifactRegistration{
+		Tenant:    spec.Tenant,
+		VideoId:   spec.VideoId,
+		Channel:   spec.Channel,
+		Profile:   spec.Profile,
+		Namespace: namespace,
+		Job:       job.Name,
+		Artifacts: []Artifact{},
+	}
+	if job.Status.CompletionTime != nil {
+		completedAt := job.Status.CompletionTime.UTC()
+		reg.CompletedAt = &completedAt
+	}
+	if p.Artifacts != nil {
+		if reg.Artifacts, err = NewArtifactsFromTemplate(p.Artifacts, spec); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Artifacts.Register(ctx, reg); err != nil {
+		return err
+	}
+	log.Printf("registered %d artifacts of job %s", len(reg.Artifacts), job.Name)
+
+	// Remember the registration so it is not repeated
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				ArtifactsAnnotation: strconv.Itoa(len(reg.Artifacts)),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.jobClient(namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("error annotating job %s: %w", job.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package launcher
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// builtinFS holds profiles compiled into the binary, for clusters where
+// mounting templates is awkward. Images and commands are placeholders meant to
+// be replaced with an environment overlay.
+//
+//go:embed builtin
+var builtinFS embed.FS
+
+// BuiltinProfiles returns the names of the builtin profiles.
+func BuiltinProfiles() []string {
+	entries, _ := fs.ReadDir(builtinFS, "builtin")
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadBuiltinProfile loads a builtin profile under the given name.
+func LoadBuiltinProfile(builtin string, name string) (*Profile, error) {
+	if _, err := fs.Stat(builtinFS, path.Join("builtin", builtin)); err != nil {
+		return nil, fmt.Errorf("unknown builtin profile %q, available: %s", builtin, strings.Join(BuiltinProfiles(), ", "))
+	}
+	p, err := loadProfileDir(builtinFS, path.Join("builtin", builtin))
+	if err != nil {
+		return nil, fmt.Errorf("error loading builtin profile %s: %w", builtin, err)
+	}
+	p.Name = name
+	return p, nil
+}
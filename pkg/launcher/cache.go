@@ -0,0 +1,61 @@
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// StartInformers starts a cache of the launcher's jobs in every namespace, so
+// read endpoints don't hit the API server.
+func (s *LauncherService) StartInformers(ctx context.Context, namespaces []string) error {
+	listers := map[string]batchlisters.JobLister{}
+	for _, ns := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(
+			s.Clientset,
+			s.Tuning.ResyncPeriod,
+			informers.WithNamespace(ns),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.LabelSelector = ManagedLabelSelector()
+			}),
+		)
+		jobInformer := factory.Batch().V1().Jobs()
+		listers[ns] = jobInformer.Lister()
+
+		factory.Start(ctx.Done())
+		if !cache.WaitForCacheSync(ctx.Done(), jobInformer.Informer().HasSynced) {
+			return fmt.Errorf("error syncing job cache of namespace %s", ns)
+		}
+		log.Printf("Job cache of namespace %s synced", ns)
+	}
+
+	s.jobListers = listers
+	return nil
+}
+
+// listJobs lists jobs from the cache when it's running, and from the API
+// server otherwise.
+func (s *LauncherService) listJobs(ctx context.Context, namespace string, selector labels.Selector) ([]*batchv1.Job, error) {
+	if lister, ok := s.jobListers[namespace]; ok {
+		return lister.Jobs(namespace).List(selector)
+	}
+
+	list, err := s.jobClient(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*batchv1.Job, 0, len(list.Items))
+	for i := range list.Items {
+		jobs = append(jobs, &list.Items[i])
+	}
+	return jobs, nil
+}
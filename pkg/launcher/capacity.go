@@ -0,0 +1,147 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var ErrAtCapacity = errors.New("cluster at capacity")
+
+// jobNameLabel is set on pods by the job controller.
+const jobNameLabel = "job-name"
+
+// CapacityMonitor rejects launches while pods of launched jobs have been
+// unschedulable for longer than Threshold.
+type CapacityMonitor struct {
+	Threshold time.Duration
+	Interval  time.Duration
+
+	podListers map[string]corelisters.PodLister
+	atCapacity atomic.Bool
+	reason     atomic.Value
+}
+
+// StartCapacityMonitor caches the pods of jobs in every namespace and checks
+// them for unschedulable launches every Interval.
+func (s *LauncherService) StartCapacityMonitor(ctx context.Context, namespaces []string) error {
+	m := s.Capacity
+	m.podListers = map[string]corelisters.PodLister{}
+	for _, ns := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(
+			s.Clientset,
+			s.Tuning.ResyncPeriod,
+			informers.WithNamespace(ns),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.LabelSelector = jobNameLabel
+			}),
+		)
+		podInformer := factory.Core().V1().Pods()
+		m.podListers[ns] = podInformer.Lister()
+
+		factory.Start(ctx.Done())
+		if !cache.WaitForCacheSync(ctx.Done(), podInformer.Informer().HasSynced) {
+			return fmt.Errorf("error syncing pod cache of namespace %s", ns)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+		for {
+			s.checkCapacity(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// checkCapacity counts the unschedulable pods of managed jobs and flips the
+// capacity state when any have been waiting beyond the threshold.
+func (s *LauncherService) checkCapacity(ctx context.Context) {
+	m := s.Capacity
+	selector, err := labels.Parse(ManagedLabelSelector())
+	if err != nil {
+		return
+	}
+
+	var unschedulable, overdue int
+	var oldest *corev1.Pod
+	for ns, lister := range m.podListers {
+		jobs, err := s.listJobs(ctx, ns, selector)
+		if err != nil {
+			log.Printf("error listing jobs for capacity check: %v", err)
+			return
+		}
+		managed := map[string]bool{}
+		for _, job := range jobs {
+			managed[job.Name] = true
+		}
+
+		pods, err := lister.Pods(ns).List(labels.Everything())
+		if err != nil {
+			log.Printf("error listing pods for capacity check: %v", err)
+			return
+		}
+		for _, pod := range pods {
+			since, ok := unschedulableSince(pod)
+			if !ok || !managed[pod.Labels[jobNameLabel]] {
+				continue
+			}
+			unschedulable++
+			if time.Since(since) > m.Threshold {
+				overdue++
+				oldest = pod
+			}
+		}
+	}
+
+	unschedulablePods.Set(float64(unschedulable))
+	wasAtCapacity := m.atCapacity.Swap(overdue > 0)
+	if overdue > 0 {
+		atCapacity.Set(1)
+		m.reason.Store(fmt.Sprintf("%d pods unschedulable for over %s, e.g. %s/%s", overdue, m.Threshold, oldest.Namespace, oldest.Name))
+		if !wasAtCapacity {
+			log.Printf("cluster at capacity, rejecting launches: %s", m.reason.Load())
+		}
+	} else {
+		atCapacity.Set(0)
+		if wasAtCapacity {
+			log.Printf("cluster has capacity again, accepting launches")
+		}
+	}
+}
+
+func unschedulableSince(pod *corev1.Pod) (time.Time, bool) {
+	if pod.Status.Phase != corev1.PodPending {
+		return time.Time{}, false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
+			return c.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// checkCapacityLimit returns ErrAtCapacity while launches are held back.
+func (s *LauncherService) checkCapacityLimit() error {
+	if s.Capacity == nil || !s.Capacity.atCapacity.Load() {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrAtCapacity, s.Capacity.reason.Load())
+}
@@ -0,0 +1,110 @@
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Simulated outcomes of a launch, for exercising cleanup in staging.
+const (
+	SimulateSucceeded  = "succeeded"
+	SimulateFailed     = "failed"
+	SimulateDeletePods = "delete-pods"
+)
+
+type SimulationResult struct {
+	Job         *JobSummary `json:"job"`
+	Action      string      `json:"action"`
+	DeletedPods []string    `json:"deletedPods"`
+}
+
+// Simulate finishes the job of a video as if its stream ended, or deletes
+// its pods as if they were evicted, without waiting for the real thing. Jobs
+// that are marked finished have their pods deleted too, so that nothing keeps
+// recording.
+func (s *LauncherService) Simulate(ctx context.Context, tenant *Tenant, videoId string, action string) (*SimulationResult, error) {
+	var condition batchv1.JobConditionType
+	switch action {
+	case SimulateSucceeded:
+		condition = batchv1.JobComplete
+	case SimulateFailed:
+		condition = batchv1.JobFailed
+	case SimulateDeletePods:
+	default:
+		return nil, fmt.Errorf("%w: invalid action %q, must be %s, %s or %s", ErrInvalidRequest, action, SimulateSucceeded, SimulateFailed, SimulateDeletePods)
+	}
+
+	job, err := s.FindJob(ctx, tenant, videoId)
+	if err != nil {
+		return nil, err
+	}
+	if IsJobFinished(job) {
+		return nil, fmt.Errorf("%w: %s", ErrJobFinished, job.Name)
+	}
+
+	if condition != "" {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			// The cache may lag behind, so update the latest version
+			latest, err := s.jobClient(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			simulateFinish(latest, condition)
+			job, err = s.jobClient(job.Namespace).UpdateStatus(ctx, latest, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error updating status of job %s: %w", job.Name, err)
+		}
+	}
+
+	result := &SimulationResult{
+		Job:         NewJobSummary(job),
+		Action:      action,
+		DeletedPods: []string{},
+	}
+	pods := s.Clientset.CoreV1().Pods(job.Namespace)
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", jobNameLabel, job.Name)})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods of job %s: %w", job.Name, err)
+	}
+	for _, pod := range list.Items {
+		if err := pods.Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error deleting pod %s: %w", pod.Name, err)
+		}
+		result.DeletedPods = append(result.DeletedPods, pod.Name)
+	}
+
+	log.Printf("simulated %s of job %s of video %s", action, job.Name, videoId)
+	return result, nil
+}
+
+// simulateFinish sets the status the job controller sets when a job finishes.
+func simulateFinish(job *batchv1.Job, condition batchv1.JobConditionType) {
+	now := metav1.Now()
+	job.Status.Active = 0
+	if condition == batchv1.JobComplete {
+		job.Status.Succeeded = 1
+		if job.Spec.Completions != nil {
+			job.Status.Succeeded = *job.Spec.Completions
+		}
+		job.Status.CompletionTime = &now
+	} else {
+		job.Status.Failed++
+	}
+	job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+		Type:               condition,
+		Status:             corev1.ConditionTrue,
+		LastProbeTime:      now,
+		LastTransitionTime: now,
+		Reason:             "Simulated",
+		Message:            "Simulated by the launcher",
+	})
+}
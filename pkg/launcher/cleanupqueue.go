@@ -0,0 +1,75 @@
+package launcher
+
+import (
+	"context"
+	"log"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// cleanupItem is a completed job waiting to be cleaned up.
+type cleanupItem struct {
+	namespace string
+	job       *batchv1.Job
+}
+
+// StartCleanupWorkers starts Tuning.CleanupWorkers workers that clean up
+// completed jobs until ctx is done, so that a mass of jobs finishing at once
+// doesn't hold up the watchers. Failed cleanups are retried with exponential
+// backoff up to Tuning.CleanupRetries times.
+func (s *LauncherService) StartCleanupWorkers(ctx context.Context) {
+	if s.Tuning.CleanupWorkers <= 0 {
+		return
+	}
+	s.cleanupQueue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Minute))
+	go func() {
+		<-ctx.Done()
+		s.cleanupQueue.ShutDown()
+	}()
+	for i := 0; i < s.Tuning.CleanupWorkers; i++ {
+		go s.runCleanupWorker(ctx)
+	}
+}
+
+// scheduleCleanup hands a completed job to the cleanup workers, or cleans it
+// up right away without them.
+func (s *LauncherService) scheduleCleanup(ctx context.Context, namespace string, job *batchv1.Job) {
+	if s.cleanupQueue == nil {
+		if err := s.cleanup(ctx, namespace, job); err != nil {
+			log.Printf("error cleaning up job %s: %v", job.Name, err)
+			s.unmarkCleanedUp(job)
+		}
+		s.metrics.CleanedUp(job.Labels[TenantLabel])
+		return
+	}
+	cleanupBacklog.Inc()
+	s.cleanupQueue.Add(&cleanupItem{namespace: namespace, job: job})
+}
+
+func (s *LauncherService) runCleanupWorker(ctx context.Context) {
+	for {
+		obj, shutdown := s.cleanupQueue.Get()
+		if shutdown {
+			return
+		}
+		cleanupBacklog.Dec()
+		item := obj.(*cleanupItem)
+
+		err := s.cleanup(ctx, item.namespace, item.job)
+		if err != nil && ctx.Err() == nil && s.cleanupQueue.NumRequeues(item) < s.Tuning.CleanupRetries {
+			log.Printf("error cleaning up job %s, retrying: %v", item.job.Name, err)
+			cleanupBacklog.Inc()
+			s.cleanupQueue.AddRateLimited(item)
+		} else {
+			if err != nil {
+				log.Printf("error cleaning up job %s, giving up: %v", item.job.Name, err)
+				s.unmarkCleanedUp(item.job)
+			}
+			s.cleanupQueue.Forget(item)
+			s.metrics.CleanedUp(item.job.Labels[TenantLabel])
+		}
+		s.cleanupQueue.Done(item)
+	}
+}
@@ -0,0 +1,178 @@
+package launcher
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// EffectiveConfig is the configuration the launcher is running with, after
+// flags, config files and defaults are resolved.
+type EffectiveConfig struct {
+	Namespace     string               `json:"namespace"`
+	Namespaces    []string             `json:"namespaces"`
+	Profiles      []ProfileConfig      `json:"profiles"`
+	ProfileSource *ProfileSourceStatus `json:"profileSource,omitempty"`
+	Tenants       []*Tenant            `json:"tenants"`
+	CleanupPolicy string               `json:"cleanupPolicy"`
+	Environment   string               `json:"environment,omitempty"`
+	Overlays      []string             `json:"overlays,omitempty"`
+	Hostnames     HostnameConfig       `json:"hostnames"`
+	Params        map[string]any       `json:"params,omitempty"`
+	Tuning        TuningConfig         `json:"tuning"`
+	Features      FeatureFlags         `json:"features"`
+}
+
+// ProfileConfig lists the templates of a profile with their hashes, to tell
+// which version of a template was loaded.
+type ProfileConfig struct {
+	Name      string            `json:"name"`
+	Version   string            `json:"version,omitempty"`
+	Templates map[string]string `json:"templates"`
+	Settings  ProfileSettings   `json:"settings"`
+}
+
+type TuningConfig struct {
+	WatchTimeout   string `json:"watchTimeout"`
+	RelistInterval string `json:"relistInterval"`
+	ResyncPeriod   string `json:"resyncPeriod"`
+	NameHashLength int    `json:"nameHashLength"`
+	CleanupWorkers int    `json:"cleanupWorkers"`
+	CleanupRetries int    `json:"cleanupRetries"`
+
+	DeliveryAttempts   int    `json:"deliveryAttempts,omitempty"`
+	DeliveryMaxBackoff string `json:"deliveryMaxBackoff,omitempty"`
+}
+
+type FeatureFlags struct {
+	Fake                bool   `json:"fake"`
+	DryRunValidate      bool   `json:"dryRunValidate"`
+	FieldValidation     string `json:"fieldValidation"`
+	ReturnWarnings      bool   `json:"returnWarnings"`
+	Credentials         bool   `json:"credentials"`
+	Artifacts           bool   `json:"artifacts"`
+	Scheduling          bool   `json:"scheduling"`
+	SecurityDefaults    bool   `json:"securityDefaults"`
+	Chaos               bool   `json:"chaos"`
+	RestoreWindow       string `json:"restoreWindow,omitempty"`
+	IngressReadiness    string `json:"ingressReadiness,omitempty"`
+	CheckResourceQuotas bool   `json:"checkResourceQuotas"`
+	CapacityThreshold   string `json:"capacityThreshold,omitempty"`
+	HeartbeatWindow     string `json:"heartbeatWindow,omitempty"`
+	HeartbeatTeardown   bool   `json:"heartbeatTeardown,omitempty"`
+	Queue               string `json:"queue,omitempty"`
+	Storage             string `json:"storage,omitempty"`
+	Paused              bool   `json:"paused,omitempty"`
+}
+
+// Config returns the effective configuration of the launcher.
+func (s *LauncherService) Config() *EffectiveConfig {
+	config := &EffectiveConfig{
+		Namespace:     s.Namespace,
+		Namespaces:    s.Tenants.Namespaces(s.Namespace),
+		Profiles:      []ProfileConfig{},
+		Tenants:       s.Tenants.List(),
+		CleanupPolicy: funcName(s.cleanupPolicy),
+		Environment:   s.Environment,
+		Hostnames:     Hostnames,
+		Params:        s.Params,
+		Tuning: TuningConfig{
+			WatchTimeout:   s.Tuning.WatchTimeout.String(),
+			RelistInterval: s.Tuning.RelistInterval.String(),
+			ResyncPeriod:   s.Tuning.ResyncPeriod.String(),
+			NameHashLength: NameHashLength,
+			CleanupWorkers: s.Tuning.CleanupWorkers,
+			CleanupRetries: s.Tuning.CleanupRetries,
+		},
+		Features: FeatureFlags{
+			Fake:                s.Fake,
+			DryRunValidate:      s.DryRunValidate,
+			FieldValidation:     s.FieldValidation,
+			ReturnWarnings:      s.ReturnWarnings,
+			Credentials:         s.Credentials != nil,
+			Artifacts:           s.Artifacts != nil,
+			Scheduling:          s.Scheduling != nil,
+			SecurityDefaults:    s.SecurityDefaults,
+			Chaos:               s.Chaos,
+			CheckResourceQuotas: s.CheckResourceQuotas,
+			Paused:              s.Maintenance().Paused,
+		},
+	}
+	switch s.Queue.(type) {
+	case *ConfigMapQueue:
+		config.Features.Queue = "configmap"
+	case *memoryQueue:
+		config.Features.Queue = "memory"
+	case *storageQueue:
+		config.Features.Queue = "storage"
+	}
+	switch s.Storage.(type) {
+	case *BoltStorage:
+		config.Features.Storage = "bolt"
+	case *SQLStorage:
+		config.Features.Storage = "postgres"
+	case *memoryStorage:
+		config.Features.Storage = "memory"
+	}
+	if s.RemoteProfiles != nil {
+		status := s.RemoteProfiles.Status()
+		config.ProfileSource = &status
+	}
+	if s.Heartbeats != nil {
+		config.Features.HeartbeatWindow = s.Heartbeats.Window.String()
+		config.Features.HeartbeatTeardown = s.Heartbeats.Teardown
+	}
+	if s.Delivery != nil {
+		config.Tuning.DeliveryAttempts = s.Delivery.MaxAttempts
+		config.Tuning.DeliveryMaxBackoff = s.Delivery.MaxBackoff.String()
+	}
+	if s.IngressReadiness != nil {
+		config.Features.IngressReadiness = s.IngressReadiness.Timeout.String()
+	}
+	if s.RestoreWindow > 0 {
+		config.Features.RestoreWindow = s.RestoreWindow.String()
+	}
+	if s.Capacity != nil {
+		config.Features.CapacityThreshold = s.Capacity.Threshold.String()
+	}
+
+	for _, p := range s.activeProfiles() {
+		templates := map[string]string{}
+		for name, tmpl := range p.templates() {
+			if *tmpl == nil {
+				continue
+			}
+			// Profiles built in code have no source to hash
+			templates[name] = p.Hashes[name]
+		}
+		config.Profiles = append(config.Profiles, ProfileConfig{
+			Name:      p.Name,
+			Version:   p.Version,
+			Templates: templates,
+			Settings:  p.Settings,
+		})
+	}
+	sort.Slice(config.Profiles, func(i, j int) bool {
+		return config.Profiles[i].Name < config.Profiles[j].Name
+	})
+
+	for kind, overlay := range s.Overlays {
+		if overlay.StrategicMerge != nil || overlay.JSONPatch != nil {
+			config.Overlays = append(config.Overlays, kind)
+		}
+	}
+	sort.Strings(config.Overlays)
+
+	return config
+}
+
+// funcName returns the name of a function without its package, e.g.
+// CleanupOnSuccess.
+func funcName(f any) string {
+	if reflect.ValueOf(f).IsNil() {
+		return ""
+	}
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
@@ -0,0 +1,41 @@
+package launcher
+
+const (
+	VideoIdLabel = "rewind.moe/video-id"
+	TenantLabel  = "rewind.moe/tenant"
+	HookLabel    = "rewind.moe/hook"
+	ProfileLabel = "rewind.moe/profile"
+
+	CredentialsLabel = "rewind.moe/credentials"
+	RetirementLabel  = "rewind.moe/retirement"
+	RetainedLabel    = "rewind.moe/retained"
+	ConsumedLabel    = "rewind.moe/consumed"
+
+	VideoIdAnnotation         = "rewind.moe/video-id"
+	NameSaltAnnotation        = "rewind.moe/name-salt"
+	ExtensionsAnnotation      = "rewind.moe/extensions"
+	HookResourcesAnnotation   = "rewind.moe/hook-resources"
+	PostHookAnnotation        = "rewind.moe/post-hook"
+	ProgressAnnotation        = "rewind.moe/progress"
+	ProfileVersionAnnotation  = "rewind.moe/profile-version"
+	ChannelAnnotation         = "rewind.moe/channel"
+	ArtifactsAnnotation       = "rewind.moe/artifacts"
+	ParamsAnnotation          = "rewind.moe/params"
+	HeartbeatAnnotation       = "rewind.moe/last-heartbeat"
+	StaleAnnotation           = "rewind.moe/stale"
+	RetiredAnnotation         = "rewind.moe/retired-at"
+	RestoredAnnotation        = "rewind.moe/restored-at"
+	IngressReadyAnnotation    = "rewind.moe/ingress-ready"
+	TraceParentAnnotation     = "rewind.moe/traceparent"
+	RequestIdAnnotation       = "rewind.moe/request-id"
+	RetainUntilAnnotation     = "rewind.moe/retain-until"
+	FinishRequestedAnnotation = "rewind.moe/finish-requested-at"
+
+	CredentialsLeaseAnnotation = "rewind.moe/credentials-lease"
+)
+
+var (
+	DefaultLabels = map[string]string{
+		"app.kubernetes.io/managed-by": "rewind-launcher",
+	}
+)
@@ -0,0 +1,290 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Creation steps of a launch, named after what they create.
+const (
+	StepPreHooks        = "preHooks"
+	StepNetworkPolicy   = "networkPolicy"
+	StepCredentials     = "credentials"
+	StepJob             = "job"
+	StepService         = "service"
+	StepIngress         = "ingress"
+	StepHTTPRoute       = "httpRoute"
+	StepDestinationRule = "destinationRule"
+	StepVirtualService  = "virtualService"
+	StepPDB             = "pdb"
+)
+
+// CreationSteps are all creation steps in their default order, which also
+// breaks ties between steps that don't depend on each other.
+var CreationSteps = []string{
+	StepPreHooks,
+	StepNetworkPolicy,
+	StepCredentials,
+	StepJob,
+	StepService,
+	StepIngress,
+	StepHTTPRoute,
+	StepDestinationRule,
+	StepVirtualService,
+	StepPDB,
+}
+
+// CreationPollInterval is how often the readiness of a step that others wait
+// for is checked.
+var CreationPollInterval = time.Second
+
+// CreationStep declares when a step of a launch runs. Steps run after the
+// steps they come After, and once the steps they WaitFor are ready, e.g. a
+// Service has endpoints. Timeout bounds the wait together with the step.
+type CreationStep struct {
+	Step    string   `yaml:"step" json:"step"`
+	After   []string `yaml:"after" json:"after,omitempty"`
+	WaitFor []string `yaml:"waitFor" json:"waitFor,omitempty"`
+	Timeout string   `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+// CreationRollbackTimeout bounds undoing the steps of a launch that failed.
+var CreationRollbackTimeout = 30 * time.Second
+
+// creationTask creates what a step creates. Ready tells whether it is ready
+// for the steps waiting for it, steps without are ready once they ran. Undo
+// deletes what Create created, which is nothing if it only found resources
+// left over from an earlier attempt.
+type creationTask struct {
+	Create func(ctx context.Context) error
+	Ready  func(ctx context.Context) (bool, error)
+	Undo   func(ctx context.Context) error
+}
+
+func validStep(name string) bool {
+	for _, step := range CreationSteps {
+		if name == step {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateCreation(steps []CreationStep) error {
+	_, err := creationOrder(steps)
+	return err
+}
+
+// creationOrder returns every step in the order it runs in, with its declared
+// dependencies. The job always runs after the pre-launch hooks, it records
+// their resources.
+func creationOrder(declared []CreationStep) ([]CreationStep, error) {
+	steps := map[string]*CreationStep{}
+	for _, name := range CreationSteps {
+		steps[name] = &CreationStep{Step: name}
+	}
+	seen := map[string]bool{}
+	for _, step := range declared {
+		if !validStep(step.Step) {
+			return nil, fmt.Errorf("invalid creation step %q, must be one of %s", step.Step, strings.Join(CreationSteps, ", "))
+		}
+		if seen[step.Step] {
+			return nil, fmt.Errorf("creation step %s is declared twice", step.Step)
+		}
+		seen[step.Step] = true
+		for _, dep := range append(append([]string{}, step.After...), step.WaitFor...) {
+			if !validStep(dep) {
+				return nil, fmt.Errorf("creation step %s depends on invalid step %q", step.Step, dep)
+			}
+		}
+		if step.Timeout != "" {
+			if _, err := time.ParseDuration(step.Timeout); err != nil {
+				return nil, fmt.Errorf("invalid timeout of creation step %s: %w", step.Step, err)
+			}
+		}
+		step := step
+		steps[step.Step] = &step
+	}
+
+	deps := map[string]map[string]bool{}
+	for name, step := range steps {
+		deps[name] = map[string]bool{}
+		for _, dep := range append(append([]string{}, step.After...), step.WaitFor...) {
+			deps[name][dep] = true
+		}
+	}
+	deps[StepJob][StepPreHooks] = true
+
+	// Run the first step in default order whose dependencies ran
+	var order []CreationStep
+	done := map[string]bool{}
+	for len(order) < len(CreationSteps) {
+		next := ""
+		for _, name := range CreationSteps {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for dep := range deps[name] {
+				ready = ready && done[dep]
+			}
+			if ready {
+				next = name
+				break
+			}
+		}
+		if next == "" {
+			var cycle []string
+			for _, name := range CreationSteps {
+				if !done[name] {
+					cycle = append(cycle, name)
+				}
+			}
+			sort.Strings(cycle)
+			return nil, fmt.Errorf("creation steps %s depend on each other", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		order = append(order, *steps[next])
+	}
+	return order, nil
+}
+
+// runCreation runs the tasks of a launch in the order the profile declares.
+// Steps without a task are skipped, and count as ready. beforeWait, if set,
+// is called before a step waits for the readiness of others. When a step fails
+// before the job is created, the steps that ran are undone, nothing would
+// clean up after them otherwise. Once the job exists, its cleanup does.
+func runCreation(ctx context.Context, declared []CreationStep, tasks map[string]*creationTask, timer *phaseTimer, beforeWait func()) error {
+	order, err := creationOrder(declared)
+	if err != nil {
+		return err
+	}
+	var ran []*creationTask
+	jobCreated := false
+	for _, step := range order {
+		task := tasks[step.Step]
+		if task == nil {
+			continue
+		}
+		// A failing step may have created part of what it creates
+		ran = append(ran, task)
+		if err := runStep(ctx, step, task, tasks, timer, beforeWait); err != nil {
+			if !jobCreated && !errors.Is(err, errLaunchExisting) {
+				rollback(ran)
+			}
+			return err
+		}
+		jobCreated = jobCreated || step.Step == StepJob
+	}
+	return nil
+}
+
+// rollback undoes tasks, the last one first. It doesn't use the launch's
+// context, which may be what failed it.
+func rollback(tasks []*creationTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), CreationRollbackTimeout)
+	defer cancel()
+	for i := len(tasks) - 1; i >= 0; i-- {
+		if tasks[i].Undo == nil {
+			continue
+		}
+		if err := tasks[i].Undo(ctx); err != nil {
+			log.Printf("error rolling back launch: %v", err)
+		}
+	}
+}
+
+func runStep(ctx context.Context, step CreationStep, task *creationTask, tasks map[string]*creationTask, timer *phaseTimer, beforeWait func()) error {
+	if step.Timeout != "" {
+		timeout, _ := time.ParseDuration(step.Timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if len(step.WaitFor) > 0 && beforeWait != nil {
+		beforeWait()
+	}
+	for _, dep := range step.WaitFor {
+		start := time.Now()
+		err := waitReady(ctx, tasks[dep])
+		timer.observe(dep+"Ready", start)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("%s timed out waiting for %s to be ready: %w", step.Step, dep, err)
+			}
+			return fmt.Errorf("error waiting for %s to be ready: %w", dep, err)
+		}
+	}
+	defer timer.observe(step.Step, time.Now())
+	return task.Create(ctx)
+}
+
+func waitReady(ctx context.Context, task *creationTask) error {
+	if task == nil || task.Ready == nil {
+		return nil
+	}
+	ticker := time.NewTicker(CreationPollInterval)
+	defer ticker.Stop()
+	for {
+		ready, err := task.Ready(ctx)
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// jobReady tells whether a pod of the job is ready, or it succeeded already.
+func (s *LauncherService) jobReady(ctx context.Context, namespace string, name string) (bool, error) {
+	job, err := s.jobClient(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return (job.Status.Ready != nil && *job.Status.Ready > 0) || job.Status.Succeeded > 0, nil
+}
+
+// serviceReady tells whether the service has a ready endpoint.
+func (s *LauncherService) serviceReady(ctx context.Context, namespace string, name string) (bool, error) {
+	endpoints, err := s.Clientset.CoreV1().Endpoints(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ingressReady tells whether the ingress controller assigned the ingress an
+// address.
+func (s *LauncherService) ingressReady(ctx context.Context, namespace string, name string) (bool, error) {
+	ingress, err := s.ingressClient(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return len(ingress.Status.LoadBalancer.Ingress) > 0, nil
+}
@@ -0,0 +1,84 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreationOrder(t *testing.T) {
+	order, err := creationOrder([]CreationStep{
+		{Step: StepJob, After: []string{StepService}},
+		{Step: StepService, After: []string{StepCredentials}},
+		{Step: StepIngress, WaitFor: []string{StepService}},
+	})
+	if err != nil {
+		t.Fatalf("creationOrder: %v", err)
+	}
+	var names []string
+	for _, step := range order {
+		names = append(names, step.Step)
+	}
+	want := []string{StepPreHooks, StepNetworkPolicy, StepCredentials, StepService, StepJob, StepIngress, StepHTTPRoute, StepDestinationRule, StepVirtualService, StepPDB}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("creation order = %v, want %v", names, want)
+	}
+
+	for _, declared := range [][]CreationStep{
+		{{Step: StepPreHooks, After: []string{StepJob}}},
+		{{Step: "configMap"}},
+		{{Step: StepIngress, WaitFor: []string{"endpoints"}}},
+		{{Step: StepIngress, Timeout: "soon"}},
+	} {
+		if err := ValidateCreation(declared); err == nil {
+			t.Errorf("ValidateCreation(%+v) succeeded", declared)
+		}
+	}
+}
+
+func TestCreationWaitTimeout(t *testing.T) {
+	CreationPollInterval = time.Millisecond
+	defer func() { CreationPollInterval = time.Second }()
+
+	var created []string
+	task := func(name string, ready bool) *creationTask {
+		return &creationTask{
+			Create: func(ctx context.Context) error {
+				created = append(created, name)
+				return nil
+			},
+			Ready: func(ctx context.Context) (bool, error) {
+				return ready, nil
+			},
+		}
+	}
+	tasks := map[string]*creationTask{
+		StepJob:     task(StepJob, true),
+		StepService: task(StepService, false),
+		StepIngress: task(StepIngress, true),
+	}
+
+	err := runCreation(context.Background(), []CreationStep{
+		{Step: StepIngress, WaitFor: []string{StepService}, Timeout: "20ms"},
+	}, tasks, nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runCreation error = %v, want DeadlineExceeded", err)
+	}
+	if want := []string{StepJob, StepService}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created %v, want %v", created, want)
+	}
+
+	created = nil
+	tasks[StepService] = task(StepService, true)
+	err = runCreation(context.Background(), []CreationStep{
+		{Step: StepIngress, WaitFor: []string{StepService, StepJob}, Timeout: "1s"},
+	}, tasks, nil, nil)
+	if err != nil {
+		t.Errorf("runCreation: %v", err)
+	}
+	if want := []string{StepJob, StepService, StepIngress}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created %v, want %v", created, want)
+	}
+}
@@ -0,0 +1,204 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Credentials are short-lived secrets issued for a single launch.
+type Credentials struct {
+	Data map[string]string `json:"data"`
+
+	// LeaseID revokes the credentials, empty if they can't be revoked
+	LeaseID string `json:"leaseId,omitempty"`
+}
+
+// CredentialsProvider issues credentials per launch and revokes them once the
+// launch is cleaned up.
+type CredentialsProvider interface {
+	Issue(ctx context.Context, spec *TemplateSpec) (*Credentials, error)
+	Revoke(ctx context.Context, leaseID string) error
+}
+
+// HTTPCredentialsProvider POSTs the launch's video ID, channel, tenant and
+// namespace to URL and expects Credentials as JSON in return. Leases are
+// revoked with a DELETE of URL/<lease ID>.
+type HTTPCredentialsProvider struct {
+	URL      string
+	Token    string
+	Delivery *Delivery
+}
+
+func (p *HTTPCredentialsProvider) header() http.Header {
+	header := http.Header{}
+	if p.Token != "" {
+		header.Set("Authorization", "Bearer "+p.Token)
+	}
+	return header
+}
+
+func (p *HTTPCredentialsProvider) Issue(ctx context.Context, spec *TemplateSpec) (*Credentials, error) {
+	body := map[string]string{
+		"videoId":   spec.VideoId,
+		"channel":   spec.Channel,
+		"tenant":    spec.Tenant,
+		"namespace": spec.Namespace,
+	}
+	creds := &Credentials{}
+	err := p.Delivery.Send(ctx, &DeliveryRequest{
+		Destination: "credentials",
+		Method:      http.MethodPost,
+		URL:         p.URL,
+		Header:      p.header(),
+		Body:        body,
+	}, creds)
+	if err != nil {
+		return nil, fmt.Errorf("error issuing credentials: %w", err)
+	}
+	return creds, nil
+}
+
+func (p *HTTPCredentialsProvider) Revoke(ctx context.Context, leaseID string) error {
+	// Leaked leases outlive the launch, so keep the ones that can't be revoked
+	err := p.Delivery.Send(ctx, &DeliveryRequest{
+		Destination: "credentials",
+		Method:      http.MethodDelete,
+		URL:         strings.TrimSuffix(p.URL, "/") + "/" + url.PathEscape(leaseID),
+		Header:      p.header(),
+		DeadLetter:  true,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error revoking credentials: %w", err)
+	}
+	return nil
+}
+
+// VaultCredentialsProvider reads dynamic secrets from a Vault path, e.g.
+// database/creds/recorder, and revokes their leases.
+type VaultCredentialsProvider struct {
+	Addr     string
+	Token    string
+	Path     string
+	Delivery *Delivery
+}
+
+func (p *VaultCredentialsProvider) request(method string, path string, body any) *DeliveryRequest {
+	header := http.Header{}
+	header.Set("X-Vault-Token", p.Token)
+	return &DeliveryRequest{
+		Destination: "vault",
+		Method:      method,
+		URL:         strings.TrimSuffix(p.Addr, "/") + "/v1/" + strings.TrimPrefix(path, "/"),
+		Header:      header,
+		Body:        body,
+	}
+}
+
+func (p *VaultCredentialsProvider) Issue(ctx context.Context, spec *TemplateSpec) (*Credentials, error) {
+	var secret struct {
+		LeaseID string         `json:"lease_id"`
+		Data    map[string]any `json:"data"`
+	}
+	if err := p.Delivery.Send(ctx, p.request(http.MethodGet, p.Path, nil), &secret); err != nil {
+		return nil, fmt.Errorf("error reading credentials from vault: %w", err)
+	}
+
+	creds := &Credentials{Data: map[string]string{}, LeaseID: secret.LeaseID}
+	for k, v := range secret.Data {
+		if str, ok := v.(string); ok {
+			creds.Data[k] = str
+		} else {
+			creds.Data[k] = fmt.Sprint(v)
+		}
+	}
+	return creds, nil
+}
+
+func (p *VaultCredentialsProvider) Revoke(ctx context.Context, leaseID string) error {
+	req := p.request(http.MethodPut, "sys/leases/revoke", map[string]string{"lease_id": leaseID})
+	req.DeadLetter = true
+	if err := p.Delivery.Send(ctx, req, nil); err != nil {
+		return fmt.Errorf("error revoking vault lease: %w", err)
+	}
+	return nil
+}
+
+// provisionCredentials issues credentials for a launch into the secret named
+// spec.CredentialsSecret, unless it already exists for this video.
+func (s *LauncherService) provisionCredentials(ctx context.Context, spec *TemplateSpec) (*corev1.Secret, error) {
+	secrets := s.Clientset.CoreV1().Secrets(spec.Namespace)
+	if _, err := secrets.Get(ctx, spec.CredentialsSecret, metav1.GetOptions{}); err == nil {
+		return nil, nil
+	} else if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("error checking credentials secret: %w", err)
+	}
+
+	creds, err := s.Credentials.Issue(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        spec.CredentialsSecret,
+			Labels:      map[string]string{CredentialsLabel: "true"},
+			Annotations: map[string]string{CredentialsLeaseAnnotation: creds.LeaseID},
+		},
+		StringData: creds.Data,
+	}
+	SetLaunchMetadata(secret, spec)
+
+	created, err := secrets.Create(ctx, secret, s.createOptions())
+	if err != nil {
+		s.revokeCredentials(ctx, creds.LeaseID)
+		return nil, fmt.Errorf("error creating credentials secret: %w", err)
+	}
+	return created, nil
+}
+
+// deleteCredentials revokes and deletes the credentials of a job's video.
+func (s *LauncherService) deleteCredentials(ctx context.Context, namespace string, job *batchv1.Job) error {
+	selector := ManagedLabelSelector() + fmt.Sprintf(",%s=true,%s=%s", CredentialsLabel, VideoIdLabel, job.Labels[VideoIdLabel])
+	if tenant, ok := job.Labels[TenantLabel]; ok {
+		selector += fmt.Sprintf(",%s=%s", TenantLabel, tenant)
+	}
+
+	secrets := s.Clientset.CoreV1().Secrets(namespace)
+	list, err := secrets.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		log.Printf("error listing credentials of job %s: %v", job.Name, err)
+		return fmt.Errorf("error listing credentials: %w", err)
+	}
+	var errs []error
+	for _, secret := range list.Items {
+		s.revokeCredentials(ctx, secret.Annotations[CredentialsLeaseAnnotation])
+		err := secrets.Delete(ctx, secret.Name, metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			log.Printf("error deleting credentials %s: %v", secret.Name, err)
+			errs = append(errs, fmt.Errorf("error deleting credentials %s: %w", secret.Name, err))
+		}
+		s.recordCleanup(cleanupActionOf(job, CleanupDelete, "Secret", secret.Name), err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *LauncherService) revokeCredentials(ctx context.Context, leaseID string) {
+	if s.Credentials == nil || leaseID == "" {
+		return
+	}
+	if err := s.Credentials.Revoke(ctx, leaseID); err != nil {
+		log.Printf("error revoking credentials lease %s: %v", leaseID, err)
+	}
+}
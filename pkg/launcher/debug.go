@@ -0,0 +1,50 @@
+package launcher
+
+import (
+	"encoding/base64"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const RedactedValue = "REDACTED"
+
+// RedactManifests returns copies of the given objects with Secret data
+// replaced, so they can be returned to API clients. Secrets of pre-launch
+// hooks are unstructured, and are redacted too.
+func RedactManifests(objects []runtime.Object) []runtime.Object {
+	redacted := make([]runtime.Object, 0, len(objects))
+	for _, obj := range objects {
+		obj = obj.DeepCopyObject()
+		switch obj := obj.(type) {
+		case *corev1.Secret:
+			for k := range obj.Data {
+				obj.Data[k] = []byte(RedactedValue)
+			}
+			for k := range obj.StringData {
+				obj.StringData[k] = RedactedValue
+			}
+		case *unstructured.Unstructured:
+			if obj.GroupVersionKind().GroupKind() == (corev1.SchemeGroupVersion.WithKind("Secret")).GroupKind() {
+				redactUnstructuredField(obj, "data", base64.StdEncoding.EncodeToString([]byte(RedactedValue)))
+				redactUnstructuredField(obj, "stringData", RedactedValue)
+			}
+		}
+		redacted = append(redacted, obj)
+	}
+	return redacted
+}
+
+// redactUnstructuredField replaces every value of a map field of obj.
+// Malformed fields are dropped, so nothing is returned unredacted.
+func redactUnstructuredField(obj *unstructured.Unstructured, field string, value string) {
+	values, ok := obj.Object[field].(map[string]any)
+	if !ok {
+		delete(obj.Object, field)
+		return
+	}
+	for k := range values {
+		values[k] = value
+	}
+}
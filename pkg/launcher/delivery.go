@@ -0,0 +1,552 @@
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit open")
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// Delivery sends the outbound calls of every integration. Failed attempts are
+// retried with exponential backoff and full jitter, destinations that keep
+// failing are cut off by a circuit breaker for a while, and payloads that
+// can't be delivered end up in the dead letter log.
+type Delivery struct {
+	Client *http.Client
+
+	// MaxAttempts is the number of attempts per delivery, including the first
+	MaxAttempts int
+	// InitialBackoff doubles with every attempt up to MaxBackoff, the actual
+	// wait is a random duration up to it
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// FailureThreshold consecutive failed deliveries to a destination open
+	// its circuit for Cooldown, after which a single delivery probes it
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	DeadLetters *DeadLetterLog
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+func NewDelivery(deadLetters *DeadLetterLog) *Delivery {
+	return &Delivery{
+		MaxAttempts:      4,
+		InitialBackoff:   500 * time.Millisecond,
+		MaxBackoff:       10 * time.Second,
+		FailureThreshold: 5,
+		Cooldown:         time.Minute,
+		DeadLetters:      deadLetters,
+	}
+}
+
+// defaultDelivery is used by integrations without a Delivery of their own.
+var defaultDelivery = NewDelivery(nil)
+
+// DeliveryRequest is a JSON call to an external endpoint.
+type DeliveryRequest struct {
+	// Destination names the integration in metrics, circuits and dead
+	// letters, e.g. artifacts
+	Destination string
+	Method      string
+	URL         string
+	Header      http.Header
+	Body        any
+
+	// DeadLetter records the payload if it can't be delivered, for calls
+	// that are worth replaying by hand
+	DeadLetter bool
+}
+
+// DeliveryError is a response that retrying won't change.
+type DeliveryError struct {
+	Method     string
+	URL        string
+	Status     string
+	StatusCode int
+	Body       string
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("%s %s returned %s: %s", e.Method, e.URL, e.Status, e.Body)
+}
+
+// retryableStatus tells whether a response status is worth another attempt.
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
+
+// Send delivers a request and decodes the JSON response into out, unless out
+// is nil.
+func (d *Delivery) Send(ctx context.Context, req *DeliveryRequest, out any) error {
+	if d == nil {
+		d = defaultDelivery
+	}
+
+	var payload []byte
+	if req.Body != nil {
+		var err error
+		if payload, err = json.Marshal(req.Body); err != nil {
+			return err
+		}
+	}
+	target := req.URL
+	if u, err := url.Parse(req.URL); err == nil {
+		target = u.Redacted()
+	}
+
+	c := d.circuit(req)
+	if !d.allow(c, time.Now()) {
+		deliveriesTotal.WithLabelValues(req.Destination, "circuit_open").Inc()
+		err := fmt.Errorf("%s %s: %w", req.Method, target, ErrCircuitOpen)
+		d.deadLetter(req, target, payload, 0, err)
+		return err
+	}
+
+	start := time.Now()
+	attempts, err := d.attempt(ctx, req, payload, target, out)
+	deliveryDuration.WithLabelValues(req.Destination).Observe(time.Since(start).Seconds())
+
+	var deliveryErr *DeliveryError
+	if err == nil || (errors.As(err, &deliveryErr) && !retryableStatus(deliveryErr.StatusCode)) {
+		// The destination answered, whatever it thought of the request
+		d.record(c, req, true)
+	} else if ctx.Err() == nil {
+		d.record(c, req, false)
+	}
+
+	if err != nil {
+		deliveriesTotal.WithLabelValues(req.Destination, "failed").Inc()
+		d.deadLetter(req, target, payload, attempts, err)
+		return err
+	}
+	deliveriesTotal.WithLabelValues(req.Destination, "delivered").Inc()
+	return nil
+}
+
+// attempt sends the request until it succeeds, fails for good or runs out of
+// attempts, and returns the number of attempts made.
+func (d *Delivery) attempt(ctx context.Context, req *DeliveryRequest, payload []byte, target string, out any) (int, error) {
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxAttempts := d.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	backoff := d.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		deliveryAttemptsTotal.WithLabelValues(req.Destination).Inc()
+
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, body)
+		if err != nil {
+			return attempt, err
+		}
+		for k, v := range req.Header {
+			httpReq.Header[k] = v
+		}
+		if payload != nil {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+
+		retryAfter, err := d.do(client, httpReq, target, out)
+		var deliveryErr *DeliveryError
+		if err == nil {
+			return attempt, nil
+		} else if errors.As(err, &deliveryErr) && !retryableStatus(deliveryErr.StatusCode) {
+			return attempt, err
+		} else if attempt >= maxAttempts || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		wait := time.Duration(0)
+		if backoff > 0 {
+			wait = time.Duration(rand.Int63n(int64(backoff)) + 1)
+		}
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if d.MaxBackoff > 0 && wait > d.MaxBackoff {
+			wait = d.MaxBackoff
+		}
+		log.Printf("attempt %d of %s %s failed, retrying in %v: %v", attempt, req.Method, target, wait.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		}
+		if backoff *= 2; d.MaxBackoff > 0 && backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+}
+
+// do sends a single request and returns how long the destination asked to
+// wait before the next attempt, if it did.
+func (d *Delivery) do(client *http.Client, req *http.Request, target string, out any) (time.Duration, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, &DeliveryError{
+			Method:     req.Method,
+			URL:        target,
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(data)),
+		}
+	}
+	if out == nil {
+		return 0, nil
+	}
+	return 0, json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (d *Delivery) deadLetter(req *DeliveryRequest, target string, payload []byte, attempts int, err error) {
+	if !req.DeadLetter {
+		return
+	}
+	deadLettersTotal.WithLabelValues(req.Destination).Inc()
+	d.DeadLetters.Record(DeadLetter{
+		Time:        time.Now().UTC(),
+		Destination: req.Destination,
+		Method:      req.Method,
+		URL:         target,
+		Payload:     payload,
+		Attempts:    attempts,
+		Error:       err.Error(),
+	})
+}
+
+// circuit is the breaker of a destination host of an integration.
+type circuit struct {
+	destination string
+	host        string
+
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (d *Delivery) circuit(req *DeliveryRequest) *circuit {
+	host := req.URL
+	if u, err := url.Parse(req.URL); err == nil {
+		host = u.Host
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.circuits == nil {
+		d.circuits = map[string]*circuit{}
+	}
+	key := req.Destination + "/" + host
+	c, ok := d.circuits[key]
+	if !ok {
+		c = &circuit{destination: req.Destination, host: host}
+		d.circuits[key] = c
+	}
+	return c
+}
+
+func (c *circuit) state(now time.Time) string {
+	switch {
+	case c.openUntil.IsZero():
+		return CircuitClosed
+	case now.Before(c.openUntil):
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
+
+// allow tells whether a delivery may go out, letting through one probe once
+// an open circuit cools down.
+func (d *Delivery) allow(c *circuit, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch c.state(now) {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+	}
+	return true
+}
+
+func (d *Delivery) record(c *circuit, req *DeliveryRequest, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wasOpen := !c.openUntil.IsZero()
+	if ok {
+		c.failures = 0
+		c.openUntil = time.Time{}
+		c.probing = false
+		if wasOpen {
+			log.Printf("circuit of %s at %s closed", c.destination, c.host)
+			circuitOpen.WithLabelValues(c.destination).Set(0)
+		}
+		return
+	}
+
+	c.failures++
+	if c.probing || (d.FailureThreshold > 0 && c.failures >= d.FailureThreshold) {
+		c.openUntil = time.Now().Add(d.Cooldown)
+		c.probing = false
+		log.Printf("circuit of %s at %s opened for %v after %d failed deliveries", c.destination, c.host, d.Cooldown, c.failures)
+		circuitOpen.WithLabelValues(c.destination).Set(1)
+	}
+}
+
+// CircuitState is the state of a destination's circuit breaker.
+type CircuitState struct {
+	Destination string     `json:"destination"`
+	Host        string     `json:"host"`
+	State       string     `json:"state"`
+	Failures    int        `json:"failures"`
+	OpenUntil   *time.Time `json:"openUntil,omitempty"`
+}
+
+// Circuits returns the state of every destination delivered to so far.
+func (d *Delivery) Circuits() []CircuitState {
+	if d == nil {
+		d = defaultDelivery
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	states := []CircuitState{}
+	for _, c := range d.circuits {
+		state := CircuitState{
+			Destination: c.destination,
+			Host:        c.host,
+			State:       c.state(now),
+			Failures:    c.failures,
+		}
+		if !c.openUntil.IsZero() {
+			openUntil := c.openUntil.UTC()
+			state.OpenUntil = &openUntil
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Destination != states[j].Destination {
+			return states[i].Destination < states[j].Destination
+		}
+		return states[i].Host < states[j].Host
+	})
+	return states
+}
+
+// DeadLetter is a payload that couldn't be delivered. Headers aren't kept,
+// they may hold credentials.
+type DeadLetter struct {
+	Time        time.Time       `json:"time"`
+	Destination string          `json:"destination"`
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Attempts    int             `json:"attempts"`
+	Error       string          `json:"error"`
+}
+
+// DeadLetterLog keeps the most recent dead letters in memory, optionally
+// appending every dead letter to a file for replaying them later.
+type DeadLetterLog struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+	next    int
+	full    bool
+	file    *os.File
+}
+
+func NewDeadLetterLog(size int, path string) (*DeadLetterLog, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("dead letter log size must be positive")
+	}
+	l := &DeadLetterLog{
+		letters: make([]DeadLetter, size),
+	}
+	if path == "" {
+		return l, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening dead letter log %v: %w", path, err)
+	}
+	l.file = f
+	return l, nil
+}
+
+func (l *DeadLetterLog) Record(letter DeadLetter) {
+	log.Printf("dead letter for %s %s after %d attempts: %s", letter.Method, letter.URL, letter.Attempts, letter.Error)
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.letters[l.next] = letter
+	l.next = (l.next + 1) % len(l.letters)
+	if l.next == 0 {
+		l.full = true
+	}
+	if l.file != nil {
+		data, err := json.Marshal(letter)
+		if err == nil {
+			_, err = l.file.Write(append(data, '\n'))
+		}
+		if err != nil {
+			log.Printf("error persisting dead letter: %v", err)
+		}
+	}
+}
+
+// List returns the kept dead letters, newest first.
+func (l *DeadLetterLog) List() []DeadLetter {
+	if l == nil {
+		return []DeadLetter{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.letters)
+	}
+	letters := make([]DeadLetter, 0, n)
+	for i := 1; i <= n; i++ {
+		letters = append(letters, l.letters[(l.next-i+len(l.letters))%len(l.letters)])
+	}
+	return letters
+}
+
+// Forget removes the dead letters whose payload belongs to a tenant's video,
+// e.g. artifact registrations and stale alerts, from memory and the file,
+// and returns how many were removed from memory.
+func (l *DeadLetterLog) Forget(tenant string, videoId string) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.letters)
+	}
+	kept := make([]DeadLetter, 0, n)
+	for i := n; i >= 1; i-- {
+		letter := l.letters[(l.next-i+len(l.letters))%len(l.letters)]
+		if !letter.belongsTo(tenant, videoId) {
+			kept = append(kept, letter)
+		}
+	}
+	removed := n - len(kept)
+	if l.file != nil {
+		if err := l.rewrite(tenant, videoId); err != nil {
+			return removed, fmt.Errorf("error rewriting dead letter log: %w", err)
+		}
+	}
+
+	l.letters = make([]DeadLetter, len(l.letters))
+	copy(l.letters, kept)
+	l.next, l.full = len(kept)%len(l.letters), len(kept) == len(l.letters)
+	return removed, nil
+}
+
+// rewrite drops the letters of a tenant's video from the file, which keeps
+// more letters than fit in memory.
+func (l *DeadLetterLog) rewrite(tenant string, videoId string) error {
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(l.file)
+	if err != nil {
+		return err
+	}
+	var kept bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var letter DeadLetter
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(line, &letter); err == nil && letter.belongsTo(tenant, videoId) {
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = l.file.Write(kept.Bytes())
+	return err
+}
+
+// belongsTo tells whether the payload of a letter is about a tenant's video.
+func (letter *DeadLetter) belongsTo(tenant string, videoId string) bool {
+	var payload struct {
+		Tenant  string `json:"tenant"`
+		VideoId string `json:"videoId"`
+	}
+	if err := json.Unmarshal(letter.Payload, &payload); err != nil {
+		return false
+	}
+	return payload.VideoId == videoId && (payload.Tenant == "" || payload.Tenant == tenant)
+}
+
+// DeadLetterList returns the kept dead letters, newest first.
+func (d *Delivery) DeadLetterList() []DeadLetter {
+	if d == nil {
+		return []DeadLetter{}
+	}
+	return d.DeadLetters.List()
+}
@@ -0,0 +1,92 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliveryRetries(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	d := NewDelivery(nil)
+	d.InitialBackoff = time.Millisecond
+	var out struct {
+		OK bool `json:"ok"`
+	}
+	err := d.Send(context.Background(), &DeliveryRequest{Destination: "test", Method: http.MethodPost, URL: server.URL, Body: map[string]string{}}, &out)
+	if err != nil || !out.OK {
+		t.Fatalf("Send() = %v, %v, want success", err, out)
+	}
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+}
+
+func TestDeliveryCircuitAndDeadLetters(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	deadLetters, err := NewDeadLetterLog(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDelivery(deadLetters)
+	d.MaxAttempts = 1
+	d.FailureThreshold = 2
+	d.Cooldown = time.Hour
+
+	req := &DeliveryRequest{Destination: "test", Method: http.MethodPost, URL: server.URL, Body: map[string]string{"videoId": "abc"}, DeadLetter: true}
+	for i := 0; i < 3; i++ {
+		d.Send(context.Background(), req, nil)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2 before the circuit opens", requests)
+	}
+	if err := d.Send(context.Background(), req, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Send() = %v, want %v", err, ErrCircuitOpen)
+	}
+	if circuits := d.Circuits(); len(circuits) != 1 || circuits[0].State != CircuitOpen {
+		t.Errorf("Circuits() = %+v, want one open circuit", circuits)
+	}
+
+	letters := deadLetters.List()
+	if len(letters) != 4 {
+		t.Fatalf("dead letters = %d, want 4", len(letters))
+	}
+	if string(letters[0].Payload) != `{"videoId":"abc"}` {
+		t.Errorf("payload = %s, want the request body", letters[0].Payload)
+	}
+}
+
+func TestHTTPCredentialsRevoke(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+	}))
+	defer server.Close()
+
+	p := &HTTPCredentialsProvider{URL: server.URL + "/leases/", Delivery: NewDelivery(nil)}
+	if err := p.Revoke(context.Background(), "database/creds/abc?x"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if want := "/leases/database%2Fcreds%2Fabc%3Fx"; path != want {
+		t.Errorf("revoked %s, want %s", path, want)
+	}
+}
@@ -0,0 +1,143 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var ErrValidation = errors.New("validation failed")
+
+type DryRunResult struct {
+	// Manifests are left out by the API unless debug responses are allowed
+	Manifests []runtime.Object `json:"manifests,omitempty"`
+	Valid     bool             `json:"valid"`
+	Errors    []string         `json:"errors,omitempty"`
+	Warnings  []string         `json:"warnings,omitempty"`
+
+	// Params are the merged template parameters, with the layer that set
+	// each top-level key
+	Params       map[string]any    `json:"params"`
+	ParamSources map[string]string `json:"paramSources"`
+}
+
+// serverDryRun sends every manifest to the API server as a dry-run create, so
+// schema and admission webhook rejections surface before anything exists.
+func (s *LauncherService) serverDryRun(ctx context.Context, namespace string, m *Manifests) []error {
+	// A fake cluster would create the objects for real
+	if s.Fake {
+		return nil
+	}
+
+	opts := s.createOptions()
+	opts.DryRun = []string{metav1.DryRunAll}
+
+	var errs []error
+	check := func(kind string, name string, err error) {
+		// Conflicts are handled by the real create
+		if err == nil || apierrors.IsAlreadyExists(err) {
+			return
+		}
+		if apierrors.IsInvalid(err) || apierrors.IsForbidden(err) || apierrors.IsBadRequest(err) {
+			err = fmt.Errorf("%w: %s %s: %v", ErrValidation, kind, name, err)
+		} else {
+			err = fmt.Errorf("error validating %s %s: %w", kind, name, err)
+		}
+		errs = append(errs, err)
+	}
+
+	checkUnstructured := func(obj *unstructured.Unstructured) {
+		client, err := s.resourceFor(namespace, obj.GroupVersionKind())
+		if err == nil {
+			_, err = client.Create(ctx, obj, opts)
+		}
+		check(obj.GetKind(), obj.GetName(), err)
+	}
+
+	for _, obj := range m.PreHooks {
+		checkUnstructured(obj)
+	}
+	if m.NetworkPolicy != nil {
+		_, err := s.networkPolicyClient(namespace).Create(ctx, m.NetworkPolicy, opts)
+		check("network policy", m.NetworkPolicy.Name, err)
+	}
+	if m.Job != nil {
+		_, err := s.jobs.CreateJob(ctx, namespace, m.Job, opts)
+		check("job", m.Job.Name, err)
+	}
+	if m.Service != nil {
+		_, err := s.services.CreateService(ctx, namespace, m.Service, opts)
+		check("service", m.Service.Name, err)
+	}
+	if m.Ingress != nil {
+		_, err := s.ingresses.CreateIngress(ctx, namespace, m.Ingress, opts)
+		check("ingress", m.Ingress.Name, err)
+	}
+	for _, obj := range []*unstructured.Unstructured{m.HTTPRoute, m.DestinationRule, m.VirtualService} {
+		if obj != nil {
+			checkUnstructured(obj)
+		}
+	}
+	if m.PodDisruptionBudget != nil {
+		_, err := s.pdbClient(namespace).Create(ctx, m.PodDisruptionBudget, opts)
+		check("pod disruption budget", m.PodDisruptionBudget.Name, err)
+	}
+
+	return errs
+}
+
+// DryRun renders a launch and validates it against the API server without
+// creating anything.
+func (s *LauncherService) DryRun(ctx context.Context, req *LaunchRequest) (*DryRunResult, error) {
+	if req.VideoId == "" {
+		return nil, fmt.Errorf("%w: video ID cannot be empty", ErrInvalidRequest)
+	}
+	ctx, warnings := withWarningCollector(ctx)
+
+	spec := &TemplateSpec{
+		VideoId:   req.VideoId,
+		Channel:   req.Channel,
+		Tenant:    req.Tenant.Name,
+		Profile:   req.Profile,
+		Namespace: s.NamespaceFor(req.Tenant),
+
+		TraceParent: req.TraceParent,
+		RequestId:   req.RequestId,
+
+		requestParams: req.Params,
+	}
+	manifests, err := s.renderUnique(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DryRunResult{
+		Manifests: RedactManifests(manifests.Objects()),
+		Valid:     true,
+	}
+	if p, err := s.Profile(spec.Profile); err == nil {
+		result.Params, result.ParamSources = s.effectiveParams(p, spec.Tenant, req.Params)
+	}
+	if err := ValidateManifests(manifests); err != nil {
+		result.Valid = false
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, err := range errs {
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+	for _, err := range s.serverDryRun(ctx, spec.Namespace, manifests) {
+		result.Valid = false
+		result.Errors = append(result.Errors, err.Error())
+	}
+	result.Warnings = s.logWarnings(req.Tenant, req.VideoId, warnings)
+
+	return result, nil
+}
@@ -0,0 +1,40 @@
+package launcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// newETag returns a weak ETag over parts. It is weak because the same state
+// is served as JSON and YAML.
+func newETag(parts []string) string {
+	sort.Strings(parts)
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return `W/"` + hex.EncodeToString(hash[:12]) + `"`
+}
+
+func jobETagPart(job *batchv1.Job) string {
+	return fmt.Sprintf("job/%s/%s/%s", job.Namespace, job.Name, job.ResourceVersion)
+}
+
+// ListETag returns an ETag of the tenant's job list, which changes whenever a
+// job is created, updated or deleted. It is computed from the job cache when
+// it's running, without building the list.
+func (s *LauncherService) ListETag(ctx context.Context, tenant *Tenant) (string, error) {
+	jobs, err := s.scopedJobs(ctx, tenant, "")
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		parts = append(parts, jobETagPart(job))
+	}
+	return newETag(parts), nil
+}
@@ -0,0 +1,241 @@
+package launcher
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Formats of exported launch records.
+const (
+	ExportCSV  = "csv"
+	ExportJSON = "json"
+	ExportYAML = "yaml"
+)
+
+var exportColumns = []string{"tenant", "namespace", "job", "videoId", "channel", "startedAt", "finishedAt", "outcome", "durationSeconds"}
+
+// ExportedRecord is a launch record with the duration of finished launches.
+type ExportedRecord struct {
+	LaunchRecord
+	DurationSeconds *float64 `json:"durationSeconds,omitempty"`
+}
+
+// RecordsBetween returns the records of a tenant, or of all tenants for an
+// empty tenant, of launches started from from up to but excluding to, oldest
+// first. Zero times leave the range open.
+func (h *LaunchHistory) RecordsBetween(tenant string, from time.Time, to time.Time) []LaunchRecord {
+	var records []LaunchRecord
+	for _, record := range h.Records(tenant) {
+		if inRange(&record, from, to) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func inRange(record *LaunchRecord, from time.Time, to time.Time) bool {
+	return (from.IsZero() || !record.StartedAt.Before(from)) && (to.IsZero() || record.StartedAt.Before(to))
+}
+
+// exportPageSize is how many records Export copies at a time, so that slow
+// clients don't hold up writes to the history.
+const exportPageSize = 100
+
+// ExportETag returns an ETag of the export of a tenant's records started
+// between from and to in format, like RecordsBetween. It changes with the
+// format and whenever the history changes, without reading the records.
+func (h *LaunchHistory) ExportETag(format string, tenant string, from time.Time, to time.Time) string {
+	var epoch int64
+	var revision uint64
+	if h != nil {
+		h.mu.Lock()
+		if err := h.reload(context.Background()); err != nil {
+			log.Printf("%v, exporting the records of this replica", err)
+		}
+		epoch, revision = h.epoch, h.revision
+		h.mu.Unlock()
+	}
+	return newETag([]string{
+		"format/" + format,
+		"tenant/" + tenant,
+		"from/" + from.UTC().Format(time.RFC3339Nano),
+		"to/" + to.UTC().Format(time.RFC3339Nano),
+		fmt.Sprintf("revision/%d/%d", epoch, revision),
+	})
+}
+
+// Export writes a tenant's records started between from and to in format,
+// like RecordsBetween, copying and writing a page of records at a time.
+func (h *LaunchHistory) Export(w io.Writer, format string, tenant string, from time.Time, to time.Time) error {
+	out, err := newExportWriter(w, format)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return out.close()
+	}
+
+	h.mu.Lock()
+	if err := h.reload(context.Background()); err != nil {
+		log.Printf("%v, exporting the records of this replica", err)
+	}
+	var selected []*LaunchRecord
+	for _, record := range h.sorted() {
+		if (tenant == "" || record.Tenant == tenant) && inRange(record, from, to) {
+			selected = append(selected, record)
+		}
+	}
+	h.mu.Unlock()
+
+	page := make([]LaunchRecord, 0, exportPageSize)
+	for len(selected) > 0 {
+		n := len(selected)
+		if n > exportPageSize {
+			n = exportPageSize
+		}
+		// Records are updated in place when launches finish
+		page = page[:0]
+		h.mu.Lock()
+		for _, record := range selected[:n] {
+			page = append(page, *record)
+		}
+		h.mu.Unlock()
+		selected = selected[n:]
+
+		for _, record := range page {
+			if err := out.write(record); err != nil {
+				return err
+			}
+		}
+		if err := out.flush(); err != nil {
+			return err
+		}
+	}
+	return out.close()
+}
+
+// ExportRecords writes records to w as CSV with a header row, or as a JSON
+// array or a YAML sequence, one record at a time.
+func ExportRecords(w io.Writer, format string, records []LaunchRecord) error {
+	out, err := newExportWriter(w, format)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := out.write(record); err != nil {
+			return err
+		}
+	}
+	return out.close()
+}
+
+// exportWriter writes records one at a time in an export format.
+type exportWriter struct {
+	w       io.Writer
+	format  string
+	csv     *csv.Writer
+	written int
+}
+
+func newExportWriter(w io.Writer, format string) (*exportWriter, error) {
+	out := &exportWriter{w: w, format: format}
+	switch format {
+	case ExportCSV:
+		out.csv = csv.NewWriter(w)
+		return out, out.csv.Write(exportColumns)
+	case ExportJSON:
+		_, err := io.WriteString(w, "[")
+		return out, err
+	case ExportYAML:
+		return out, nil
+	default:
+		return nil, fmt.Errorf("%w: invalid export format %q, must be %s, %s or %s", ErrInvalidRequest, format, ExportCSV, ExportJSON, ExportYAML)
+	}
+}
+
+func (out *exportWriter) write(record LaunchRecord) error {
+	exported := exportRecord(record)
+	defer func() { out.written++ }()
+
+	switch out.format {
+	case ExportCSV:
+		var finishedAt, duration string
+		if record.FinishedAt != nil {
+			finishedAt = record.FinishedAt.UTC().Format(time.RFC3339)
+			duration = strconv.FormatFloat(*exported.DurationSeconds, 'f', -1, 64)
+		}
+		return out.csv.Write([]string{
+			record.Tenant,
+			record.Namespace,
+			record.Job,
+			record.VideoId,
+			record.Channel,
+			record.StartedAt.UTC().Format(time.RFC3339),
+			finishedAt,
+			record.Outcome,
+			duration,
+		})
+	case ExportJSON:
+		if out.written > 0 {
+			if _, err := io.WriteString(out.w, ","); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(exported)
+		if err != nil {
+			return err
+		}
+		_, err = out.w.Write(data)
+		return err
+	default:
+		// A sequence of one, to get the item's indentation
+		data, err := yaml.Marshal([]*ExportedRecord{exported})
+		if err != nil {
+			return err
+		}
+		_, err = out.w.Write(data)
+		return err
+	}
+}
+
+// flush sends buffered CSV rows on.
+func (out *exportWriter) flush() error {
+	if out.csv == nil {
+		return nil
+	}
+	out.csv.Flush()
+	return out.csv.Error()
+}
+
+func (out *exportWriter) close() error {
+	switch out.format {
+	case ExportJSON:
+		_, err := io.WriteString(out.w, "]\n")
+		return err
+	case ExportYAML:
+		if out.written == 0 {
+			_, err := io.WriteString(out.w, "[]\n")
+			return err
+		}
+		return nil
+	default:
+		return out.flush()
+	}
+}
+
+func exportRecord(record LaunchRecord) *ExportedRecord {
+	exported := &ExportedRecord{LaunchRecord: record}
+	if record.FinishedAt != nil {
+		seconds := record.FinishedAt.Sub(record.StartedAt).Seconds()
+		exported.DurationSeconds = &seconds
+	}
+	return exported
+}
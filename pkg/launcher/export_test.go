@@ -0,0 +1,63 @@
+package launcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExportRecords(t *testing.T) {
+	h, err := NewLaunchHistory("")
+	if err != nil {
+		t.Fatalf("NewLaunchHistory: %v", err)
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	finish := start.Add(90 * time.Minute)
+	h.Add(&LaunchRecord{Namespace: "test", Job: "a", VideoId: "abc", Channel: "ch", Tenant: "default", StartedAt: start, FinishedAt: &finish, Outcome: OutcomeSucceeded})
+	h.Add(&LaunchRecord{Namespace: "test", Job: "b", VideoId: "def", Tenant: "default", StartedAt: start.AddDate(0, 1, 0), Outcome: OutcomeRunning})
+
+	records := h.RecordsBetween("", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC))
+	if len(records) != 1 || records[0].Job != "a" {
+		t.Fatalf("RecordsBetween = %+v, want only job a", records)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportRecords(&buf, ExportCSV, records); err != nil {
+		t.Fatalf("ExportRecords csv: %v", err)
+	}
+	want := "tenant,namespace,job,videoId,channel,startedAt,finishedAt,outcome,durationSeconds\n" +
+		"default,test,a,abc,ch,2024-03-01T12:00:00Z,2024-03-01T13:30:00Z,succeeded,5400\n"
+	if buf.String() != want {
+		t.Errorf("csv export = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := ExportRecords(&buf, ExportJSON, h.Records("")); err != nil {
+		t.Fatalf("ExportRecords json: %v", err)
+	}
+	var exported []ExportedRecord
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatalf("invalid json export %q: %v", buf.String(), err)
+	}
+	if len(exported) != 2 || *exported[0].DurationSeconds != 5400 || exported[1].DurationSeconds != nil {
+		t.Errorf("unexpected json export %s", buf.String())
+	}
+
+	// Export writes the same in pages
+	for i := 0; i < exportPageSize; i++ {
+		h.Add(&LaunchRecord{Namespace: "test", Job: fmt.Sprintf("c%d", i), VideoId: "ghi", Tenant: "default", StartedAt: start.Add(time.Duration(i) * time.Second), Outcome: OutcomeRunning})
+	}
+	var exportedAll bytes.Buffer
+	buf.Reset()
+	if err := ExportRecords(&buf, ExportCSV, h.Records("")); err != nil {
+		t.Fatalf("ExportRecords csv: %v", err)
+	}
+	if err := h.Export(&exportedAll, ExportCSV, "", time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if exportedAll.String() != buf.String() {
+		t.Errorf("Export = %q, want %q", exportedAll.String(), buf.String())
+	}
+}
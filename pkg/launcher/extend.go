@@ -0,0 +1,141 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+)
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrJobFinished = errors.New("job has already finished")
+	ErrNoDeadline  = errors.New("job has no activeDeadlineSeconds")
+
+	ErrInvalidRequest = errors.New("invalid request")
+)
+
+type Extension struct {
+	Time     time.Time `json:"time"`
+	Tenant   string    `json:"tenant"`
+	Seconds  int64     `json:"seconds"`
+	Deadline int64     `json:"activeDeadlineSeconds"`
+}
+
+type ExtendResult struct {
+	Job        *JobSummary  `json:"job"`
+	Deadline   *metav1.Time `json:"deadline,omitempty"`
+	Extensions []Extension  `json:"extensions"`
+}
+
+// FindJob returns the job launched for a video by the given tenant, or by any
+// tenant for a nil tenant.
+func (s *LauncherService) FindJob(ctx context.Context, tenant *Tenant, videoId string) (*batchv1.Job, error) {
+	if _, err := labels.Parse(fmt.Sprintf("%s=%s", VideoIdLabel, videoId)); err != nil {
+		return nil, fmt.Errorf("%w: invalid video ID %q", ErrNotFound, videoId)
+	}
+	jobs, err := s.scopedJobs(ctx, tenant, fmt.Sprintf(",%s=%s", VideoIdLabel, videoId))
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%w: no job for video %s", ErrNotFound, videoId)
+	}
+
+	// Prefer the most recently created job
+	job := jobs[0]
+	for _, j := range jobs {
+		if j.CreationTimestamp.After(job.CreationTimestamp.Time) {
+			job = j
+		}
+	}
+	return job, nil
+}
+
+func JobExtensions(job *batchv1.Job) ([]Extension, error) {
+	var extensions []Extension
+	if data, ok := job.Annotations[ExtensionsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &extensions); err != nil {
+			return nil, fmt.Errorf("error parsing extensions of job %s: %w", job.Name, err)
+		}
+	}
+	return extensions, nil
+}
+
+// Extend pushes out the activeDeadlineSeconds of a running job and records
+// the extension in an annotation on the job.
+func (s *LauncherService) Extend(ctx context.Context, tenant *Tenant, videoId string, duration time.Duration) (*ExtendResult, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+
+	var result *ExtendResult
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		job, err := s.FindJob(ctx, tenant, videoId)
+		if err != nil {
+			return err
+		}
+		// The cache may lag behind, so update the latest version
+		if job, err = s.jobClient(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{}); err != nil {
+			return err
+		}
+		if IsJobFinished(job) {
+			return fmt.Errorf("%w: %s", ErrJobFinished, job.Name)
+		}
+		if job.Spec.ActiveDeadlineSeconds == nil {
+			return fmt.Errorf("%w: %s", ErrNoDeadline, job.Name)
+		}
+
+		extensions, err := JobExtensions(job)
+		if err != nil {
+			return err
+		}
+
+		seconds := int64(duration.Seconds())
+		deadline := *job.Spec.ActiveDeadlineSeconds + seconds
+		extensions = append(extensions, Extension{
+			Time:     time.Now().UTC(),
+			Tenant:   tenant.Name,
+			Seconds:  seconds,
+			Deadline: deadline,
+		})
+		data, err := json.Marshal(extensions)
+		if err != nil {
+			return err
+		}
+
+		job.Spec.ActiveDeadlineSeconds = &deadline
+		if job.Annotations == nil {
+			job.Annotations = map[string]string{}
+		}
+		job.Annotations[ExtensionsAnnotation] = string(data)
+
+		job, err = s.jobClient(job.Namespace).Update(ctx, job, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+
+		result = &ExtendResult{
+			Job:        NewJobSummary(job),
+			Extensions: extensions,
+		}
+		if job.Status.StartTime != nil {
+			t := metav1.NewTime(job.Status.StartTime.Add(time.Duration(deadline) * time.Second))
+			result.Deadline = &t
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("extended job %s of video %s by %v for tenant %s", result.Job.Name, videoId, duration, tenant.Name)
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package launcher
+
+import (
+	"log"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeCustomKinds are the custom resources templates may render, which the
+// fake cluster serves in addition to the built-in kinds.
+var fakeCustomKinds = []schema.GroupVersionKind{
+	HTTPRouteGroupKind.WithVersion("v1beta1"),
+	VirtualServiceGroupKind.WithVersion("v1beta1"),
+	DestinationRuleGroupKind.WithVersion("v1beta1"),
+}
+
+// NewFakeCluster returns a launcher service backed by an in-memory cluster,
+// for local development and tests. Created jobs are started but never
+// finish on their own.
+func NewFakeCluster(namespace string, tenants *TenantRegistry, profiles map[string]*Profile, opts ...Option) *LauncherService {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.UID = uuid.NewUUID()
+		now := metav1.NewTime(time.Now())
+		job.Status.StartTime = &now
+		job.Status.Active = 1
+		return false, nil, nil
+	})
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	listKinds := map[schema.GroupVersionResource]string{}
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	for _, gvk := range fakeCustomKinds {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			log.Fatalf("error mapping fake kind %s: %v", gvk, err)
+		}
+		listKinds[mapping.Resource] = gvk.Kind + "List"
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+
+	s := NewLauncherService(clientset, namespace, tenants, profiles, opts...)
+	s.Dynamic = dynamicClient
+	s.Mapper = mapper
+	s.Fake = true
+	return s
+}
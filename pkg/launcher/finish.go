@@ -0,0 +1,95 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type FinishResult struct {
+	Job         *JobSummary `json:"job"`
+	RequestedAt time.Time   `json:"requestedAt"`
+
+	// Pods were annotated, and Signalled sent SIGTERM by deleting them with
+	// the grace period
+	Pods      []string `json:"pods"`
+	Signalled []string `json:"signalled,omitempty"`
+}
+
+// Finish asks the recording of a video to wrap up, by annotating its job and
+// running pods with the time it was asked, so a recorder watching its
+// annotations through the downward API can stop on its own. With a positive
+// gracePeriod, the pods are also deleted with that grace period, which sends
+// them SIGTERM. Either way, the job is left to complete and be cleaned up as
+// usual, with its post-launch hook.
+func (s *LauncherService) Finish(ctx context.Context, tenant *Tenant, videoId string, gracePeriod time.Duration) (*FinishResult, error) {
+	if gracePeriod < 0 {
+		return nil, fmt.Errorf("%w: grace period must not be negative", ErrInvalidRequest)
+	}
+	job, err := s.FindJob(ctx, tenant, videoId)
+	if err != nil {
+		return nil, err
+	}
+	if IsJobFinished(job) {
+		return nil, fmt.Errorf("%w: %s", ErrJobFinished, job.Name)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				FinishRequestedAnnotation: now.Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	patched, err := s.jobClient(job.Namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error annotating job %s: %w", job.Name, err)
+	}
+	job = patched
+
+	result := &FinishResult{
+		Job:         NewJobSummary(job),
+		RequestedAt: now,
+		Pods:        []string{},
+	}
+	pods := s.Clientset.CoreV1().Pods(job.Namespace)
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", jobNameLabel, job.Name)})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods of job %s: %w", job.Name, err)
+	}
+	for _, pod := range list.Items {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		_, err := pods.Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error annotating pod %s: %w", pod.Name, err)
+		}
+		result.Pods = append(result.Pods, pod.Name)
+
+		if gracePeriod > 0 {
+			seconds := int64(gracePeriod.Seconds())
+			err := pods.Delete(ctx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &seconds})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("error signalling pod %s: %w", pod.Name, err)
+			}
+			result.Signalled = append(result.Signalled, pod.Name)
+		}
+	}
+
+	log.Printf("asked job %s of video %s to finish for tenant %s, signalled %d pods", job.Name, videoId, tenant.Name, len(result.Signalled))
+	return result, nil
+}
@@ -0,0 +1,212 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// HeartbeatResolution is how often heartbeats are written to a job, more
+// frequent heartbeats are acknowledged without touching the job.
+var HeartbeatResolution = 10 * time.Second
+
+// HeartbeatMonitor marks launches stale whose job is still active but hasn't
+// sent a heartbeat for Window, counting from the job's start until the first
+// heartbeat, e.g. because the stream it records silently died.
+type HeartbeatMonitor struct {
+	Window   time.Duration
+	Interval time.Duration
+
+	// Teardown deletes stale launches instead of only marking them
+	Teardown bool
+
+	// AlertURL is sent a StaleAlert for every launch that turns stale, when set
+	AlertURL   string
+	AlertToken string
+	Delivery   *Delivery
+}
+
+// StaleAlert is sent when a launch turns stale.
+type StaleAlert struct {
+	Tenant        string    `json:"tenant"`
+	VideoId       string    `json:"videoId"`
+	Channel       string    `json:"channel,omitempty"`
+	Namespace     string    `json:"namespace"`
+	Job           string    `json:"job"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	TornDown      bool      `json:"tornDown"`
+}
+
+type HeartbeatResult struct {
+	Job           *JobSummary `json:"job"`
+	LastHeartbeat time.Time   `json:"lastHeartbeat"`
+}
+
+// lastHeartbeat returns when the job last sent a heartbeat, or started if it
+// never did.
+func lastHeartbeat(job *batchv1.Job) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, job.Annotations[HeartbeatAnnotation]); err == nil {
+		return t, true
+	}
+	if job.Status.StartTime != nil {
+		return job.Status.StartTime.Time, false
+	}
+	return job.CreationTimestamp.Time, false
+}
+
+// Heartbeat records that the launch of a video is alive, clearing its stale
+// mark.
+func (s *LauncherService) Heartbeat(ctx context.Context, tenant *Tenant, videoId string) (*HeartbeatResult, error) {
+	job, err := s.FindJob(ctx, tenant, videoId)
+	if err != nil {
+		return nil, err
+	}
+	if IsJobFinished(job) {
+		return nil, fmt.Errorf("%w: %s", ErrJobFinished, job.Name)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	last, ok := lastHeartbeat(job)
+	_, stale := job.Annotations[StaleAnnotation]
+	if ok && !stale && now.Sub(last) < HeartbeatResolution {
+		return &HeartbeatResult{Job: NewJobSummary(job), LastHeartbeat: last}, nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				HeartbeatAnnotation: now.Format(time.RFC3339),
+				StaleAnnotation:     nil,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	job, err = s.jobClient(job.Namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error recording heartbeat of job %s: %w", job.Name, err)
+	}
+	if stale {
+		log.Printf("launch of video %s of tenant %s is alive again", videoId, tenant.Name)
+	}
+	return &HeartbeatResult{Job: NewJobSummary(job), LastHeartbeat: now}, nil
+}
+
+// StartHeartbeatMonitor checks the active jobs in every namespace for missing
+// heartbeats every Interval.
+func (s *LauncherService) StartHeartbeatMonitor(ctx context.Context, namespaces []string) {
+	go func() {
+		ticker := time.NewTicker(s.Heartbeats.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.checkHeartbeats(ctx, namespaces)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *LauncherService) checkHeartbeats(ctx context.Context, namespaces []string) {
+	selector, err := labels.Parse(ManagedLabelSelector())
+	if err != nil {
+		log.Printf("error checking heartbeats: %v", err)
+		return
+	}
+
+	now := time.Now()
+	count := 0
+	for _, ns := range namespaces {
+		jobs, err := s.listJobs(ctx, ns, selector)
+		if err != nil {
+			log.Printf("error listing jobs of namespace %s for heartbeats: %v", ns, err)
+			continue
+		}
+		for _, job := range jobs {
+			if _, ok := job.Labels[HookLabel]; ok || IsJobFinished(job) {
+				continue
+			}
+			last, _ := lastHeartbeat(job)
+			if now.Sub(last) < s.Heartbeats.Window {
+				continue
+			}
+			count++
+			if _, ok := job.Annotations[StaleAnnotation]; ok {
+				continue
+			}
+			if err := s.markStale(ctx, job, last); err != nil {
+				log.Printf("error marking job %s stale: %v", job.Name, err)
+			}
+		}
+	}
+	staleLaunches.Set(float64(count))
+}
+
+// markStale annotates a job whose heartbeats stopped, alerts and, if
+// configured, tears the launch down.
+func (s *LauncherService) markStale(ctx context.Context, job *batchv1.Job, last time.Time) error {
+	videoId := job.Labels[VideoIdLabel]
+	log.Printf("launch of video %s of tenant %s is stale, no heartbeat since %s", videoId, job.Labels[TenantLabel], last.UTC().Format(time.RFC3339))
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				StaleAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.jobClient(job.Namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+
+	alert := &StaleAlert{
+		Tenant:        job.Labels[TenantLabel],
+		VideoId:       videoId,
+		Channel:       job.Annotations[ChannelAnnotation],
+		Namespace:     job.Namespace,
+		Job:           job.Name,
+		LastHeartbeat: last.UTC(),
+	}
+	if s.Heartbeats.Teardown {
+		if tenant, ok := s.Tenants.Tenant(alert.Tenant); !ok {
+			log.Printf("not tearing down stale job %s of unknown tenant %s", job.Name, alert.Tenant)
+		} else if _, err := s.Teardown(ctx, tenant, videoId, false); err != nil {
+			log.Printf("error tearing down stale job %s: %v", job.Name, err)
+		} else {
+			alert.TornDown = true
+		}
+	}
+
+	if s.Heartbeats.AlertURL != "" {
+		header := http.Header{}
+		if s.Heartbeats.AlertToken != "" {
+			header.Set("Authorization", "Bearer "+s.Heartbeats.AlertToken)
+		}
+		err := s.Heartbeats.Delivery.Send(ctx, &DeliveryRequest{
+			Destination: "alerts",
+			Method:      http.MethodPost,
+			URL:         s.Heartbeats.AlertURL,
+			Header:      header,
+			Body:        alert,
+			DeadLetter:  true,
+		}, nil)
+		if err != nil {
+			log.Printf("error sending stale alert for job %s: %v", job.Name, err)
+		}
+	}
+	return nil
+}
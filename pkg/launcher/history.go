@@ -0,0 +1,317 @@
+package launcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Actions taken on the resources of a job, deletion unless stated otherwise.
+const (
+	CleanupDelete  = "delete"
+	CleanupRetire  = "retire"
+	CleanupRestore = "restore"
+	CleanupRetain  = "retain"
+)
+
+type CleanupAction struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Tenant    string    `json:"tenant"`
+	VideoId   string    `json:"videoId"`
+	Job       string    `json:"job"`
+	Action    string    `json:"action,omitempty"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// CleanupHistory keeps the most recent cleanup actions in a ring buffer,
+// optionally appending them to a file that is read back on startup, or
+// storing them in a Storage. With a Storage, actions are listed from it, so
+// replicas sharing it see each other's.
+type CleanupHistory struct {
+	mu      sync.Mutex
+	actions []CleanupAction
+	next    int
+	full    bool
+	file    *os.File
+
+	// store keeps the actions under keys, which are kept next to the actions
+	// to delete them once they drop out of the ring buffer
+	store Storage
+	keys  []string
+}
+
+// NewStorageCleanupHistory reads back the most recent actions of a Storage
+// and stores every new one in it, deleting those that no longer fit.
+func NewStorageCleanupHistory(ctx context.Context, size int, store Storage) (*CleanupHistory, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("cleanup history size must be positive")
+	}
+	h := &CleanupHistory{
+		actions: make([]CleanupAction, size),
+		store:   store,
+		keys:    make([]string, size),
+	}
+	entries, err := store.List(ctx, CollectionCleanups)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cleanup history: %w", err)
+	}
+	for _, entry := range entries {
+		var action CleanupAction
+		if err := json.Unmarshal(entry.Value, &action); err != nil {
+			log.Printf("skipping invalid cleanup action %s: %v", entry.Key, err)
+			continue
+		}
+		h.add(action, entry.Key)
+	}
+	return h, nil
+}
+
+func NewCleanupHistory(size int, path string) (*CleanupHistory, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("cleanup history size must be positive")
+	}
+	h := &CleanupHistory{
+		actions: make([]CleanupAction, size),
+	}
+	if path == "" {
+		return h, nil
+	}
+
+	// Read back persisted actions
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var action CleanupAction
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				log.Printf("skipping invalid cleanup history line: %v", err)
+				continue
+			}
+			h.add(action, "")
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading cleanup history %v: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading cleanup history %v: %w", path, err)
+	}
+
+	// Rewrite the file with only the kept actions, so it doesn't grow forever
+	f, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening cleanup history %v: %w", path, err)
+	}
+	h.file = f
+	if err := h.rewrite(); err != nil {
+		return nil, fmt.Errorf("error writing cleanup history %v: %w", path, err)
+	}
+
+	return h, nil
+}
+
+// rewrite replaces the file contents with the kept actions, oldest first.
+func (h *CleanupHistory) rewrite() error {
+	if err := h.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := h.file.Seek(0, 0); err != nil {
+		return err
+	}
+	kept := h.list("", "")
+	for i := len(kept) - 1; i >= 0; i-- {
+		data, err := json.Marshal(kept[i])
+		if err != nil {
+			return err
+		}
+		if _, err := h.file.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add keeps an action, dropping the oldest one once the buffer is full. Stored
+// actions are deleted when they are dropped.
+func (h *CleanupHistory) add(action CleanupAction, key string) {
+	if h.keys != nil {
+		if dropped := h.keys[h.next]; dropped != "" && h.store != nil {
+			if err := h.store.Delete(context.Background(), CollectionCleanups, dropped); err != nil {
+				log.Printf("error deleting cleanup action: %v", err)
+			}
+		}
+		h.keys[h.next] = key
+	}
+	h.actions[h.next] = action
+	h.next = (h.next + 1) % len(h.actions)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *CleanupHistory) Record(action CleanupAction) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := ""
+	if h.store != nil {
+		key = orderedKey(action.Time)
+		if err := h.store.Put(context.Background(), CollectionCleanups, key, action); err != nil {
+			log.Printf("error storing cleanup action: %v", err)
+		}
+	}
+	h.add(action, key)
+	if h.file != nil {
+		data, err := json.Marshal(action)
+		if err == nil {
+			_, err = h.file.Write(append(data, '\n'))
+		}
+		if err != nil {
+			log.Printf("error persisting cleanup action: %v", err)
+		}
+	}
+}
+
+// List returns the recorded actions of a tenant, newest first. An empty
+// tenant or videoId returns actions of all tenants or videos.
+func (h *CleanupHistory) List(tenant string, videoId string) []CleanupAction {
+	if h == nil {
+		return []CleanupAction{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.store != nil {
+		actions, _, err := h.stored(context.Background(), tenant, videoId, len(h.actions))
+		if err == nil {
+			return actions
+		}
+		log.Printf("%v, listing the actions of this replica", err)
+	}
+	return h.list(tenant, videoId)
+}
+
+// stored returns the stored actions of a tenant's video and their keys,
+// newest first like list, among the limit most recent ones unless limit is
+// zero. Every replica stores as many actions as fit in its ring buffer.
+func (h *CleanupHistory) stored(ctx context.Context, tenant string, videoId string, limit int) ([]CleanupAction, []string, error) {
+	entries, err := h.store.List(ctx, CollectionCleanups)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading cleanup history: %w", err)
+	}
+	actions, keys := []CleanupAction{}, []string{}
+	for i := len(entries) - 1; i >= 0 && (limit <= 0 || len(entries)-i <= limit); i-- {
+		var action CleanupAction
+		if err := json.Unmarshal(entries[i].Value, &action); err != nil {
+			continue
+		}
+		if (tenant == "" || action.Tenant == tenant) && (videoId == "" || action.VideoId == videoId) {
+			actions = append(actions, action)
+			keys = append(keys, entries[i].Key)
+		}
+	}
+	return actions, keys, nil
+}
+
+func (h *CleanupHistory) list(tenant string, videoId string) []CleanupAction {
+	actions := []CleanupAction{}
+	n := h.next
+	if h.full {
+		n = len(h.actions)
+	}
+	for i := 1; i <= n; i++ {
+		action := h.actions[(h.next-i+len(h.actions))%len(h.actions)]
+		if (tenant == "" || action.Tenant == tenant) && (videoId == "" || action.VideoId == videoId) {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// listKeys returns the keys of the stored actions, newest first like list.
+func (h *CleanupHistory) listKeys() []string {
+	if h.store == nil {
+		return nil
+	}
+	keys := []string{}
+	n := h.next
+	if h.full {
+		n = len(h.keys)
+	}
+	for i := 1; i <= n; i++ {
+		keys = append(keys, h.keys[(h.next-i+len(h.keys))%len(h.keys)])
+	}
+	return keys
+}
+
+// Forget removes the actions of a tenant's video and returns how many were
+// removed. The kept actions are only swapped in once the removed ones are
+// deleted from the store, so on error the history is left as it was and
+// Forget can be retried.
+func (h *CleanupHistory) Forget(tenant string, videoId string) (int, error) {
+	if h == nil {
+		return 0, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// A ring without store to build the kept actions in
+	all, keys := h.list("", ""), h.listKeys()
+	kept := &CleanupHistory{actions: make([]CleanupAction, len(h.actions))}
+	if h.store != nil {
+		kept.keys = make([]string, len(h.keys))
+	}
+
+	var forgotten []string
+	for i := len(all) - 1; i >= 0; i-- {
+		key := ""
+		if h.store != nil {
+			key = keys[i]
+		}
+		if all[i].Tenant == tenant && all[i].VideoId == videoId {
+			forgotten = append(forgotten, key)
+			continue
+		}
+		kept.add(all[i], key)
+	}
+	if h.store != nil {
+		// Actions of other replicas are only in the store
+		_, storedKeys, err := h.stored(context.Background(), tenant, videoId, 0)
+		if err != nil {
+			return 0, err
+		}
+		forgotten = storedKeys
+	}
+	if len(forgotten) == 0 {
+		return 0, nil
+	}
+
+	if h.store != nil {
+		for _, key := range forgotten {
+			if err := h.store.Delete(context.Background(), CollectionCleanups, key); err != nil {
+				return 0, fmt.Errorf("error deleting cleanup action: %w", err)
+			}
+		}
+	}
+	h.actions, h.keys, h.next, h.full = kept.actions, kept.keys, kept.next, kept.full
+
+	if h.file != nil {
+		if err := h.rewrite(); err != nil {
+			return len(forgotten), fmt.Errorf("error rewriting cleanup history: %w", err)
+		}
+	}
+	return len(forgotten), nil
+}
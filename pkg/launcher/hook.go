@@ -0,0 +1,300 @@
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"text/template"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	PreHook  = "pre"
+	PostHook = "post"
+)
+
+// HookRef identifies a pre-launch hook resource, so it can be deleted
+// together with the job.
+type HookRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// NewHookObjectsFromTemplate renders a multi-document template of arbitrary
+// kinds.
+func NewHookObjectsFromTemplate(tmpl *template.Template, spec *TemplateSpec) ([]*unstructured.Unstructured, error) {
+	// Generate template
+	GenTemplateSpec(spec)
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, spec); err != nil {
+		return nil, fmt.Errorf("error executing hook template: %w", err)
+	}
+
+	// Parse resulting YAML documents
+	var objects []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(buf, 100)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error parsing hook YAML: %w", err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		SetLaunchMetadata(obj, spec)
+		labels := obj.GetLabels()
+		labels[HookLabel] = PreHook
+		obj.SetLabels(labels)
+
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+func (s *LauncherService) resourceFor(namespace string, gvk schema.GroupVersionKind) (dynamic.ResourceInterface, error) {
+	if s.Dynamic == nil || s.Mapper == nil {
+		return nil, fmt.Errorf("dynamic client is not configured")
+	}
+
+	mapping, err := s.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if resettable, ok := s.Mapper.(meta.ResettableRESTMapper); ok && meta.IsNoMatchError(err) {
+		// The kind may have been installed after discovery was cached
+		resettable.Reset()
+		mapping, err = s.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding resource for %s: %w", gvk, err)
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		return s.Dynamic.Resource(mapping.Resource), nil
+	}
+	return s.Dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+func (s *LauncherService) launchUnstructured(ctx context.Context, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	client, err := s.resourceFor(namespace, obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	return client.Create(ctx, obj, s.createOptions())
+}
+
+// unstructuredCleanupTarget cleans up a kind through the dynamic client, in
+// whatever version the API server prefers.
+func (s *LauncherService) unstructuredCleanupTarget(gk schema.GroupKind) cleanupTarget {
+	resource := func(namespace string) (dynamic.ResourceInterface, error) {
+		return s.resourceFor(namespace, gk.WithVersion(""))
+	}
+	return cleanupTarget{
+		Kind: gk.Kind,
+		List: func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+			client, err := resource(namespace)
+			if err != nil {
+				return nil, err
+			}
+			list, err := client.List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return objects(list.Items), nil
+		},
+		Patch: func(ctx context.Context, namespace string, name string, patch []byte) error {
+			client, err := resource(namespace)
+			if err != nil {
+				return err
+			}
+			_, err = client.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+			return err
+		},
+		Delete: func(ctx context.Context, namespace string, name string) error {
+			client, err := resource(namespace)
+			if err != nil {
+				return err
+			}
+			return client.Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}
+}
+
+// launchPreHooks creates the pre-launch hook resources. Resources left over
+// from an earlier attempt are reused, created only lists those that were not,
+// also when creating a later one fails.
+func (s *LauncherService) launchPreHooks(ctx context.Context, namespace string, objects []*unstructured.Unstructured) (refs []HookRef, created []HookRef, err error) {
+	for _, obj := range objects {
+		client, err := s.resourceFor(namespace, obj.GroupVersionKind())
+		if err != nil {
+			return nil, created, err
+		}
+		ref := HookRef{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Name:       obj.GetName(),
+		}
+		if _, err := client.Create(ctx, obj, s.createOptions()); err == nil {
+			created = append(created, ref)
+		} else if !apierrors.IsAlreadyExists(err) {
+			return nil, created, fmt.Errorf("error creating %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, created, nil
+}
+
+// deleteHooks deletes pre-launch hook resources of a launch that failed.
+func (s *LauncherService) deleteHooks(ctx context.Context, namespace string, refs []HookRef) error {
+	var errs []error
+	for _, ref := range refs {
+		client, err := s.resourceFor(namespace, schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+		if err == nil {
+			err = client.Delete(ctx, ref.Name, metav1.DeleteOptions{})
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("error deleting hook %s %s: %w", ref.Kind, ref.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteHookResources deletes the pre-launch hook resources of a job. With
+// retain, those the profile's RetentionPolicy keeps are only marked retained.
+func (s *LauncherService) deleteHookResources(ctx context.Context, namespace string, job *batchv1.Job, retain bool) error {
+	data, ok := job.Annotations[HookResourcesAnnotation]
+	if !ok {
+		return nil
+	}
+	var policy RetentionPolicy
+	if retain {
+		if p, err := s.profileVersion(job.Labels[ProfileLabel], job.Annotations[ProfileVersionAnnotation]); err == nil {
+			policy = p.Settings.Retention
+		}
+	}
+
+	var refs []HookRef
+	if err := json.Unmarshal([]byte(data), &refs); err != nil {
+		// Retrying won't fix the annotation
+		log.Printf("error parsing hook resources of job %s: %v", job.Name, err)
+		return nil
+	}
+
+	var errs []error
+	for _, ref := range refs {
+		client, err := s.resourceFor(namespace, schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+		if err != nil {
+			log.Printf("error deleting hook %s %s: %v", ref.Kind, ref.Name, err)
+			s.recordCleanup(cleanupActionOf(job, CleanupDelete, ref.Kind, ref.Name), err)
+			errs = append(errs, fmt.Errorf("error deleting hook %s %s: %w", ref.Kind, ref.Name, err))
+			continue
+		}
+		if retention := policy.retention(ref); retention > 0 {
+			patch, err := retainPatch(time.Now().UTC().Add(retention))
+			if err == nil {
+				_, err = client.Patch(ctx, ref.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+			}
+			if apierrors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				log.Printf("error retaining hook %s %s: %v", ref.Kind, ref.Name, err)
+				errs = append(errs, fmt.Errorf("error retaining hook %s %s: %w", ref.Kind, ref.Name, err))
+			}
+			s.recordCleanup(cleanupActionOf(job, CleanupRetain, ref.Kind, ref.Name), err)
+			continue
+		}
+		err = client.Delete(ctx, ref.Name, metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			log.Printf("error deleting hook %s %s: %v", ref.Kind, ref.Name, err)
+			errs = append(errs, fmt.Errorf("error deleting hook %s %s: %w", ref.Kind, ref.Name, err))
+		}
+		s.recordCleanup(cleanupActionOf(job, CleanupDelete, ref.Kind, ref.Name), err)
+	}
+	return errors.Join(errs...)
+}
+
+// launchPostHook creates the post-launch hook job of a completed job, once.
+func (s *LauncherService) launchPostHook(ctx context.Context, namespace string, job *batchv1.Job) error {
+	// The job's own profile version, even if a newer one was rolled out since
+	p, err := s.profileVersion(job.Labels[ProfileLabel], job.Annotations[ProfileVersionAnnotation])
+	if err != nil {
+		return err
+	}
+	if p.PostHook == nil {
+		return nil
+	}
+	if _, ok := job.Annotations[PostHookAnnotation]; ok {
+		return nil
+	}
+
+	spec, err := s.specFromJob(namespace, job, p)
+	if err != nil {
+		return err
+	}
+	hook, err := NewJobFromTemplate(p.PostHook, nil, spec)
+	if err != nil {
+		return fmt.Errorf("error creating post-launch hook from template: %w", err)
+	}
+	hook.Labels[HookLabel] = PostHook
+
+	if _, err := s.jobClient(namespace).Create(ctx, hook, s.createOptions()); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("error creating post-launch hook job %s: %w", hook.Name, err)
+	}
+	log.Printf("launched post-launch hook job %s for job %s", hook.Name, job.Name)
+
+	// Remember the hook so it is not launched again
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				PostHookAnnotation: hook.Name,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.jobClient(namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("error annotating job %s: %w", job.Name, err)
+	}
+
+	return nil
+}
+
+// handleHookJob deletes a hook job once the cleanup policy allows it. Its
+// recording job was cleaned up already, so there is nothing else to delete.
+func (s *LauncherService) handleHookJob(ctx context.Context, namespace string, job *batchv1.Job) {
+	if !s.cleanupPolicy(job) || s.markCleanedUp(ctx, job) {
+		return
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	err := s.jobClient(namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if apierrors.IsNotFound(err) {
+		return
+	} else if err != nil {
+		log.Printf("error deleting hook job %s: %v", job.Name, err)
+		s.unmarkCleanedUp(job)
+	} else {
+		log.Printf("hook job %s has completed, deleted it", job.Name)
+	}
+	s.recordCleanup(cleanupActionOf(job, CleanupDelete, "Job", job.Name), err)
+}
@@ -0,0 +1,54 @@
+package launcher
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Hostnames configures the .IngressHost, .IngressPath and .URL template
+// values. They are left empty without a base domain.
+var Hostnames = HostnameConfig{
+	Prefix:      "v-",
+	PathPattern: "/",
+}
+
+type HostnameConfig struct {
+	// BaseDomain the per-video hosts are created under, e.g. live.example.com
+	BaseDomain string `json:"baseDomain"`
+
+	// Prefix of the host label
+	Prefix string `json:"prefix"`
+
+	// PathPattern of the ingress path, {name} is replaced with .UniqueName
+	// and {videoId} with the escaped video ID
+	PathPattern string `json:"pathPattern"`
+}
+
+var dnsUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// DNSLabel turns s into a valid DNS label: lowercase alphanumerics and
+// hyphens, at most 63 characters, not starting or ending with a hyphen.
+func DNSLabel(s string) string {
+	label := dnsUnsafe.ReplaceAllString(strings.ToLower(s), "-")
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return strings.Trim(label, "-")
+}
+
+// setHostnames fills in the hostname values of a spec whose UniqueName is set.
+func (c HostnameConfig) setHostnames(spec *TemplateSpec) {
+	if c.BaseDomain == "" {
+		return
+	}
+
+	spec.IngressHost = DNSLabel(c.Prefix+spec.UniqueName) + "." + strings.Trim(c.BaseDomain, ".")
+	spec.IngressPath = strings.NewReplacer(
+		"{name}", spec.UniqueName,
+		"{videoId}", url.PathEscape(spec.VideoId),
+	).Replace(c.PathPattern)
+
+	u := url.URL{Scheme: "https", Host: spec.IngressHost, Path: spec.IngressPath}
+	spec.URL = u.String()
+}
@@ -0,0 +1,195 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// IngressReadiness checks that created ingresses are reachable: it watches
+// for the ingress controller to assign an address, then probes every host of
+// the ingress until it resolves and answers HTTP requests with anything but a
+// server error. Reachable ingresses get the IngressReadyAnnotation.
+type IngressReadiness struct {
+	// Timeout gives up on ingresses that aren't reachable by then
+	Timeout time.Duration
+	// Interval between probes
+	Interval time.Duration
+	// Scheme of the probe requests, http or https
+	Scheme string
+
+	// LookupHost resolves hosts, net.DefaultResolver when nil
+	LookupHost func(ctx context.Context, host string) ([]string, error)
+	// Client sends the probe requests, with a short timeout when nil
+	Client *http.Client
+}
+
+func (r *IngressReadiness) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if r.LookupHost != nil {
+		return r.LookupHost(ctx, host)
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+func (r *IngressReadiness) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return &http.Client{Timeout: 5 * time.Second}
+}
+
+// ingressReachable tells whether an ingress was reachable, and since when.
+func ingressReachable(ingress *networkingv1.Ingress) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, ingress.Annotations[IngressReadyAnnotation])
+	return t, err == nil
+}
+
+// checkIngressReadiness waits in the background until a created ingress is
+// reachable, and records when it became reachable.
+func (s *LauncherService) checkIngressReadiness(namespace string, ingress *networkingv1.Ingress, createdAt time.Time) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.IngressReadiness.Timeout)
+		defer cancel()
+
+		ingress, err := s.waitForIngressAddress(ctx, namespace, ingress)
+		if err == nil {
+			err = s.probeIngress(ctx, ingress)
+		}
+		if err != nil {
+			ingressReadinessTimeouts.Inc()
+			log.Printf("ingress %s is not reachable after %s: %v", ingress.Name, time.Since(createdAt).Round(time.Second), err)
+			return
+		}
+
+		now := time.Now().UTC()
+		ingressTimeToReachable.Observe(now.Sub(createdAt).Seconds())
+		log.Printf("ingress %s is reachable after %s", ingress.Name, now.Sub(createdAt).Round(time.Millisecond))
+
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"annotations": map[string]string{
+					IngressReadyAnnotation: now.Format(time.RFC3339),
+				},
+			},
+		})
+		if err != nil {
+			log.Printf("error marking ingress %s ready: %v", ingress.Name, err)
+			return
+		}
+		// The readiness check is done, don't let its deadline fail the patch
+		_, err = s.ingressClient(namespace).Patch(context.Background(), ingress.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			log.Printf("error marking ingress %s ready: %v", ingress.Name, err)
+		}
+	}()
+}
+
+// waitForIngressAddress watches an ingress until its status has a load
+// balancer address.
+func (s *LauncherService) waitForIngressAddress(ctx context.Context, namespace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
+	for len(ingress.Status.LoadBalancer.Ingress) == 0 {
+		w, err := s.ingressClient(namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", ingress.Name).String(),
+			ResourceVersion: ingress.ResourceVersion,
+		})
+		if err != nil {
+			return ingress, fmt.Errorf("error watching ingress: %w", err)
+		}
+		// Fake clusters ignore the resource version, catch up on changes
+		// made before the watch started
+		if latest, err := s.ingressClient(namespace).Get(ctx, ingress.Name, metav1.GetOptions{}); err == nil && len(latest.Status.LoadBalancer.Ingress) > 0 {
+			w.Stop()
+			return latest, nil
+		}
+		ingress, err = nextIngressAddress(ctx, w, ingress)
+		w.Stop()
+		if err != nil {
+			return ingress, err
+		}
+	}
+	return ingress, nil
+}
+
+// nextIngressAddress returns the ingress once it has an address, or its
+// latest version when the watch ends without one.
+func nextIngressAddress(ctx context.Context, w watch.Interface, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
+	for {
+		select {
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return ingress, nil
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return ingress, fmt.Errorf("ingress was deleted")
+			case watch.Added, watch.Modified:
+				if updated, ok := event.Object.(*networkingv1.Ingress); ok && updated.Name == ingress.Name {
+					ingress = updated
+					if len(ingress.Status.LoadBalancer.Ingress) > 0 {
+						return ingress, nil
+					}
+				}
+			}
+		case <-ctx.Done():
+			return ingress, fmt.Errorf("no load balancer address: %w", ctx.Err())
+		}
+	}
+}
+
+// probeIngress probes every host of an ingress until all of them are
+// reachable.
+func (s *LauncherService) probeIngress(ctx context.Context, ingress *networkingv1.Ingress) error {
+	r := s.IngressReadiness
+	var hosts []string
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" {
+			hosts = append(hosts, rule.Host)
+		}
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for _, host := range hosts {
+		for {
+			err := r.probe(ctx, host)
+			if err == nil {
+				break
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return fmt.Errorf("host %s: %v: %w", host, err, ctx.Err())
+			}
+		}
+	}
+	return nil
+}
+
+func (r *IngressReadiness) probe(ctx context.Context, host string) error {
+	if _, err := r.lookupHost(ctx, host); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.Scheme+"://"+host+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package launcher
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testIngressTemplate = `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: recorder-ing-{{ .UniqueName }}
+spec:
+  rules:
+  - host: {{ .VideoId }}.example.com
+    http:
+      paths:
+      - path: /
+        pathType: Prefix
+        backend:
+          service:
+            name: recorder-svc-{{ .UniqueName }}
+            port:
+              number: 80
+`
+
+func TestIngressReadiness(t *testing.T) {
+	ctx := context.Background()
+
+	// The backend comes up on the second probe
+	var probes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "abc.example.com" || probes.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	s := newTestService(t)
+	s.Profiles[DefaultProfileName].Ingress = template.Must(template.New("ingress").Parse(testIngressTemplate))
+	s.IngressReadiness = &IngressReadiness{
+		Timeout:  5 * time.Second,
+		Interval: 10 * time.Millisecond,
+		Scheme:   "http",
+		LookupHost: func(ctx context.Context, host string) ([]string, error) {
+			return []string{"127.0.0.1"}, nil
+		},
+		Client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("tcp", server.Listener.Addr().String())
+			},
+		}},
+	}
+	tenant, _ := s.Tenants.Tenant(DefaultTenantName)
+
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	status, err := s.Status(ctx, tenant, "abc")
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.IngressReady == nil || *status.IngressReady {
+		t.Fatalf("ingress ready before it got an address: %v", status.IngressReady)
+	}
+
+	// The ingress controller assigns an address
+	ingresses := s.ingressClient("test")
+	ingress, err := ingresses.Get(ctx, status.Ingresses[0], metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get ingress: %v", err)
+	}
+	ingress.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{IP: "10.0.0.1"}}
+	if _, err := ingresses.UpdateStatus(ctx, ingress, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update ingress status: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err = s.Status(ctx, tenant, "abc")
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		if *status.IngressReady {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ingress never became ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.IngressReadyAt == nil || probes.Load() < 2 {
+		t.Errorf("ingress ready at %v after %d probes, want a time after 2 probes", status.IngressReadyAt, probes.Load())
+	}
+}
@@ -0,0 +1,73 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var ErrPaused = errors.New("launcher is paused for maintenance")
+
+// MaintenanceState tells whether launching is paused, e.g. during a cluster
+// upgrade. While it is, launches are rejected with ErrPaused or queued, and
+// queued launches wait. Cleanup carries on.
+type MaintenanceState struct {
+	Paused bool       `json:"paused"`
+	Reason string     `json:"reason,omitempty"`
+	By     string     `json:"by,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// Pause stops launching until Resume. Pausing again updates the reason.
+func (s *LauncherService) Pause(reason string, by string) MaintenanceState {
+	now := time.Now().UTC()
+	state := &MaintenanceState{Paused: true, Reason: reason, By: by, Since: &now}
+	s.maintenance.Store(state)
+	pausedGauge.Set(1)
+	log.Printf("launching was paused by %s: %s", by, reason)
+	s.auditMaintenance(state)
+	return *state
+}
+
+// Resume lets launches and queued launches start again.
+func (s *LauncherService) Resume(by string) MaintenanceState {
+	previous := s.maintenance.Swap(nil)
+	pausedGauge.Set(0)
+	state := &MaintenanceState{By: by}
+	if previous != nil {
+		log.Printf("launching was resumed by %s after %s", by, time.Since(*previous.Since).Round(time.Second))
+		s.auditMaintenance(state)
+	}
+	return *state
+}
+
+// Maintenance returns whether launching is paused.
+func (s *LauncherService) Maintenance() MaintenanceState {
+	if state := s.maintenance.Load(); state != nil {
+		return *state
+	}
+	return MaintenanceState{}
+}
+
+// checkPaused returns ErrPaused while launching is paused.
+func (s *LauncherService) checkPaused() error {
+	state := s.maintenance.Load()
+	if state == nil {
+		return nil
+	}
+	if state.Reason == "" {
+		return ErrPaused
+	}
+	return fmt.Errorf("%w: %s", ErrPaused, state.Reason)
+}
+
+func (s *LauncherService) auditMaintenance(state *MaintenanceState) {
+	if s.Storage == nil {
+		return
+	}
+	if err := s.Storage.Put(context.Background(), CollectionAudit, "maintenance/"+orderedKey(time.Now()), state); err != nil {
+		log.Printf("error storing maintenance state: %v", err)
+	}
+}
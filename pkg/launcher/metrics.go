@@ -0,0 +1,151 @@
+package launcher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	launchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_launches_total",
+		Help: "Number of launch requests by tenant and result.",
+	}, []string{"tenant", "result"})
+
+	quotaRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_quota_rejections_total",
+		Help: "Number of launches rejected by tenant limits.",
+	}, []string{"tenant", "limit"})
+
+	cleanupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_cleanups_total",
+		Help: "Number of completed jobs whose resources were cleaned up.",
+	}, []string{"tenant"})
+
+	watchEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_watch_events_total",
+		Help: "Number of job watch events by namespace and event type.",
+	}, []string{"namespace", "type"})
+
+	relistsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_relists_total",
+		Help: "Number of full job relists by namespace and reason.",
+	}, []string{"namespace", "reason"})
+
+	unschedulablePods = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_unschedulable_pods",
+		Help: "Number of pods of launched jobs the scheduler cannot place.",
+	})
+
+	atCapacity = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_at_capacity",
+		Help: "1 while launches are rejected because pods stay unschedulable, e.g. to trigger autoscaling.",
+	})
+
+	apiWarningsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_api_warnings_total",
+		Help: "Number of API server warnings, e.g. about deprecated fields, in launches by tenant.",
+	}, []string{"tenant"})
+
+	deliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_deliveries_total",
+		Help: "Number of outbound deliveries by destination and result: delivered, failed or circuit_open.",
+	}, []string{"destination", "result"})
+
+	deliveryAttemptsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_delivery_attempts_total",
+		Help: "Number of outbound delivery attempts, including retries, by destination.",
+	}, []string{"destination"})
+
+	ingressTimeToReachable = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "launcher_ingress_time_to_reachable_seconds",
+		Help:    "Time from creating an ingress until its hosts resolved and answered HTTP requests.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	ingressReadinessTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "launcher_ingress_readiness_timeouts_total",
+		Help: "Number of ingresses that weren't reachable within the readiness timeout.",
+	})
+
+	launchPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "launcher_launch_phase_duration_seconds",
+		Help:    "Time spent in each phase of a launch: rendering, dry run, quota checks, creation steps and waits for their readiness.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"phase"})
+
+	deliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "launcher_delivery_duration_seconds",
+		Help:    "Time spent on outbound deliveries, including retries, by destination.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"destination"})
+
+	circuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "launcher_delivery_circuit_open",
+		Help: "1 while the circuit of a destination is open and deliveries to it are skipped.",
+	}, []string{"destination"})
+
+	deadLettersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_dead_letters_total",
+		Help: "Number of payloads that could not be delivered by destination.",
+	}, []string{"destination"})
+
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_queue_depth",
+		Help: "Number of scheduled and queued launches, updated every queue interval.",
+	})
+
+	cleanupBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_cleanup_backlog",
+		Help: "Number of completed jobs waiting for a cleanup worker, including retries.",
+	})
+
+	retiredResources = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "launcher_retired_resources",
+		Help: "Number of retired or restored resources of completed jobs waiting for deletion.",
+	}, []string{"state"})
+
+	retainedResources = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "launcher_retained_resources",
+		Help: "Number of PVCs and Secrets of completed jobs that are retained.",
+	}, []string{"kind"})
+
+	retainedStorage = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_retained_storage_bytes",
+		Help: "Storage claimed by retained PVCs of completed jobs.",
+	})
+
+	reclaimedResources = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_reclaimed_resources_total",
+		Help: "Number of retained PVCs and Secrets deleted, by whether they expired or were consumed.",
+	}, []string{"kind", "reason"})
+
+	reclaimedStorage = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "launcher_reclaimed_storage_bytes_total",
+		Help: "Storage of retained PVCs deleted.",
+	})
+
+	profileSourceRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "launcher_profile_source_refreshes_total",
+		Help: "Number of refreshes of the remote profiles source, by whether they were unchanged, updated or failed.",
+	}, []string{"result"})
+
+	pausedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_paused",
+		Help: "Whether launching is paused for maintenance.",
+	})
+
+	staleLaunches = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_stale_launches",
+		Help: "Number of active jobs whose heartbeats are overdue.",
+	})
+
+	inflightLaunches = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_inflight_launches",
+		Help: "Number of launch requests this replica is processing.",
+	})
+
+	watchBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "launcher_watch_backlog",
+		Help: "Number of job events and relisted jobs this replica received but has not handled yet.",
+	})
+)
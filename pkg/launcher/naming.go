@@ -0,0 +1,83 @@
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MaxNameSalt bounds the attempts to find a job name that isn't taken by
+// another video.
+const MaxNameSalt = 10
+
+// renderUnique renders a launch, salting UniqueName until the job name does not
+// belong to a different video.
+func (s *LauncherService) renderUnique(ctx context.Context, spec *TemplateSpec) (*Manifests, error) {
+	for ; spec.NameSalt <= MaxNameSalt; spec.NameSalt++ {
+		manifests, err := s.render(spec)
+		if err != nil {
+			return nil, err
+		}
+		if manifests.Job == nil {
+			return manifests, nil
+		}
+
+		existing, err := s.jobClient(spec.Namespace).Get(ctx, manifests.Job.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			setNameSalt(spec, manifests)
+			return manifests, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error checking job name %s: %w", manifests.Job.Name, err)
+		}
+
+		// Jobs from before the annotation existed only have the label
+		videoId, ok := existing.Annotations[VideoIdAnnotation]
+		if !ok {
+			videoId = existing.Labels[VideoIdLabel]
+		}
+		if videoId == spec.VideoId {
+			setNameSalt(spec, manifests)
+			return manifests, nil
+		}
+
+		log.Printf("job name %s of video %s collides with video %s, regenerating", manifests.Job.Name, spec.VideoId, videoId)
+	}
+
+	return nil, fmt.Errorf("no free job name for video %s after %d attempts", spec.VideoId, MaxNameSalt)
+}
+
+// setNameSalt records the salt on the job, so later renders of the launch
+// (e.g. hooks) get the same names.
+func setNameSalt(spec *TemplateSpec, m *Manifests) {
+	if spec.NameSalt > 0 {
+		m.Job.Annotations[NameSaltAnnotation] = strconv.Itoa(spec.NameSalt)
+	}
+}
+
+// renamedJob returns the newest job of the spec's video with another name than
+// name, if any. Jobs launched under another NameHashLength or naming
+// strategy, e.g. before an upgrade, aren't found by their name.
+func (s *LauncherService) renamedJob(ctx context.Context, spec *TemplateSpec, name string) (*batchv1.Job, error) {
+	selector := ManagedLabelSelector() + fmt.Sprintf(",%s=%s,%s=%s,!%s", VideoIdLabel, spec.VideoId, TenantLabel, spec.Tenant, HookLabel)
+	list, err := s.jobClient(spec.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("error listing jobs of video %s: %w", spec.VideoId, err)
+	}
+
+	var newest *batchv1.Job
+	for i := range list.Items {
+		job := &list.Items[i]
+		if job.Name == name {
+			continue
+		}
+		if newest == nil || job.CreationTimestamp.After(newest.CreationTimestamp.Time) {
+			newest = job
+		}
+	}
+	return newest, nil
+}
@@ -0,0 +1,96 @@
+package launcher
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidateManifests checks the names, labels and annotations of rendered
+// objects against the API server's rules, so a template that renders an
+// invalid one fails with an ErrValidation naming the field and value, before
+// anything is created.
+func ValidateManifests(m *Manifests) error {
+	var errs []error
+	check := func(kind string, obj metav1.Object, nameFn apivalidation.ValidateNameFunc) {
+		for _, err := range validateObjectMeta(obj, nameFn, field.NewPath("metadata")) {
+			errs = append(errs, fmt.Errorf("%w: %s %s: %v", ErrValidation, kind, obj.GetName(), err))
+		}
+	}
+
+	for _, obj := range m.PreHooks {
+		check(obj.GetKind(), obj, apivalidation.NameIsDNSSubdomain)
+	}
+	if m.NetworkPolicy != nil {
+		check("network policy", m.NetworkPolicy, apivalidation.NameIsDNSSubdomain)
+	}
+	if m.Job != nil {
+		// The job controller labels pods with the job name, which limits it
+		// to a label's length
+		check("job", m.Job, apivalidation.NameIsDNSLabel)
+		path := field.NewPath("spec", "template", "metadata")
+		for _, err := range validateLabels(m.Job.Spec.Template.Labels, path.Child("labels")) {
+			errs = append(errs, fmt.Errorf("%w: job %s: %v", ErrValidation, m.Job.Name, err))
+		}
+		for _, err := range apivalidation.ValidateAnnotations(m.Job.Spec.Template.Annotations, path.Child("annotations")) {
+			errs = append(errs, fmt.Errorf("%w: job %s: %v", ErrValidation, m.Job.Name, err))
+		}
+	}
+	if m.Service != nil {
+		check("service", m.Service, apivalidation.NameIsDNS1035Label)
+	}
+	if m.Ingress != nil {
+		check("ingress", m.Ingress, apivalidation.NameIsDNSSubdomain)
+	}
+	if m.PodDisruptionBudget != nil {
+		check("pod disruption budget", m.PodDisruptionBudget, apivalidation.NameIsDNSSubdomain)
+	}
+	for _, obj := range []*unstructured.Unstructured{m.HTTPRoute, m.DestinationRule, m.VirtualService} {
+		if obj != nil {
+			check(obj.GetKind(), obj, apivalidation.NameIsDNSSubdomain)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateObjectMeta(obj metav1.Object, nameFn apivalidation.ValidateNameFunc, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if obj.GetName() == "" {
+		errs = append(errs, field.Required(path.Child("name"), "templates must set a name"))
+	} else {
+		for _, msg := range nameFn(obj.GetName(), false) {
+			errs = append(errs, field.Invalid(path.Child("name"), obj.GetName(), msg))
+		}
+	}
+	errs = append(errs, validateLabels(obj.GetLabels(), path.Child("labels"))...)
+	errs = append(errs, apivalidation.ValidateAnnotations(obj.GetAnnotations(), path.Child("annotations"))...)
+	return errs
+}
+
+// validateLabels reports invalid label keys and values with the label they
+// belong to.
+func validateLabels(labels map[string]string, path *field.Path) field.ErrorList {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs field.ErrorList
+	for _, k := range keys {
+		v := labels[k]
+		for _, msg := range validation.IsQualifiedName(k) {
+			errs = append(errs, field.Invalid(path, k, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(v) {
+			errs = append(errs, field.Invalid(path.Key(k), v, msg))
+		}
+	}
+	return errs
+}
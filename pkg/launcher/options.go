@@ -0,0 +1,140 @@
+package launcher
+
+import (
+	"context"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// JobCreator creates the job of a launch.
+type JobCreator interface {
+	CreateJob(ctx context.Context, namespace string, job *batchv1.Job, opts metav1.CreateOptions) (*batchv1.Job, error)
+}
+
+// ServiceCreator creates the service of a launch.
+type ServiceCreator interface {
+	CreateService(ctx context.Context, namespace string, service *corev1.Service, opts metav1.CreateOptions) (*corev1.Service, error)
+}
+
+// IngressCreator creates the ingress of a launch.
+type IngressCreator interface {
+	CreateIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress, opts metav1.CreateOptions) (*networkingv1.Ingress, error)
+}
+
+// Watcher lists and watches jobs for the cleanup watcher.
+type Watcher interface {
+	ListJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.JobList, error)
+	WatchJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+// clientsetBackend implements the launcher's interfaces with a clientset.
+type clientsetBackend struct {
+	clientset kubernetes.Interface
+}
+
+func (b clientsetBackend) CreateJob(ctx context.Context, namespace string, job *batchv1.Job, opts metav1.CreateOptions) (*batchv1.Job, error) {
+	return b.clientset.BatchV1().Jobs(namespace).Create(ctx, job, opts)
+}
+
+func (b clientsetBackend) CreateService(ctx context.Context, namespace string, service *corev1.Service, opts metav1.CreateOptions) (*corev1.Service, error) {
+	return b.clientset.CoreV1().Services(namespace).Create(ctx, service, opts)
+}
+
+func (b clientsetBackend) CreateIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress, opts metav1.CreateOptions) (*networkingv1.Ingress, error) {
+	return b.clientset.NetworkingV1().Ingresses(namespace).Create(ctx, ingress, opts)
+}
+
+func (b clientsetBackend) ListJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.JobList, error) {
+	return b.clientset.BatchV1().Jobs(namespace).List(ctx, opts)
+}
+
+func (b clientsetBackend) WatchJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return b.clientset.BatchV1().Jobs(namespace).Watch(ctx, opts)
+}
+
+// CleanupPolicy decides whether the resources of a job are cleaned up.
+type CleanupPolicy func(job *batchv1.Job) bool
+
+// CleanupOnSuccess cleans up once a job has succeeded, keeping the resources
+// of failed jobs around for debugging. This is the default.
+func CleanupOnSuccess(job *batchv1.Job) bool {
+	return job.Status.Succeeded > 0
+}
+
+// CleanupOnFinish cleans up once a job has succeeded or failed.
+func CleanupOnFinish(job *batchv1.Job) bool {
+	return IsJobFinished(job)
+}
+
+// Metrics receives the launcher's events.
+type Metrics interface {
+	Launched(tenant string, result string)
+	QuotaRejected(tenant string, limit string)
+	CleanedUp(tenant string)
+	WatchEvent(namespace string, eventType string)
+	Relisted(namespace string, reason string)
+	APIWarning(tenant string)
+}
+
+// prometheusMetrics counts events in the Prometheus default registry.
+type prometheusMetrics struct{}
+
+func (prometheusMetrics) Launched(tenant string, result string) {
+	launchesTotal.WithLabelValues(tenant, result).Inc()
+}
+
+func (prometheusMetrics) QuotaRejected(tenant string, limit string) {
+	quotaRejectionsTotal.WithLabelValues(tenant, limit).Inc()
+}
+
+func (prometheusMetrics) CleanedUp(tenant string) {
+	cleanupsTotal.WithLabelValues(tenant).Inc()
+}
+
+func (prometheusMetrics) WatchEvent(namespace string, eventType string) {
+	watchEventsTotal.WithLabelValues(namespace, eventType).Inc()
+}
+
+func (prometheusMetrics) Relisted(namespace string, reason string) {
+	relistsTotal.WithLabelValues(namespace, reason).Inc()
+}
+
+func (prometheusMetrics) APIWarning(tenant string) {
+	apiWarningsTotal.WithLabelValues(tenant).Inc()
+}
+
+// Option configures a LauncherService.
+type Option func(*LauncherService)
+
+func WithJobCreator(jobs JobCreator) Option {
+	return func(s *LauncherService) { s.jobs = jobs }
+}
+
+func WithServiceCreator(services ServiceCreator) Option {
+	return func(s *LauncherService) { s.services = services }
+}
+
+func WithIngressCreator(ingresses IngressCreator) Option {
+	return func(s *LauncherService) { s.ingresses = ingresses }
+}
+
+func WithWatcher(watcher Watcher) Option {
+	return func(s *LauncherService) { s.watcher = watcher }
+}
+
+func WithCleanupPolicy(policy CleanupPolicy) Option {
+	return func(s *LauncherService) { s.cleanupPolicy = policy }
+}
+
+func WithNamingStrategy(naming NamingStrategy) Option {
+	return func(s *LauncherService) { s.naming = naming }
+}
+
+func WithMetrics(metrics Metrics) Option {
+	return func(s *LauncherService) { s.metrics = metrics }
+}
@@ -0,0 +1,123 @@
+package launcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Overlay holds the patches applied to one kind of rendered manifest. Patches
+// are templates themselves and are executed with the same TemplateSpec.
+type Overlay struct {
+	// Strategic merge patch, read from <kind>.patch.yaml
+	StrategicMerge *template.Template
+	// RFC 6902 JSON patch, read from <kind>.jsonpatch.yaml
+	JSONPatch *template.Template
+}
+
+var OverlayKinds = []string{"job", "service", "ingress", "pdb", "networkpolicy"}
+
+// LoadOverlays reads the overlays of an environment from dir/environment.
+func LoadOverlays(dir string, environment string) (map[string]*Overlay, error) {
+	overlays := map[string]*Overlay{}
+	envDir := filepath.Join(dir, environment)
+	if _, err := os.Stat(envDir); err != nil {
+		return nil, fmt.Errorf("error reading overlays of environment %s: %w", environment, err)
+	}
+
+	load := func(name string) (*template.Template, error) {
+		path := filepath.Join(envDir, name)
+		str, err := ReadToString(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(name).Funcs(TemplateFuncs).Parse(str)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing overlay %s: %w", path, err)
+		}
+		log.Printf("Loaded overlay %s", path)
+		return tmpl, nil
+	}
+
+	for _, kind := range OverlayKinds {
+		var err error
+		overlay := &Overlay{}
+		if overlay.StrategicMerge, err = load(kind + ".patch.yaml"); err != nil {
+			return nil, err
+		}
+		if overlay.JSONPatch, err = load(kind + ".jsonpatch.yaml"); err != nil {
+			return nil, err
+		}
+		if overlay.StrategicMerge != nil || overlay.JSONPatch != nil {
+			overlays[kind] = overlay
+		}
+	}
+
+	return overlays, nil
+}
+
+func executeToJSON(tmpl *template.Template, spec *TemplateSpec) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, spec); err != nil {
+		return nil, fmt.Errorf("error executing overlay %s: %w", tmpl.Name(), err)
+	}
+	data, err := yaml.ToJSON(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing overlay %s: %w", tmpl.Name(), err)
+	}
+	return data, nil
+}
+
+// ApplyOverlay returns a patched copy of obj. A nil overlay returns obj as is.
+func ApplyOverlay[T any](o *Overlay, spec *TemplateSpec, obj *T) (*T, error) {
+	if o == nil {
+		return obj, nil
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.StrategicMerge != nil {
+		patch, err := executeToJSON(o.StrategicMerge, spec)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = strategicpatch.StrategicMergePatch(data, patch, obj); err != nil {
+			return nil, fmt.Errorf("error applying overlay %s: %w", o.StrategicMerge.Name(), err)
+		}
+	}
+
+	if o.JSONPatch != nil {
+		patchJSON, err := executeToJSON(o.JSONPatch, spec)
+		if err != nil {
+			return nil, err
+		}
+		patch, err := jsonpatch.DecodePatch(patchJSON)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing overlay %s: %w", o.JSONPatch.Name(), err)
+		}
+		if data, err = patch.Apply(data); err != nil {
+			return nil, fmt.Errorf("error applying overlay %s: %w", o.JSONPatch.Name(), err)
+		}
+	}
+
+	var patched T
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return nil, fmt.Errorf("error decoding patched object: %w", err)
+	}
+	return &patched, nil
+}
@@ -0,0 +1,148 @@
+package launcher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Parameter layers, from lowest to highest precedence.
+const (
+	ParamsGlobal  = "global"
+	ParamsProfile = "profile"
+	ParamsTenant  = "tenant"
+	ParamsRequest = "request"
+)
+
+// ParamLayer is one set of template parameters.
+type ParamLayer struct {
+	Name   string
+	Values map[string]any
+}
+
+// MergeParams merges layers into one map, later layers taking precedence.
+// Maps are merged key by key, any other value replaces the one below it. It
+// also returns the layer each top-level key was last set by.
+func MergeParams(layers ...ParamLayer) (map[string]any, map[string]string) {
+	params := map[string]any{}
+	sources := map[string]string{}
+	for _, layer := range layers {
+		for key, value := range layer.Values {
+			params[key] = mergeValue(params[key], value)
+			sources[key] = layer.Name
+		}
+	}
+	return params, sources
+}
+
+func mergeValue(base any, value any) any {
+	value = normalizeValue(value)
+	baseMap, ok := base.(map[string]any)
+	valueMap, ok2 := value.(map[string]any)
+	if !ok || !ok2 {
+		return value
+	}
+
+	merged := make(map[string]any, len(baseMap)+len(valueMap))
+	for k, v := range baseMap {
+		merged[k] = v
+	}
+	for k, v := range valueMap {
+		merged[k] = mergeValue(merged[k], v)
+	}
+	return merged
+}
+
+// normalizeValue copies a value, turning the map[interface{}]interface{} of
+// YAML decoders into map[string]any, so it can be merged and encoded as JSON.
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[k] = normalizeValue(item)
+		}
+		return m
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = normalizeValue(item)
+		}
+		return m
+	case []any:
+		l := make([]any, len(v))
+		for i, item := range v {
+			l[i] = normalizeValue(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// normalizeParams normalizes parameters decoded from YAML, see normalizeValue.
+func normalizeParams(params map[string]any) map[string]any {
+	if params == nil {
+		return nil
+	}
+	return normalizeValue(params).(map[string]any)
+}
+
+// LoadParamsFile reads global template parameters from a YAML file.
+func LoadParamsFile(path string) (map[string]any, error) {
+	data, err := ReadToString(path)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]any{}
+	if err := yaml.Unmarshal([]byte(data), &params); err != nil {
+		return nil, fmt.Errorf("error parsing params file %v: %w", path, err)
+	}
+	return params, nil
+}
+
+// effectiveParams merges the parameters of a launch.
+func (s *LauncherService) effectiveParams(p *Profile, tenantName string, request map[string]any) (map[string]any, map[string]string) {
+	layers := []ParamLayer{
+		{Name: ParamsGlobal, Values: s.Params},
+		{Name: ParamsProfile, Values: p.Settings.Params},
+	}
+	if tenant, ok := s.Tenants.Tenant(tenantName); ok {
+		layers = append(layers, ParamLayer{Name: ParamsTenant, Values: tenant.Params})
+	}
+	layers = append(layers, ParamLayer{Name: ParamsRequest, Values: request})
+	return MergeParams(layers...)
+}
+
+// setParamsAnnotation records the parameters on the job, for the templates
+// rendered after it completes.
+func setParamsAnnotation(job *batchv1.Job, params map[string]any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("error encoding params: %w", err)
+	}
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[ParamsAnnotation] = string(data)
+	return nil
+}
+
+// paramsFromJob returns the parameters a job was rendered with.
+func paramsFromJob(annotations map[string]string) (map[string]any, error) {
+	value, ok := annotations[ParamsAnnotation]
+	if !ok {
+		return map[string]any{}, nil
+	}
+	params := map[string]any{}
+	if err := json.Unmarshal([]byte(value), &params); err != nil {
+		return nil, fmt.Errorf("invalid params annotation: %w", err)
+	}
+	return params, nil
+}
@@ -0,0 +1,41 @@
+package launcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeParams(t *testing.T) {
+	params, sources := MergeParams(
+		ParamLayer{Name: ParamsGlobal, Values: map[string]any{
+			"retention": "30d",
+			"storage":   map[string]any{"bucket": "global", "region": "eu"},
+		}},
+		ParamLayer{Name: ParamsProfile, Values: map[string]any{
+			"bitrate": 3000,
+			// As decoded by gopkg.in/yaml.v2
+			"storage": map[any]any{"bucket": "profile"},
+		}},
+		ParamLayer{Name: ParamsTenant, Values: nil},
+		ParamLayer{Name: ParamsRequest, Values: map[string]any{
+			"bitrate": 8000,
+		}},
+	)
+
+	want := map[string]any{
+		"retention": "30d",
+		"bitrate":   8000,
+		"storage":   map[string]any{"bucket": "profile", "region": "eu"},
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("params = %v, want %v", params, want)
+	}
+	wantSources := map[string]string{
+		"retention": ParamsGlobal,
+		"bitrate":   ParamsRequest,
+		"storage":   ParamsProfile,
+	}
+	if !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("sources = %v, want %v", sources, wantSources)
+	}
+}
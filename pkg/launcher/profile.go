@@ -0,0 +1,228 @@
+package launcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const DefaultProfileName = "default"
+
+// Profile is a set of templates a launch is rendered from.
+type Profile struct {
+	Name string
+
+	Job           *template.Template
+	Service       *template.Template
+	Ingress       *template.Template
+	PDB           *template.Template
+	NetworkPolicy *template.Template
+	HTTPRoute     *template.Template
+
+	// Istio routing for meshes
+	VirtualService  *template.Template
+	DestinationRule *template.Template
+
+	// Hooks are created before the job and after it completes
+	PreHook  *template.Template
+	PostHook *template.Template
+
+	// Artifacts renders the artifacts of a succeeded job for registration
+	Artifacts *template.Template
+
+	Settings ProfileSettings
+
+	// Hashes are the SHA-256 hashes of the template sources by template name
+	Hashes map[string]string
+
+	// Version identifies the templates and settings, see ProfileVersion
+	Version string
+
+	// sources are the template sources by template name, to store the
+	// profile version
+	sources map[string]string
+}
+
+// ProfileSettings adjust the rendered manifests of a profile. In a profiles
+// directory, they are read from profile.yaml.
+type ProfileSettings struct {
+	// ServiceType overrides the type of the rendered service
+	ServiceType corev1.ServiceType `yaml:"serviceType" json:"serviceType,omitempty"`
+	// NameServicePorts names the unnamed ports of the rendered service
+	NameServicePorts bool `yaml:"nameServicePorts" json:"nameServicePorts,omitempty"`
+	// Params are the profile's default template parameters
+	Params map[string]any `yaml:"params" json:"params,omitempty"`
+	// SecurityExceptions are the security defaults not injected into the
+	// profile's jobs, see SecurityDefaults
+	SecurityExceptions []string `yaml:"securityExceptions" json:"securityExceptions,omitempty"`
+	// Creation orders the creation steps of a launch and lets them wait for
+	// the readiness of others, see CreationSteps
+	Creation []CreationStep `yaml:"creation" json:"creation,omitempty"`
+	// Retention keeps PVCs and Secrets of the pre-launch hooks after the job
+	// completed, see RetentionPolicy
+	Retention RetentionPolicy `yaml:"retention" json:"retention,omitempty"`
+}
+
+// templates maps the template names, which are also the file names in a
+// profiles directory, to the profile's fields.
+func (p *Profile) templates() map[string]**template.Template {
+	return map[string]**template.Template{
+		"job":             &p.Job,
+		"service":         &p.Service,
+		"ingress":         &p.Ingress,
+		"pdb":             &p.PDB,
+		"networkpolicy":   &p.NetworkPolicy,
+		"httproute":       &p.HTTPRoute,
+		"virtualservice":  &p.VirtualService,
+		"destinationrule": &p.DestinationRule,
+		"pre-hook":        &p.PreHook,
+		"post-hook":       &p.PostHook,
+		"artifacts":       &p.Artifacts,
+	}
+}
+
+// TemplateFuncs are available in every template.
+var TemplateFuncs = template.FuncMap{
+	"dnsLabel": DNSLabel,
+}
+
+func ParseTemplateFile(name string, path string) (*template.Template, error) {
+	tmpl, _, err := parseTemplateFile(nil, name, path)
+	return tmpl, err
+}
+
+// parseTemplateFile parses a template file of fsys, or of the local file
+// system for a nil fsys, and returns its source.
+func parseTemplateFile(fsys fs.FS, name string, path string) (*template.Template, string, error) {
+	var str string
+	if fsys == nil {
+		var err error
+		if str, err = ReadToString(path); err != nil {
+			return nil, "", err
+		}
+	} else {
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading file %v: %w", path, err)
+		}
+		str = string(data)
+	}
+	tmpl, err := template.New(name).Funcs(TemplateFuncs).Parse(str)
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing %s template: %w", name, err)
+	}
+	return tmpl, str, nil
+}
+
+// LoadProfile reads a profile from template files keyed by template name.
+// Templates without a file are left out.
+func LoadProfile(name string, paths map[string]string) (*Profile, error) {
+	return loadProfile(nil, name, paths)
+}
+
+func loadProfile(fsys fs.FS, name string, paths map[string]string) (*Profile, error) {
+	p := &Profile{Name: name, Hashes: map[string]string{}, sources: map[string]string{}}
+	for tmplName, field := range p.templates() {
+		path, ok := paths[tmplName]
+		if !ok || path == "" {
+			continue
+		}
+		tmpl, source, err := parseTemplateFile(fsys, tmplName, path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading profile %s: %w", name, err)
+		}
+		*field = tmpl
+		hash := sha256.Sum256([]byte(source))
+		p.Hashes[tmplName] = hex.EncodeToString(hash[:])
+		p.sources[tmplName] = source
+	}
+
+	if p.Job == nil {
+		return nil, fmt.Errorf("profile %s has no job template", name)
+	}
+	return p, nil
+}
+
+// LoadProfilesDir reads every subdirectory of dir as a profile, with the
+// templates in <template name>.yaml files.
+func LoadProfilesDir(dir string) (map[string]*Profile, error) {
+	profiles, err := LoadProfilesFS(os.DirFS(dir))
+	if err != nil {
+		return nil, fmt.Errorf("error loading profiles directory %v: %w", dir, err)
+	}
+	return profiles, nil
+}
+
+// LoadProfilesFS reads every top-level directory of fsys as a profile, like
+// LoadProfilesDir.
+func LoadProfilesFS(fsys fs.FS) (map[string]*Profile, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := map[string]*Profile{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		profile, err := loadProfileDir(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		profiles[profile.Name] = profile
+		log.Printf("Loaded profile %s", profile.Name)
+	}
+
+	return profiles, nil
+}
+
+// loadProfileDir reads the profile in directory dir of fsys.
+func loadProfileDir(fsys fs.FS, dir string) (*Profile, error) {
+	paths := map[string]string{}
+	for tmplName := range (&Profile{}).templates() {
+		file := path.Join(dir, tmplName+".yaml")
+		if _, err := fs.Stat(fsys, file); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		paths[tmplName] = file
+	}
+
+	profile, err := loadProfile(fsys, path.Base(dir), paths)
+	if err != nil {
+		return nil, err
+	}
+
+	settingsPath := path.Join(dir, "profile.yaml")
+	if data, err := fs.ReadFile(fsys, settingsPath); err == nil {
+		if err := yaml.UnmarshalStrict(data, &profile.Settings); err != nil {
+			return nil, fmt.Errorf("error parsing profile settings %v: %w", settingsPath, err)
+		}
+		profile.Settings.Params = normalizeParams(profile.Settings.Params)
+		if err := ValidateServiceType(profile.Settings.ServiceType); err != nil {
+			return nil, fmt.Errorf("error in profile settings %v: %w", settingsPath, err)
+		}
+		if err := ValidateSecurityExceptions(profile.Settings.SecurityExceptions); err != nil {
+			return nil, fmt.Errorf("error in profile settings %v: %w", settingsPath, err)
+		}
+		if err := ValidateCreation(profile.Settings.Creation); err != nil {
+			return nil, fmt.Errorf("error in profile settings %v: %w", settingsPath, err)
+		}
+		if err := ValidateRetention(profile.Settings.Retention); err != nil {
+			return nil, fmt.Errorf("error in profile settings %v: %w", settingsPath, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading profile settings %v: %w", settingsPath, err)
+	}
+	return profile, nil
+}
@@ -0,0 +1,67 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Progress is what recorder pods report in their ProgressAnnotation, e.g.
+// {"bytesWritten": 1048576, "segments": 12}.
+type Progress struct {
+	BytesWritten int64 `json:"bytesWritten"`
+	Segments     int64 `json:"segments"`
+
+	// UpdatedAt is when the pod last reported, the pod's start time if unset
+	UpdatedAt *metav1.Time `json:"updatedAt,omitempty"`
+	Pod       string       `json:"pod,omitempty"`
+}
+
+// JobProgress returns the most recent progress report of the job's pods, or
+// nil if none of them reported any. Reports of earlier, retried pods are
+// ignored.
+func (s *LauncherService) JobProgress(ctx context.Context, job *batchv1.Job) (*Progress, error) {
+	selector := fmt.Sprintf("job-name=%s", job.Name)
+	if job.Spec.Selector != nil {
+		selector = metav1.FormatLabelSelector(job.Spec.Selector)
+	}
+	pods, err := s.Clientset.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods of job %s: %w", job.Name, err)
+	}
+
+	var latest *Progress
+	for _, pod := range pods.Items {
+		value, ok := pod.Annotations[ProgressAnnotation]
+		if !ok {
+			continue
+		}
+		progress := &Progress{}
+		if err := json.Unmarshal([]byte(value), progress); err != nil {
+			log.Printf("invalid progress annotation on pod %s: %v", pod.Name, err)
+			continue
+		}
+		progress.Pod = pod.Name
+		if progress.UpdatedAt == nil {
+			progress.UpdatedAt = pod.Status.StartTime
+		}
+
+		if latest == nil || newerProgress(progress, latest) {
+			latest = progress
+		}
+	}
+	return latest, nil
+}
+
+func newerProgress(a *Progress, b *Progress) bool {
+	if a.UpdatedAt == nil || b.UpdatedAt == nil {
+		return b.UpdatedAt == nil && a.UpdatedAt != nil
+	}
+	return b.UpdatedAt.Before(a.UpdatedAt)
+}
@@ -0,0 +1,281 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	PendingScheduled = "scheduled"
+	PendingQueued    = "queued"
+)
+
+// PendingLaunch is a launch that hasn't been created yet, either because it is
+// scheduled for later or because it waits for tenant limits or capacity.
+type PendingLaunch struct {
+	Tenant  string         `json:"tenant"`
+	VideoId string         `json:"videoId"`
+	Channel string         `json:"channel,omitempty"`
+	Profile string         `json:"profile,omitempty"`
+	Params  map[string]any `json:"params,omitempty"`
+
+	Reason    string    `json:"reason"`
+	NotBefore time.Time `json:"notBefore,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// TraceParent and RequestId of the request that queued the launch
+	TraceParent string `json:"traceParent,omitempty"`
+	RequestId   string `json:"requestId,omitempty"`
+
+	// LastError is why the last attempt of a queued launch was held back
+	LastError string `json:"lastError,omitempty"`
+}
+
+func (p *PendingLaunch) key() string {
+	return p.Tenant + "/" + p.VideoId
+}
+
+// LaunchQueue holds the pending launches, at most one per video of a tenant.
+type LaunchQueue interface {
+	// Add queues a launch, replacing a pending launch of the same video.
+	Add(ctx context.Context, p *PendingLaunch) error
+	// Remove takes the pending launch of a video out of the queue, or returns
+	// nil if there is none. Only one caller gets it, so a launch is either
+	// cancelled or started, never both.
+	Remove(ctx context.Context, tenant string, videoId string) (*PendingLaunch, error)
+	// List returns the pending launches of a tenant, or of all tenants for an
+	// empty tenant, oldest first.
+	List(tenant string) ([]*PendingLaunch, error)
+}
+
+// memoryQueue keeps pending launches in memory, they are lost on restart.
+type memoryQueue struct {
+	mu      sync.Mutex
+	pending map[string]*PendingLaunch
+}
+
+func NewLaunchQueue() LaunchQueue {
+	return &memoryQueue{
+		pending: map[string]*PendingLaunch{},
+	}
+}
+
+func (q *memoryQueue) Add(ctx context.Context, p *PendingLaunch) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending[p.key()] = p
+	return nil
+}
+
+func (q *memoryQueue) Remove(ctx context.Context, tenant string, videoId string) (*PendingLaunch, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := (&PendingLaunch{Tenant: tenant, VideoId: videoId}).key()
+	p := q.pending[key]
+	delete(q.pending, key)
+	return p, nil
+}
+
+func (q *memoryQueue) List(tenant string) ([]*PendingLaunch, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := []*PendingLaunch{}
+	for _, p := range q.pending {
+		if tenant == "" || p.Tenant == tenant {
+			copied := *p
+			pending = append(pending, &copied)
+		}
+	}
+	sortPending(pending)
+	return pending, nil
+}
+
+// storageQueue keeps pending launches in a Storage. Removals are only
+// exclusive within a replica, share the queue between replicas with a
+// ConfigMapQueue instead.
+type storageQueue struct {
+	mu    sync.Mutex
+	store Storage
+}
+
+func NewStorageQueue(store Storage) LaunchQueue {
+	return &storageQueue{store: store}
+}
+
+func (q *storageQueue) Add(ctx context.Context, p *PendingLaunch) error {
+	return q.store.Put(ctx, CollectionSchedules, p.key(), p)
+}
+
+func (q *storageQueue) Remove(ctx context.Context, tenant string, videoId string) (*PendingLaunch, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p := &PendingLaunch{Tenant: tenant, VideoId: videoId}
+	if ok, err := q.store.Get(ctx, CollectionSchedules, p.key(), p); err != nil || !ok {
+		return nil, err
+	}
+	if err := q.store.Delete(ctx, CollectionSchedules, p.key()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (q *storageQueue) List(tenant string) ([]*PendingLaunch, error) {
+	entries, err := q.store.List(context.Background(), CollectionSchedules)
+	if err != nil {
+		return nil, err
+	}
+	pending := []*PendingLaunch{}
+	for _, entry := range entries {
+		var p PendingLaunch
+		if err := json.Unmarshal(entry.Value, &p); err != nil {
+			log.Printf("skipping invalid pending launch %s: %v", entry.Key, err)
+			continue
+		}
+		if tenant == "" || p.Tenant == tenant {
+			pending = append(pending, &p)
+		}
+	}
+	sortPending(pending)
+	return pending, nil
+}
+
+func sortPending(pending []*PendingLaunch) {
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+}
+
+// removePending removes a pending launch from the queue, if there is one.
+func (s *LauncherService) removePending(ctx context.Context, tenant string, videoId string) (*PendingLaunch, error) {
+	if s.Queue == nil {
+		return nil, nil
+	}
+	return s.Queue.Remove(ctx, tenant, videoId)
+}
+
+// ListPending returns the tenant's pending launches, or those of every tenant
+// for a nil tenant.
+func (s *LauncherService) ListPending(tenant *Tenant) ([]*PendingLaunch, error) {
+	if s.Queue == nil {
+		return []*PendingLaunch{}, nil
+	} else if tenant == nil {
+		return s.Queue.List("")
+	}
+	return s.Queue.List(tenant.Name)
+}
+
+// enqueue adds a launch request to the queue.
+func (s *LauncherService) enqueue(ctx context.Context, req *LaunchRequest, reason string, cause error) (*LaunchResult, error) {
+	if s.Queue == nil {
+		return nil, fmt.Errorf("launch queue is not configured")
+	}
+
+	p := &PendingLaunch{
+		Tenant:    req.Tenant.Name,
+		VideoId:   req.VideoId,
+		Channel:   req.Channel,
+		Profile:   req.Profile,
+		Params:    req.Params,
+		Reason:    reason,
+		NotBefore: req.At,
+		CreatedAt: time.Now().UTC(),
+
+		TraceParent: req.TraceParent,
+		RequestId:   req.RequestId,
+	}
+	if cause != nil {
+		p.LastError = cause.Error()
+	}
+	if err := s.Queue.Add(ctx, p); err != nil {
+		return nil, fmt.Errorf("error queueing launch: %w", err)
+	}
+	log.Printf("launch of video %s of tenant %s is %s", req.VideoId, req.Tenant.Name, reason)
+
+	copied := *p
+	return &LaunchResult{Pending: &copied}, nil
+}
+
+// retryable errors keep a queued launch in the queue.
+func retryable(err error) bool {
+	var shortfallErr *QuotaShortfallError
+	return errors.Is(err, ErrPaused) || errors.Is(err, ErrAtCapacity) || (errors.Is(err, ErrQuotaExceeded) && !errors.As(err, &shortfallErr))
+}
+
+// RunQueue starts due pending launches every interval until ctx is done.
+func (s *LauncherService) RunQueue(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.processQueue(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *LauncherService) processQueue(ctx context.Context) {
+	pending, err := s.Queue.List("")
+	if err != nil {
+		log.Printf("error listing pending launches: %v", err)
+		return
+	}
+	queueDepth.Set(float64(len(pending)))
+
+	// Pending launches wait out maintenance in the queue
+	if s.checkPaused() != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, p := range pending {
+		if p.NotBefore.After(now) {
+			continue
+		}
+
+		// Claim the launch, it may have been cancelled or claimed by another
+		// replica in the meantime
+		claimed, err := s.Queue.Remove(ctx, p.Tenant, p.VideoId)
+		if err != nil {
+			log.Printf("error claiming pending launch of video %s of tenant %s: %v", p.VideoId, p.Tenant, err)
+			continue
+		} else if claimed == nil {
+			continue
+		}
+
+		tenant, ok := s.Tenants.Tenant(p.Tenant)
+		if !ok {
+			log.Printf("dropping pending launch of video %s of unknown tenant %s", p.VideoId, p.Tenant)
+			continue
+		}
+		_, err = s.Launch(ctx, &LaunchRequest{
+			Tenant:     tenant,
+			VideoId:    p.VideoId,
+			Channel:    p.Channel,
+			Profile:    p.Profile,
+			Params:     p.Params,
+			Idempotent: true,
+
+			TraceParent: p.TraceParent,
+			RequestId:   p.RequestId,
+		})
+		if retryable(err) {
+			claimed.Reason = PendingQueued
+			claimed.LastError = err.Error()
+			if err := s.Queue.Add(ctx, claimed); err != nil {
+				log.Printf("error requeueing launch of video %s of tenant %s: %v", p.VideoId, p.Tenant, err)
+			}
+		} else if err != nil {
+			log.Printf("error starting pending launch of video %s of tenant %s: %v", p.VideoId, p.Tenant, err)
+		} else {
+			log.Printf("started pending launch of video %s of tenant %s", p.VideoId, p.Tenant)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package launcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	PendingLabel   = "rewind.moe/pending-launch"
+	pendingDataKey = "launch.json"
+)
+
+// ConfigMapQueue keeps pending launches as ConfigMaps, so they survive
+// restarts and replicas share them. Lists come from a cache, which Start must
+// be called for first.
+type ConfigMapQueue struct {
+	clientset kubernetes.Interface
+	namespace string
+	lister    corelisters.ConfigMapLister
+}
+
+func NewConfigMapQueue(clientset kubernetes.Interface, namespace string) *ConfigMapQueue {
+	return &ConfigMapQueue{
+		clientset: clientset,
+		namespace: namespace,
+	}
+}
+
+// Start starts the cache of the pending launches.
+func (q *ConfigMapQueue) Start(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		q.clientset,
+		0,
+		informers.WithNamespace(q.namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = PendingLabel + "=true"
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps()
+	q.lister = informer.Lister()
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+		return fmt.Errorf("error syncing pending launches of namespace %s", q.namespace)
+	}
+	log.Printf("Pending launch cache of namespace %s synced", q.namespace)
+	return nil
+}
+
+func pendingConfigMapName(tenant string, videoId string) string {
+	hash := sha256.Sum256([]byte(tenant + "/" + videoId))
+	return "pending-launch-" + hex.EncodeToString(hash[:8])
+}
+
+func (q *ConfigMapQueue) Add(ctx context.Context, p *PendingLaunch) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{PendingLabel: "true", TenantLabel: p.Tenant}
+	for k, v := range DefaultLabels {
+		labels[k] = v
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pendingConfigMapName(p.Tenant, p.VideoId),
+			Labels:      labels,
+			Annotations: map[string]string{VideoIdAnnotation: p.VideoId},
+		},
+		Data: map[string]string{pendingDataKey: string(data)},
+	}
+
+	client := q.clientset.CoreV1().ConfigMaps(q.namespace)
+	_, err = client.Create(ctx, cm, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, getErr := client.Get(ctx, cm.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		cm.ResourceVersion = existing.ResourceVersion
+		_, err = client.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+func (q *ConfigMapQueue) Remove(ctx context.Context, tenant string, videoId string) (*PendingLaunch, error) {
+	client := q.clientset.CoreV1().ConfigMaps(q.namespace)
+	cm, err := client.Get(ctx, pendingConfigMapName(tenant, videoId), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	p, err := decodePending(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whoever deletes the ConfigMap claims the launch
+	err = client.Delete(ctx, cm.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &cm.UID, ResourceVersion: &cm.ResourceVersion},
+	})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (q *ConfigMapQueue) List(tenant string) ([]*PendingLaunch, error) {
+	selector := labels.SelectorFromSet(labels.Set{PendingLabel: "true"})
+	configMaps, err := q.lister.ConfigMaps(q.namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []*PendingLaunch{}
+	for _, cm := range configMaps {
+		p, err := decodePending(cm)
+		if err != nil {
+			log.Printf("skipping pending launch %s: %v", cm.Name, err)
+			continue
+		}
+		if tenant == "" || p.Tenant == tenant {
+			pending = append(pending, p)
+		}
+	}
+	sortPending(pending)
+	return pending, nil
+}
+
+func decodePending(cm *corev1.ConfigMap) (*PendingLaunch, error) {
+	p := &PendingLaunch{}
+	if err := json.Unmarshal([]byte(cm.Data[pendingDataKey]), p); err != nil {
+		return nil, fmt.Errorf("invalid pending launch: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,134 @@
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QuotaShortfall is a resource a launch needs more of than a ResourceQuota
+// has left.
+type QuotaShortfall struct {
+	Quota     string `json:"quota"`
+	Resource  string `json:"resource"`
+	Requested string `json:"requested"`
+	Available string `json:"available"`
+}
+
+// QuotaShortfallError is returned when a job would exceed a ResourceQuota.
+type QuotaShortfallError struct {
+	Shortfalls []QuotaShortfall
+}
+
+func (e *QuotaShortfallError) Error() string {
+	var parts []string
+	for _, s := range e.Shortfalls {
+		parts = append(parts, fmt.Sprintf("%s needs %s of %s but %s is available", s.Quota, s.Requested, s.Resource, s.Available))
+	}
+	return fmt.Sprintf("%v: %s", ErrQuotaExceeded, strings.Join(parts, ", "))
+}
+
+func (e *QuotaShortfallError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// jobResources returns what a job counts against ResourceQuotas once all its
+// pods run.
+func jobResources(job *batchv1.Job) corev1.ResourceList {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	spec := job.Spec.Template.Spec
+	for _, c := range spec.Containers {
+		addResources(requests, c.Resources.Requests)
+		addResources(limits, c.Resources.Limits)
+	}
+	// Init containers run one at a time before the others
+	for _, c := range spec.InitContainers {
+		maxResources(requests, c.Resources.Requests)
+		maxResources(limits, c.Resources.Limits)
+	}
+
+	pods := int64(1)
+	if job.Spec.Parallelism != nil {
+		pods = int64(*job.Spec.Parallelism)
+	}
+
+	usage := corev1.ResourceList{
+		corev1.ResourcePods:                     *resource.NewQuantity(pods, resource.DecimalSI),
+		corev1.ResourceName("count/jobs.batch"): *resource.NewQuantity(1, resource.DecimalSI),
+	}
+	for name, q := range requests {
+		total := *resource.NewMilliQuantity(q.MilliValue()*pods, q.Format)
+		usage[name] = total
+		usage[corev1.ResourceName("requests."+string(name))] = total
+	}
+	for name, q := range limits {
+		usage[corev1.ResourceName("limits."+string(name))] = *resource.NewMilliQuantity(q.MilliValue()*pods, q.Format)
+	}
+	return usage
+}
+
+func addResources(total corev1.ResourceList, add corev1.ResourceList) {
+	for name, q := range add {
+		sum := total[name]
+		sum.Add(q)
+		total[name] = sum
+	}
+}
+
+func maxResources(total corev1.ResourceList, other corev1.ResourceList) {
+	for name, q := range other {
+		if current, ok := total[name]; !ok || q.Cmp(current) > 0 {
+			total[name] = q.DeepCopy()
+		}
+	}
+}
+
+// checkResourceQuotas returns a QuotaShortfallError if the job does not fit
+// into the namespace's ResourceQuotas. Scoped quotas are not checked.
+func (s *LauncherService) checkResourceQuotas(ctx context.Context, namespace string, job *batchv1.Job) error {
+	quotas, err := s.Clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing resource quotas: %w", err)
+	}
+
+	usage := jobResources(job)
+	var shortfalls []QuotaShortfall
+	for _, quota := range quotas.Items {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+		for name, hard := range quota.Status.Hard {
+			requested, ok := usage[name]
+			if !ok {
+				continue
+			}
+			available := hard.DeepCopy()
+			available.Sub(quota.Status.Used[name])
+			if requested.Cmp(available) > 0 {
+				shortfalls = append(shortfalls, QuotaShortfall{
+					Quota:     quota.Name,
+					Resource:  string(name),
+					Requested: requested.String(),
+					Available: available.String(),
+				})
+			}
+		}
+	}
+
+	if len(shortfalls) > 0 {
+		sort.Slice(shortfalls, func(i, j int) bool {
+			if shortfalls[i].Quota != shortfalls[j].Quota {
+				return shortfalls[i].Quota < shortfalls[j].Quota
+			}
+			return shortfalls[i].Resource < shortfalls[j].Resource
+		})
+		return &QuotaShortfallError{Shortfalls: shortfalls}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package launcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Permission is a verb on a resource in a namespace.
+type Permission struct {
+	Namespace string `json:"namespace"`
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource"`
+	Verb      string `json:"verb"`
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	return fmt.Sprintf("%s %s in namespace %s", p.Verb, resource, p.Namespace)
+}
+
+// MissingPermissionsError lists every permission the launcher lacks.
+type MissingPermissionsError struct {
+	Missing []Permission
+}
+
+func (e *MissingPermissionsError) Error() string {
+	lines := make([]string, len(e.Missing))
+	for i, p := range e.Missing {
+		lines[i] = "  " + p.String()
+	}
+	return fmt.Sprintf("service account is missing %d permissions:\n%s", len(e.Missing), strings.Join(lines, "\n"))
+}
+
+// requiredResources returns the verbs needed per resource, based on the
+// loaded templates and enabled features.
+func (s *LauncherService) requiredResources() map[schema.GroupResource]map[string]bool {
+	required := map[schema.GroupResource]map[string]bool{}
+	add := func(gr schema.GroupResource, verbs ...string) {
+		if required[gr] == nil {
+			required[gr] = map[string]bool{}
+		}
+		for _, verb := range verbs {
+			required[gr][verb] = true
+		}
+	}
+	addKind := func(gk schema.GroupKind, verbs ...string) {
+		mapping, err := s.Mapper.RESTMapping(gk)
+		if err != nil {
+			log.Printf("Skipping permission check of %s: %v", gk, err)
+			return
+		}
+		add(mapping.Resource.GroupResource(), verbs...)
+	}
+
+	add(schema.GroupResource{Group: "batch", Resource: "jobs"}, "create", "get", "list", "watch", "update", "patch", "delete")
+	add(schema.GroupResource{Resource: "pods"}, "list", "patch", "delete")
+
+	// Cleanup looks for these even when no profile creates them
+	add(schema.GroupResource{Resource: "services"}, "list", "delete")
+	add(schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}, "list", "delete")
+	add(schema.GroupResource{Group: "networking.k8s.io", Resource: "networkpolicies"}, "list", "delete")
+	add(schema.GroupResource{Group: "policy", Resource: "poddisruptionbudgets"}, "list", "delete")
+
+	for _, p := range s.allProfiles() {
+		if p.Service != nil {
+			add(schema.GroupResource{Resource: "services"}, "create")
+		}
+		if p.Ingress != nil {
+			add(schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}, "create")
+		}
+		if p.NetworkPolicy != nil {
+			add(schema.GroupResource{Group: "networking.k8s.io", Resource: "networkpolicies"}, "create")
+		}
+		if p.PDB != nil {
+			add(schema.GroupResource{Group: "policy", Resource: "poddisruptionbudgets"}, "create")
+		}
+		for gk, tmpl := range map[schema.GroupKind]bool{
+			HTTPRouteGroupKind:       p.HTTPRoute != nil,
+			VirtualServiceGroupKind:  p.VirtualService != nil,
+			DestinationRuleGroupKind: p.DestinationRule != nil,
+		} {
+			if tmpl && s.Mapper != nil {
+				addKind(gk, "create", "list", "delete")
+				if s.RestoreWindow > 0 {
+					addKind(gk, "patch")
+				}
+			}
+		}
+
+		// Readiness of the steps others wait for
+		for _, step := range p.Settings.Creation {
+			for _, dep := range step.WaitFor {
+				switch dep {
+				case StepService:
+					add(schema.GroupResource{Resource: "endpoints"}, "get")
+				case StepIngress:
+					add(schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}, "get")
+				}
+			}
+		}
+
+		// Render the pre-hooks with a placeholder video to learn their kinds
+		if p.PreHook != nil && s.Mapper != nil {
+			objects, err := NewHookObjectsFromTemplate(p.PreHook, &TemplateSpec{
+				VideoId:   "permission-check",
+				Tenant:    DefaultTenantName,
+				Profile:   p.Name,
+				Namespace: s.Namespace,
+				naming:    s.naming,
+			})
+			if err != nil {
+				log.Printf("Skipping permission check of pre-hooks of profile %s: %v", p.Name, err)
+			}
+			for _, obj := range objects {
+				addKind(obj.GroupVersionKind().GroupKind(), "create", "delete")
+			}
+		}
+
+		// Retained hook resources are marked, then found again by the reaper
+		for kind := range p.Settings.Retention {
+			resource := "secrets"
+			if kind == KindPersistentVolumeClaim {
+				resource = "persistentvolumeclaims"
+			}
+			add(schema.GroupResource{Resource: resource}, "patch", "list", "delete")
+		}
+	}
+
+	if r, ok := s.Artifacts.(*ResourceArtifactRegistrar); ok && s.Mapper != nil {
+		addKind(r.Kind.GroupKind(), "create")
+	}
+	if s.Credentials != nil {
+		add(schema.GroupResource{Resource: "secrets"}, "create", "get", "list", "delete")
+	}
+	if s.Capacity != nil {
+		add(schema.GroupResource{Resource: "pods"}, "watch")
+	}
+	if _, ok := s.Queue.(*ConfigMapQueue); ok {
+		add(schema.GroupResource{Resource: "configmaps"}, "create", "get", "list", "watch", "update", "delete")
+	}
+	if s.Chaos {
+		add(schema.GroupResource{Group: "batch", Resource: "jobs/status"}, "update")
+		add(schema.GroupResource{Resource: "pods"}, "delete")
+	}
+	if s.IngressReadiness != nil {
+		add(schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}, "watch", "patch")
+	}
+	if s.RestoreWindow > 0 {
+		for _, gr := range []schema.GroupResource{
+			{Resource: "services"},
+			{Group: "networking.k8s.io", Resource: "ingresses"},
+			{Group: "networking.k8s.io", Resource: "networkpolicies"},
+			{Group: "policy", Resource: "poddisruptionbudgets"},
+		} {
+			add(gr, "patch")
+		}
+	}
+	if s.CheckResourceQuotas {
+		add(schema.GroupResource{Resource: "resourcequotas"}, "list")
+	}
+	if s.DryRunValidate {
+		add(schema.GroupResource{Resource: "services"}, "create")
+		add(schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}, "create")
+	}
+
+	return required
+}
+
+// CheckPermissions asks the API server whether the launcher may do everything
+// its templates and features need in every namespace it launches into, and
+// returns a MissingPermissionsError listing what it may not.
+func (s *LauncherService) CheckPermissions(ctx context.Context) error {
+	if s.Fake {
+		return nil
+	}
+
+	var missing []Permission
+	for _, ns := range s.Tenants.Namespaces(s.Namespace) {
+		for gr, verbs := range s.requiredResources() {
+			// Subresources are given as <resource>/<subresource>
+			resource, subresource, _ := strings.Cut(gr.Resource, "/")
+			for verb := range verbs {
+				review, err := s.Clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+					Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+						ResourceAttributes: &authorizationv1.ResourceAttributes{
+							Namespace:   ns,
+							Group:       gr.Group,
+							Resource:    resource,
+							Subresource: subresource,
+							Verb:        verb,
+						},
+					},
+				}, metav1.CreateOptions{})
+				if err != nil {
+					return fmt.Errorf("error checking permission to %s %s: %w", verb, gr, err)
+				}
+				if !review.Status.Allowed {
+					missing = append(missing, Permission{Namespace: ns, Group: gr.Group, Resource: gr.Resource, Verb: verb})
+				}
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].String() < missing[j].String()
+	})
+	return &MissingPermissionsError{Missing: missing}
+}
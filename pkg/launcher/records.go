@@ -0,0 +1,454 @@
+package launcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	OutcomeRunning   = "running"
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	// OutcomeCancelled is the outcome of jobs torn down while running
+	OutcomeCancelled = "cancelled"
+)
+
+type LaunchRecord struct {
+	Namespace  string     `json:"namespace"`
+	Job        string     `json:"job"`
+	VideoId    string     `json:"videoId"`
+	Channel    string     `json:"channel,omitempty"`
+	Tenant     string     `json:"tenant"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Outcome    string     `json:"outcome"`
+}
+
+func (r *LaunchRecord) key() string {
+	return r.Namespace + "/" + r.Job
+}
+
+// LaunchHistory keeps a record of every launch and its outcome. When backed
+// by a file, every change is appended as a JSON line and the file is
+// compacted on startup. When backed by a Storage, every change is stored, and
+// records are read back from it, so replicas sharing it see each other's.
+type LaunchHistory struct {
+	// MaxAge drops records of launches that finished longer ago, and
+	// MaxRecords the oldest records beyond that many. Zero keeps records
+	// forever. Pruning sorts every record, so it only runs on writes once
+	// the records grew 10% past MaxRecords, or LaunchHistoryPruneInterval
+	// after it last ran.
+	MaxAge     time.Duration
+	MaxRecords int
+
+	mu       sync.Mutex
+	records  map[string]*LaunchRecord
+	file     *os.File
+	store    Storage
+	prunedAt time.Time
+
+	// revision counts the changes of the records since epoch, for ETags
+	epoch    int64
+	revision uint64
+}
+
+// LaunchHistoryPruneInterval is how often launch records past MaxAge are
+// dropped.
+var LaunchHistoryPruneInterval = time.Minute
+
+// NewStorageLaunchHistory reads back the records of a Storage and stores
+// every change in it.
+func NewStorageLaunchHistory(ctx context.Context, store Storage) (*LaunchHistory, error) {
+	h := &LaunchHistory{store: store, epoch: time.Now().UnixNano()}
+	if err := h.reload(ctx); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// reload replaces the records with the stored ones, which other replicas
+// sharing the Storage may have changed.
+func (h *LaunchHistory) reload(ctx context.Context) error {
+	if h.store == nil {
+		return nil
+	}
+	entries, err := h.store.List(ctx, CollectionLaunches)
+	if err != nil {
+		return fmt.Errorf("error reading launch history: %w", err)
+	}
+	records := make(map[string]*LaunchRecord, len(entries))
+	for _, entry := range entries {
+		var record LaunchRecord
+		if err := json.Unmarshal(entry.Value, &record); err != nil {
+			log.Printf("skipping invalid launch record %s: %v", entry.Key, err)
+			continue
+		}
+		records[record.key()] = &record
+	}
+	if !sameRecords(h.records, records) {
+		h.revision++
+	}
+	h.records = records
+	return nil
+}
+
+func sameRecords(a map[string]*LaunchRecord, b map[string]*LaunchRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, record := range a {
+		other, ok := b[key]
+		if !ok || !reflect.DeepEqual(record, other) {
+			return false
+		}
+	}
+	return true
+}
+
+func NewLaunchHistory(path string) (*LaunchHistory, error) {
+	h := &LaunchHistory{
+		records: map[string]*LaunchRecord{},
+		epoch:   time.Now().UnixNano(),
+	}
+	if path == "" {
+		return h, nil
+	}
+
+	// Read back persisted records, later lines replace earlier ones
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record LaunchRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				log.Printf("skipping invalid launch history line: %v", err)
+				continue
+			}
+			h.records[record.key()] = &record
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading launch history %v: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading launch history %v: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening launch history %v: %w", path, err)
+	}
+	h.file = f
+	for _, record := range h.sorted() {
+		h.persist(record)
+	}
+
+	return h, nil
+}
+
+func (h *LaunchHistory) persist(record *LaunchRecord) {
+	if h.store != nil {
+		if err := h.store.Put(context.Background(), CollectionLaunches, record.key(), record); err != nil {
+			log.Printf("error storing launch record: %v", err)
+		}
+	}
+	if h.file == nil {
+		return
+	}
+	data, err := json.Marshal(record)
+	if err == nil {
+		_, err = h.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("error persisting launch record: %v", err)
+	}
+}
+
+func (h *LaunchHistory) sorted() []*LaunchRecord {
+	records := make([]*LaunchRecord, 0, len(h.records))
+	for _, record := range h.records {
+		records = append(records, record)
+	}
+	// Ties are broken by key, so that pages of an export line up
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].StartedAt.Equal(records[j].StartedAt) {
+			return records[i].StartedAt.Before(records[j].StartedAt)
+		}
+		return records[i].key() < records[j].key()
+	})
+	return records
+}
+
+func (h *LaunchHistory) Add(record *LaunchRecord) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records[record.key()] = record
+	h.revision++
+	h.persist(record)
+	h.prune()
+}
+
+// Finish records the outcome of a finished job, if it isn't known yet.
+func (h *LaunchHistory) Finish(job *batchv1.Job) {
+	if h == nil {
+		return
+	}
+
+	var outcome string
+	var finishedAt time.Time
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			outcome, finishedAt = OutcomeSucceeded, c.LastTransitionTime.Time
+		case batchv1.JobFailed:
+			outcome, finishedAt = OutcomeFailed, c.LastTransitionTime.Time
+		}
+	}
+	if outcome == "" {
+		return
+	}
+	h.finish(job, outcome, finishedAt)
+}
+
+// Cancel records that a job was torn down, if its outcome isn't known yet.
+// The deletion of torn down jobs isn't watched, they would be left running.
+func (h *LaunchHistory) Cancel(job *batchv1.Job) {
+	if h == nil {
+		return
+	}
+	h.Finish(job)
+	h.finish(job, OutcomeCancelled, time.Now().UTC())
+}
+
+func (h *LaunchHistory) finish(job *batchv1.Job, outcome string, finishedAt time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := job.Namespace + "/" + job.Name
+	record, ok := h.records[key]
+	if !ok && h.store != nil {
+		// Launched by another replica
+		var stored LaunchRecord
+		if found, err := h.store.Get(context.Background(), CollectionLaunches, key, &stored); err != nil {
+			log.Printf("error reading launch record: %v", err)
+		} else if found {
+			record, ok = &stored, true
+			h.records[key] = record
+		}
+	}
+	if !ok {
+		// Launched before history was kept
+		record = &LaunchRecord{
+			Namespace: job.Namespace,
+			Job:       job.Name,
+			VideoId:   job.Labels[VideoIdLabel],
+			Tenant:    job.Labels[TenantLabel],
+			StartedAt: job.CreationTimestamp.Time,
+		}
+		h.records[record.key()] = record
+	} else if record.Outcome != OutcomeRunning {
+		return
+	}
+
+	record.Outcome = outcome
+	record.FinishedAt = &finishedAt
+	h.revision++
+	h.persist(record)
+	h.prune()
+}
+
+// prune drops the records beyond MaxAge and MaxRecords, oldest first, once
+// it is due. Running launches are only dropped beyond MaxRecords.
+func (h *LaunchHistory) prune() {
+	if h.MaxAge <= 0 && h.MaxRecords <= 0 {
+		return
+	}
+	slack := h.MaxRecords / 10
+	if slack < 1 {
+		slack = 1
+	}
+	due := h.MaxRecords > 0 && len(h.records) > h.MaxRecords+slack
+	if h.MaxAge > 0 && time.Since(h.prunedAt) >= LaunchHistoryPruneInterval {
+		due = true
+	}
+	if !due {
+		return
+	}
+	h.prunedAt = time.Now()
+
+	cutoff := time.Now().Add(-h.MaxAge)
+	removed := 0
+	for _, record := range h.sorted() {
+		expired := h.MaxAge > 0 && record.FinishedAt != nil && record.FinishedAt.Before(cutoff)
+		if !expired && (h.MaxRecords <= 0 || len(h.records) <= h.MaxRecords) {
+			continue
+		}
+		delete(h.records, record.key())
+		removed++
+		if h.store != nil {
+			if err := h.store.Delete(context.Background(), CollectionLaunches, record.key()); err != nil {
+				log.Printf("error deleting launch record: %v", err)
+			}
+		}
+	}
+	if removed > 0 {
+		h.revision++
+		if err := h.rewrite(); err != nil {
+			log.Printf("error pruning launch history: %v", err)
+		}
+	}
+}
+
+// rewrite replaces the contents of the file with the current records.
+func (h *LaunchHistory) rewrite() error {
+	if h.file == nil {
+		return nil
+	}
+	if err := h.file.Truncate(0); err != nil {
+		return fmt.Errorf("error rewriting launch history: %w", err)
+	}
+	if _, err := h.file.Seek(0, 0); err != nil {
+		return fmt.Errorf("error rewriting launch history: %w", err)
+	}
+	for _, record := range h.sorted() {
+		h.persist(record)
+	}
+	return nil
+}
+
+// Records returns the records of a tenant, or of all tenants for an empty
+// tenant, oldest first.
+func (h *LaunchHistory) Records(tenant string) []LaunchRecord {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.reload(context.Background()); err != nil {
+		log.Printf("%v, listing the records of this replica", err)
+	}
+
+	var records []LaunchRecord
+	for _, record := range h.sorted() {
+		if tenant == "" || record.Tenant == tenant {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+type OutcomeStats struct {
+	Key             string  `json:"key"`
+	Attempts        int     `json:"attempts"`
+	Successes       int     `json:"successes"`
+	Failures        int     `json:"failures"`
+	Cancelled       int     `json:"cancelled"`
+	Running         int     `json:"running"`
+	AverageDuration float64 `json:"averageDurationSeconds"`
+}
+
+// Stats aggregates the outcomes of records grouped by key.
+func Stats(records []LaunchRecord, key func(*LaunchRecord) string) []*OutcomeStats {
+	byKey := map[string]*OutcomeStats{}
+	durations := map[string]time.Duration{}
+	for i := range records {
+		r := &records[i]
+		k := key(r)
+		if k == "" {
+			continue
+		}
+		stats, ok := byKey[k]
+		if !ok {
+			stats = &OutcomeStats{Key: k}
+			byKey[k] = stats
+		}
+
+		stats.Attempts++
+		switch r.Outcome {
+		case OutcomeSucceeded:
+			stats.Successes++
+		case OutcomeFailed:
+			stats.Failures++
+		case OutcomeCancelled:
+			// Cut short, the duration says nothing about the recording
+			stats.Cancelled++
+			continue
+		default:
+			stats.Running++
+		}
+		if r.FinishedAt != nil {
+			durations[k] += r.FinishedAt.Sub(r.StartedAt)
+		}
+	}
+
+	result := make([]*OutcomeStats, 0, len(byKey))
+	for k, stats := range byKey {
+		if finished := stats.Successes + stats.Failures; finished > 0 {
+			stats.AverageDuration = (durations[k] / time.Duration(finished)).Seconds()
+		}
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// Forget removes the records of a tenant's video and returns how many were
+// removed. Records are only removed from memory once they are deleted from
+// the store, so a failed Forget can be retried.
+func (h *LaunchHistory) Forget(tenant string, videoId string) (int, error) {
+	if h == nil {
+		return 0, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.reload(context.Background()); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	defer func() {
+		if removed > 0 {
+			h.revision++
+		}
+	}()
+	for key, record := range h.records {
+		if record.Tenant != tenant || record.VideoId != videoId {
+			continue
+		}
+		if h.store != nil {
+			if err := h.store.Delete(context.Background(), CollectionLaunches, key); err != nil {
+				return removed, fmt.Errorf("error deleting launch record: %w", err)
+			}
+		}
+		delete(h.records, key)
+		removed++
+	}
+
+	if removed > 0 {
+		if err := h.rewrite(); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,45 @@
+package launcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLaunchHistoryRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "launches.jsonl")
+	h, err := NewLaunchHistory(path)
+	if err != nil {
+		t.Fatalf("NewLaunchHistory: %v", err)
+	}
+	h.MaxAge = 24 * time.Hour
+	h.MaxRecords = 2
+
+	now := time.Now().UTC()
+	old := now.Add(-48 * time.Hour)
+	h.Add(&LaunchRecord{Namespace: "test", Job: "old", VideoId: "abc", StartedAt: old.Add(-time.Hour), FinishedAt: &old, Outcome: OutcomeSucceeded})
+	h.Add(&LaunchRecord{Namespace: "test", Job: "a", VideoId: "def", StartedAt: now.Add(-4 * time.Minute), Outcome: OutcomeRunning})
+	if records := h.Records(""); len(records) != 1 || records[0].Job != "a" {
+		t.Fatalf("records = %+v, want the expired one dropped", records)
+	}
+
+	// Records are only pruned once they grew past MaxRecords by the slack
+	h.Add(&LaunchRecord{Namespace: "test", Job: "b", VideoId: "ghi", StartedAt: now.Add(-3 * time.Minute), Outcome: OutcomeRunning})
+	h.Add(&LaunchRecord{Namespace: "test", Job: "c", VideoId: "jkl", StartedAt: now.Add(-2 * time.Minute), Outcome: OutcomeRunning})
+	if records := h.Records(""); len(records) != 3 {
+		t.Fatalf("records = %+v, want a, b and c within the slack", records)
+	}
+	h.Add(&LaunchRecord{Namespace: "test", Job: "d", VideoId: "mno", StartedAt: now.Add(-time.Minute), Outcome: OutcomeRunning})
+	if records := h.Records(""); len(records) != 2 || records[0].Job != "c" || records[1].Job != "d" {
+		t.Fatalf("records = %+v, want c and d", records)
+	}
+
+	// The file only holds what was kept
+	h, err = NewLaunchHistory(path)
+	if err != nil {
+		t.Fatalf("NewLaunchHistory: %v", err)
+	}
+	if records := h.Records(""); len(records) != 2 || records[0].Job != "c" || records[1].Job != "d" {
+		t.Errorf("records after reopening = %+v, want c and d", records)
+	}
+}
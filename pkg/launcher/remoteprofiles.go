@@ -0,0 +1,580 @@
+package launcher
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProfileSourceOCI = "oci"
+	ProfileSourceGit = "git"
+
+	// MaxProfileArchiveSize limits the size of the profiles fetched from an
+	// OCI artifact
+	MaxProfileArchiveSize = 64 << 20
+)
+
+var (
+	gitCommitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	ociDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+// RemoteProfiles fetches a profiles directory, laid out as for
+// LoadProfilesDir, from an OCI artifact or a Git repository, so template
+// changes can ship through an artifact pipeline. A reference pinned to a
+// digest or commit is fetched once, others are resolved again on every
+// Refresh and fetched when they moved.
+type RemoteProfiles struct {
+	// Ref is oci://<registry>/<repository>[:<tag>|@<digest>], or
+	// git+<url>[#<branch, tag or commit>]
+	Ref string
+	// Path is the profiles directory within the artifact or repository
+	Path string
+	// Credentials are <user>:<password> for the registry, if it needs any.
+	// Git uses its own credential helpers.
+	Credentials string
+
+	Client *http.Client
+
+	mu     sync.Mutex
+	dir    string
+	status ProfileSourceStatus
+}
+
+// ProfileSourceStatus tells where the profiles were last fetched from.
+type ProfileSourceStatus struct {
+	Type   string `json:"type"`
+	Ref    string `json:"ref"`
+	Path   string `json:"path,omitempty"`
+	Pinned bool   `json:"pinned"`
+
+	// Resolved is the digest or commit the fetched profiles are from
+	Resolved  string     `json:"resolved,omitempty"`
+	FetchedAt *time.Time `json:"fetchedAt,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	// Error is that of the last refresh, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+func NewRemoteProfiles(ref string, profilesPath string, credentials string) (*RemoteProfiles, error) {
+	profilesPath = strings.Trim(profilesPath, "/")
+	r := &RemoteProfiles{
+		Ref:         ref,
+		Path:        profilesPath,
+		Credentials: credentials,
+		Client:      &http.Client{Timeout: time.Minute},
+	}
+	r.status = ProfileSourceStatus{Ref: ref, Path: profilesPath}
+	switch {
+	case strings.HasPrefix(ref, "oci://"):
+		o, err := parseOCIRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		r.status.Type = ProfileSourceOCI
+		r.status.Pinned = ociDigestPattern.MatchString(o.reference)
+	case strings.HasPrefix(ref, "git+"):
+		_, rev, err := parseGitRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		r.status.Type = ProfileSourceGit
+		r.status.Pinned = gitCommitPattern.MatchString(rev)
+	default:
+		return nil, fmt.Errorf("invalid profiles source %q: must start with oci:// or git+", ref)
+	}
+	if profilesPath != "" && !fsValidPath(profilesPath) {
+		return nil, fmt.Errorf("invalid profiles source path %q", profilesPath)
+	}
+	return r, nil
+}
+
+// fsValidPath tells whether p is a relative path that stays within its root.
+func fsValidPath(p string) bool {
+	clean := path.Clean(p)
+	return clean == p && !path.IsAbs(p) && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// Dir returns the local directory of the last fetched profiles.
+func (r *RemoteProfiles) Dir() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dir == "" {
+		return ""
+	}
+	return filepath.Join(r.dir, filepath.FromSlash(r.Path))
+}
+
+func (r *RemoteProfiles) Status() ProfileSourceStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Refresh resolves the reference, and fetches the profiles when it resolves
+// to another digest or commit than last time. It tells whether it fetched
+// them.
+func (r *RemoteProfiles) Refresh(ctx context.Context) (bool, error) {
+	changed, err := r.refresh(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	r.status.CheckedAt = &now
+	r.status.Error = ""
+	if err != nil {
+		r.status.Error = err.Error()
+	}
+	return changed, err
+}
+
+func (r *RemoteProfiles) refresh(ctx context.Context) (bool, error) {
+	var resolved string
+	var fetch func(ctx context.Context, dir string) error
+	var err error
+	switch r.status.Type {
+	case ProfileSourceOCI:
+		resolved, fetch, err = r.resolveOCI(ctx)
+	case ProfileSourceGit:
+		resolved, fetch, err = r.resolveGit(ctx)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error resolving profiles source %s: %w", r.Ref, err)
+	}
+	if current := r.Status(); current.Resolved == resolved && r.Dir() != "" {
+		return false, nil
+	}
+
+	dir, err := os.MkdirTemp("", "launcher-profiles-")
+	if err != nil {
+		return false, err
+	}
+	if err := fetch(ctx, dir); err != nil {
+		os.RemoveAll(dir)
+		return false, fmt.Errorf("error fetching profiles source %s at %s: %w", r.Ref, resolved, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(r.Path))); err != nil || !info.IsDir() {
+		os.RemoveAll(dir)
+		return false, fmt.Errorf("profiles source %s at %s has no directory %q", r.Ref, resolved, r.Path)
+	}
+
+	r.mu.Lock()
+	previous := r.dir
+	now := time.Now().UTC()
+	r.dir = dir
+	r.status.Resolved = resolved
+	r.status.FetchedAt = &now
+	r.mu.Unlock()
+
+	if previous != "" {
+		os.RemoveAll(previous)
+	}
+	log.Printf("Fetched profiles from %s at %s", r.Ref, resolved)
+	return true, nil
+}
+
+// StartProfileRefresh refreshes RemoteProfiles every interval, and loads the
+// profiles as new versions with ReloadProfiles when they changed. Like other
+// reloads, new versions of existing profiles wait for a rollout.
+func (s *LauncherService) StartProfileRefresh(ctx context.Context, interval time.Duration) {
+	if s.RemoteProfiles.Status().Pinned {
+		log.Printf("Profiles source %s is pinned, not refreshing it", s.RemoteProfiles.Ref)
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				changed, err := s.RemoteProfiles.Refresh(ctx)
+				if err != nil {
+					profileSourceRefreshes.WithLabelValues("error").Inc()
+					log.Printf("error refreshing profiles: %v", err)
+					continue
+				}
+				if !changed {
+					profileSourceRefreshes.WithLabelValues("unchanged").Inc()
+					continue
+				}
+				profileSourceRefreshes.WithLabelValues("updated").Inc()
+				if _, err := s.ReloadProfiles(); err != nil {
+					log.Printf("error reloading profiles from %s: %v", s.RemoteProfiles.Ref, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+type ociRef struct {
+	registry   string
+	repository string
+	// reference is a tag or a digest
+	reference string
+}
+
+func parseOCIRef(ref string) (ociRef, error) {
+	rest := strings.TrimPrefix(ref, "oci://")
+	registry, repository, ok := strings.Cut(rest, "/")
+	if !ok || registry == "" || repository == "" {
+		return ociRef{}, fmt.Errorf("invalid OCI reference %q: must be oci://<registry>/<repository>[:<tag>|@<digest>]", ref)
+	}
+	o := ociRef{registry: registry, repository: repository, reference: "latest"}
+	if name, digest, ok := strings.Cut(repository, "@"); ok {
+		if !ociDigestPattern.MatchString(digest) {
+			return ociRef{}, fmt.Errorf("invalid OCI reference %q: digest must be sha256:<hex>", ref)
+		}
+		o.repository, o.reference = name, digest
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		o.repository, o.reference = repository[:i], repository[i+1:]
+	}
+	if o.repository == "" || o.reference == "" {
+		return ociRef{}, fmt.Errorf("invalid OCI reference %q", ref)
+	}
+	return o, nil
+}
+
+// url returns the URL of an API path of the repository. Registries on
+// loopback addresses are spoken to over plain HTTP, for local registries.
+func (o ociRef) url(apiPath string) string {
+	scheme := "https"
+	host, _, err := net.SplitHostPort(o.registry)
+	if err != nil {
+		host = o.registry
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s", scheme, o.registry, o.repository, apiPath)
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// resolveOCI fetches the manifest of the reference, and returns its digest
+// and how to fetch its profiles: the first tar layer, as pushed by e.g.
+// `oras push <ref> profiles/`.
+func (r *RemoteProfiles) resolveOCI(ctx context.Context) (string, func(ctx context.Context, dir string) error, error) {
+	o, err := parseOCIRef(r.Ref)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := r.registryGet(ctx, o.url("manifests/"+o.reference),
+		"application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json")
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return "", nil, err
+	}
+	hash := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	if ociDigestPattern.MatchString(o.reference) && digest != o.reference {
+		return "", nil, fmt.Errorf("manifest digest %s does not match %s", digest, o.reference)
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", nil, fmt.Errorf("error parsing manifest: %w", err)
+	}
+	var layer *ociDescriptor
+	for i, l := range manifest.Layers {
+		if strings.HasSuffix(l.MediaType, ".tar") || strings.HasSuffix(l.MediaType, "tar+gzip") {
+			layer = &manifest.Layers[i]
+			break
+		}
+	}
+	if layer == nil {
+		return "", nil, fmt.Errorf("manifest %s has no tar layer", digest)
+	}
+	if !ociDigestPattern.MatchString(layer.Digest) {
+		return "", nil, fmt.Errorf("unsupported layer digest %q", layer.Digest)
+	}
+	if layer.Size > MaxProfileArchiveSize {
+		return "", nil, fmt.Errorf("layer %s is larger than %d bytes", layer.Digest, MaxProfileArchiveSize)
+	}
+
+	fetch := func(ctx context.Context, dir string) error {
+		resp, err := r.registryGet(ctx, o.url("blobs/"+layer.Digest), "")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, MaxProfileArchiveSize+1))
+		if err != nil {
+			return err
+		}
+		if hash := sha256.Sum256(data); "sha256:"+hex.EncodeToString(hash[:]) != layer.Digest {
+			return fmt.Errorf("layer does not match its digest %s", layer.Digest)
+		}
+		var archive io.Reader = bytes.NewReader(data)
+		if strings.HasSuffix(layer.MediaType, "gzip") {
+			if archive, err = gzip.NewReader(archive); err != nil {
+				return err
+			}
+		}
+		return extractTar(archive, dir)
+	}
+	return digest, fetch, nil
+}
+
+// registryGet gets a registry URL, answering a bearer token challenge with a
+// token for the repository, or a basic one with the credentials.
+func (r *RemoteProfiles) registryGet(ctx context.Context, u string, accept string) (*http.Response, error) {
+	get := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return r.Client.Do(req)
+	}
+
+	resp, err := get("")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		authorization, err := r.authorize(ctx, challenge)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = get(authorization); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+var challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize returns the Authorization header answering a registry's
+// WWW-Authenticate challenge.
+func (r *RemoteProfiles) authorize(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	user, password, _ := strings.Cut(r.Credentials, ":")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.Credentials == "" {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(user, password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported registry challenge %q", challenge)
+	}
+
+	values := map[string]string{}
+	for _, m := range challengeParamPattern.FindAllStringSubmatch(params, -1) {
+		values[m[1]] = m[2]
+	}
+	if values["realm"] == "" {
+		return "", fmt.Errorf("registry challenge %q has no realm", challenge)
+	}
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if values[key] != "" {
+			query.Set(key, values[key])
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, values["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if r.Credentials != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error getting registry token: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error parsing registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// extractTar extracts the directories and regular files of a tar archive
+// into dir. Other entries, e.g. links, are skipped.
+func extractTar(archive io.Reader, dir string) error {
+	tr := tar.NewReader(archive)
+	var total int64
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		if name == "" {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if total += header.Size; total > MaxProfileArchiveSize {
+				return fmt.Errorf("archive is larger than %d bytes", MaxProfileArchiveSize)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, io.LimitReader(tr, header.Size))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parseGitRef splits a git+<url>[#<branch, tag or commit>] reference. Both
+// are passed to git as arguments, so they must not look like options.
+func parseGitRef(ref string) (string, string, error) {
+	repo, rev, _ := strings.Cut(strings.TrimPrefix(ref, "git+"), "#")
+	if repo == "" || strings.HasPrefix(repo, "-") {
+		return "", "", fmt.Errorf("invalid profiles source %q: missing repository", ref)
+	}
+	if strings.HasPrefix(rev, "-") || strings.ContainsAny(rev, " \t\n") {
+		return "", "", fmt.Errorf("invalid profiles source %q: invalid branch, tag or commit %q", ref, rev)
+	}
+	return repo, rev, nil
+}
+
+// resolveGit resolves the branch or tag of the reference to a commit with
+// git ls-remote, and returns how to fetch the repository at that commit.
+// Commits are used as they are.
+func (r *RemoteProfiles) resolveGit(ctx context.Context) (string, func(ctx context.Context, dir string) error, error) {
+	repo, rev, err := parseGitRef(r.Ref)
+	if err != nil {
+		return "", nil, err
+	}
+	commit := rev
+	if !gitCommitPattern.MatchString(rev) {
+		pattern := rev
+		if pattern == "" {
+			pattern = "HEAD"
+		}
+		out, err := runGit(ctx, "", "ls-remote", repo, pattern)
+		if err != nil {
+			return "", nil, err
+		}
+		if commit = lsRemoteCommit(out, rev); commit == "" {
+			return "", nil, fmt.Errorf("no branch or tag %q in %s", rev, repo)
+		}
+	}
+
+	fetch := func(ctx context.Context, dir string) error {
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"fetch", "-q", "--depth", "1", repo, commit},
+			{"checkout", "-q", "FETCH_HEAD"},
+		} {
+			if _, err := runGit(ctx, dir, args...); err != nil {
+				return err
+			}
+		}
+		// Only the profiles are needed, and .git would be read as a profile
+		return os.RemoveAll(filepath.Join(dir, ".git"))
+	}
+	return commit, fetch, nil
+}
+
+// lsRemoteCommit picks the commit of a ref from git ls-remote output,
+// preferring the commit an annotated tag points to.
+func lsRemoteCommit(out string, rev string) string {
+	commits := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if commit, ref, ok := strings.Cut(strings.TrimSpace(line), "\t"); ok {
+			commits[ref] = commit
+		}
+	}
+	if rev == "" {
+		return commits["HEAD"]
+	}
+	for _, ref := range []string{"refs/tags/" + rev + "^{}", "refs/tags/" + rev, "refs/heads/" + rev, rev} {
+		if commit, ok := commits[ref]; ok {
+			return commit
+		}
+	}
+	return ""
+}
+
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
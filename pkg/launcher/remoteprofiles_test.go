@@ -0,0 +1,116 @@
+package launcher
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testRegistry serves a profiles archive as an OCI artifact, behind a bearer
+// token challenge.
+type testRegistry struct {
+	manifest []byte
+	blobs    map[string][]byte
+}
+
+func (reg *testRegistry) push(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+
+	hash := sha256.Sum256(buf.Bytes())
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	reg.blobs = map[string][]byte{digest: buf.Bytes()}
+	reg.manifest, _ = json.Marshal(ociManifest{Layers: []ociDescriptor{{
+		MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+		Digest:    digest,
+		Size:      int64(buf.Len()),
+	}}})
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="test",scope="repository:profiles:pull"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == "/v2/profiles/manifests/v1":
+		w.Write(reg.manifest)
+	case strings.HasPrefix(r.URL.Path, "/v2/profiles/blobs/"):
+		w.Write(reg.blobs[strings.TrimPrefix(r.URL.Path, "/v2/profiles/blobs/")])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRemoteProfilesOCI(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("TMPDIR", t.TempDir())
+	reg := &testRegistry{}
+	reg.push(t, map[string]string{"profiles/default/job.yaml": testJobTemplate})
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	remote, err := NewRemoteProfiles("oci://"+strings.TrimPrefix(server.URL, "http://")+"/profiles:v1", "profiles", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		if changed, err := remote.Refresh(ctx); err != nil || changed != want {
+			t.Fatalf("Refresh %d = %v, %v, want %v", i, changed, err, want)
+		}
+	}
+	profiles, err := LoadProfilesDir(remote.Dir())
+	if err != nil || profiles[DefaultProfileName] == nil {
+		t.Fatalf("LoadProfilesDir = %v, %v, want the default profile", profiles, err)
+	}
+	resolved := remote.Status().Resolved
+
+	reg.push(t, map[string]string{
+		"profiles/default/job.yaml":        testJobTemplate,
+		"profiles/default/../../../escape": "",
+	})
+	if changed, err := remote.Refresh(ctx); err != nil || !changed {
+		t.Fatalf("Refresh after push = %v, %v, want changed", changed, err)
+	}
+	if status := remote.Status(); status.Resolved == resolved || status.Pinned {
+		t.Errorf("status = %+v, want a new unpinned digest", status)
+	}
+}
+
+func TestRemoteProfilesGitRef(t *testing.T) {
+	for ref, valid := range map[string]bool{
+		"git+https://example.com/profiles.git":                          true,
+		"git+https://example.com/profiles.git#v1.2":                     true,
+		"git+--upload-pack=touch /tmp/pwned":                            false,
+		"git+https://example.com/profiles.git#--upload-pack=touch /tmp": false,
+		"git+https://example.com/profiles.git#-v":                       false,
+	} {
+		if _, err := NewRemoteProfiles(ref, "", ""); (err == nil) != valid {
+			t.Errorf("NewRemoteProfiles(%q) error = %v, want valid %v", ref, err, valid)
+		}
+	}
+}
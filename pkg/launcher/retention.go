@@ -0,0 +1,183 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Kinds of pre-launch hook resources that can be retained after their job
+// completed.
+const (
+	KindPersistentVolumeClaim = "PersistentVolumeClaim"
+	KindSecret                = "Secret"
+)
+
+// Reasons retained resources are reclaimed for.
+const (
+	ReclaimExpired  = "expired"
+	ReclaimConsumed = "consumed"
+)
+
+// RetentionPolicy maps kinds of pre-launch hook resources to how long they
+// are kept after their job completed, e.g. the PVC of a recording until
+// post-processing picked it up. Kinds without retention are deleted right
+// away. Retained resources are deleted early once a post-processing job
+// labels them with the ConsumedLabel.
+type RetentionPolicy map[string]string
+
+func ValidateRetention(policy RetentionPolicy) error {
+	for kind, value := range policy {
+		if kind != KindPersistentVolumeClaim && kind != KindSecret {
+			return fmt.Errorf("invalid retention kind %q, must be %s or %s", kind, KindPersistentVolumeClaim, KindSecret)
+		}
+		if d, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("invalid retention of %s: %w", kind, err)
+		} else if d < 0 {
+			return fmt.Errorf("retention of %s cannot be negative", kind)
+		}
+	}
+	return nil
+}
+
+// retention returns how long a hook resource is kept, 0 if it isn't.
+func (policy RetentionPolicy) retention(ref HookRef) time.Duration {
+	if schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).Group != "" {
+		return 0
+	}
+	d, _ := time.ParseDuration(policy[ref.Kind])
+	return d
+}
+
+// retainPatch marks a resource retained until the given time.
+func retainPatch(until time.Time) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels":      map[string]string{RetainedLabel: "true"},
+			"annotations": map[string]string{RetainUntilAnnotation: until.Format(time.RFC3339)},
+		},
+	})
+}
+
+// retainedUntil returns until when a retained resource is kept, resources
+// missing the annotation count as expired.
+func retainedUntil(obj metav1.Object) time.Time {
+	t, _ := time.Parse(time.RFC3339, obj.GetAnnotations()[RetainUntilAnnotation])
+	return t
+}
+
+// retainsResources tells whether any profile retains resources.
+func (s *LauncherService) retainsResources() bool {
+	for _, p := range s.allProfiles() {
+		if len(p.Settings.Retention) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// StartRetentionReaper deletes retained resources in every namespace once
+// they expire or are consumed. It checks every interval.
+func (s *LauncherService) StartRetentionReaper(ctx context.Context, namespaces []string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !s.retainsResources() {
+					continue
+				}
+				if err := s.reapRetained(ctx, namespaces); err != nil {
+					log.Printf("error reaping retained resources: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *LauncherService) reapRetained(ctx context.Context, namespaces []string) error {
+	opts := metav1.ListOptions{LabelSelector: ManagedLabelSelector() + "," + RetainedLabel}
+	now := time.Now()
+	counts := map[string]int{KindPersistentVolumeClaim: 0, KindSecret: 0}
+	var retainedBytes int64
+
+	var errs []error
+	for _, ns := range namespaces {
+		var retained []metav1.Object
+		if pvcs, err := s.Clientset.CoreV1().PersistentVolumeClaims(ns).List(ctx, opts); err != nil {
+			errs = append(errs, fmt.Errorf("error listing %s: %w", KindPersistentVolumeClaim, err))
+		} else {
+			retained = append(retained, objects(pvcs.Items)...)
+		}
+		if secrets, err := s.Clientset.CoreV1().Secrets(ns).List(ctx, opts); err != nil {
+			errs = append(errs, fmt.Errorf("error listing %s: %w", KindSecret, err))
+		} else {
+			retained = append(retained, objects(secrets.Items)...)
+		}
+
+		for _, obj := range retained {
+			kind := KindSecret
+			var bytes int64
+			if pvc, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+				kind = KindPersistentVolumeClaim
+				bytes = claimedBytes(pvc)
+			}
+
+			reason := ""
+			if _, ok := obj.GetLabels()[ConsumedLabel]; ok {
+				reason = ReclaimConsumed
+			} else if now.After(retainedUntil(obj)) {
+				reason = ReclaimExpired
+			}
+			if reason == "" {
+				counts[kind]++
+				retainedBytes += bytes
+				continue
+			}
+
+			var err error
+			if kind == KindPersistentVolumeClaim {
+				err = s.Clientset.CoreV1().PersistentVolumeClaims(ns).Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+			} else {
+				err = s.Clientset.CoreV1().Secrets(ns).Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+			}
+			if apierrors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				errs = append(errs, fmt.Errorf("error reclaiming %s %s: %w", kind, obj.GetName(), err))
+			} else {
+				log.Printf("reclaimed %s %s %s", reason, kind, obj.GetName())
+				reclaimedResources.WithLabelValues(kind, reason).Inc()
+				reclaimedStorage.Add(float64(bytes))
+			}
+			s.recordCleanup(objectCleanupAction(obj, ns, CleanupDelete, kind), err)
+		}
+	}
+
+	for kind, count := range counts {
+		retainedResources.WithLabelValues(kind).Set(float64(count))
+	}
+	retainedStorage.Set(float64(retainedBytes))
+	return errors.Join(errs...)
+}
+
+// claimedBytes returns the storage a PVC was given, or asked for while it is
+// still pending.
+func claimedBytes(pvc *corev1.PersistentVolumeClaim) int64 {
+	if q, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		return q.Value()
+	}
+	q := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	return q.Value()
+}
@@ -0,0 +1,78 @@
+package launcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReapRetained(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	meta := func(name string, until time.Time, consumed bool) metav1.ObjectMeta {
+		labels := map[string]string{RetainedLabel: "true"}
+		for k, v := range DefaultLabels {
+			labels[k] = v
+		}
+		if consumed {
+			labels[ConsumedLabel] = "true"
+		}
+		return metav1.ObjectMeta{
+			Name:        name,
+			Labels:      labels,
+			Annotations: map[string]string{RetainUntilAnnotation: until.Format(time.RFC3339)},
+		}
+	}
+	claim := func(name string, until time.Time, consumed bool) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: meta(name, until, consumed),
+			Spec: corev1.PersistentVolumeClaimSpec{
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				},
+			},
+		}
+	}
+	future, past := time.Now().Add(time.Hour), time.Now().Add(-time.Hour)
+	pvcs := s.Clientset.CoreV1().PersistentVolumeClaims("test")
+	for _, pvc := range []*corev1.PersistentVolumeClaim{
+		claim("retained", future, false),
+		claim("expired", past, false),
+		claim("consumed", future, true),
+	} {
+		if _, err := pvcs.Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret := &corev1.Secret{ObjectMeta: meta("expired", past, false)}
+	if _, err := s.Clientset.CoreV1().Secrets("test").Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.reapRetained(ctx, []string{"test"}); err != nil {
+		t.Fatalf("reapRetained: %v", err)
+	}
+	list, err := pvcs.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "retained" {
+		t.Errorf("remaining PVCs = %v, want only retained", list.Items)
+	}
+	secrets, err := s.Clientset.CoreV1().Secrets("test").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 0 {
+		t.Errorf("remaining Secrets = %v, want none", secrets.Items)
+	}
+
+	if err := ValidateRetention(RetentionPolicy{"ConfigMap": "1h"}); err == nil {
+		t.Errorf("retention of ConfigMaps is valid")
+	}
+}
@@ -0,0 +1,459 @@
+package launcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MaxProfileVersions bounds the versions kept of each profile, the oldest
+// inactive ones are dropped beyond it. Jobs of a dropped version can no
+// longer start their post-launch hook or register their artifacts.
+var MaxProfileVersions = 20
+
+// Keys of the profiles collection of a Storage
+const (
+	profileVersionKeyPrefix = "version/"
+	activeProfileKeyPrefix  = "active/"
+)
+
+// ProfileVersion returns a version identifying the templates and settings of
+// a profile, so launches can be traced back to the templates they came from.
+func ProfileVersion(p *Profile) string {
+	h := sha256.New()
+	names := []string{}
+	templates := p.templates()
+	for name, tmpl := range templates {
+		if *tmpl != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\n%s\n", name, (*templates[name]).Root.String())
+	}
+	fmt.Fprintf(h, "%+v\n", p.Settings)
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// RolloutRecord is a switch of the active version of a profile.
+type RolloutRecord struct {
+	Time    time.Time `json:"time"`
+	Profile string    `json:"profile"`
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to"`
+	// Tenant that requested the rollout
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// ProfileVersions lists the loaded versions of a profile.
+type ProfileVersions struct {
+	Name     string   `json:"name"`
+	Active   string   `json:"active"`
+	Versions []string `json:"versions"`
+}
+
+// storedProfile is a version of a profile as kept in a Storage, with the
+// template sources to parse it again.
+type storedProfile struct {
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	Templates map[string]string `json:"templates"`
+	Hashes    map[string]string `json:"hashes,omitempty"`
+	Settings  ProfileSettings   `json:"settings"`
+	StagedAt  time.Time         `json:"stagedAt"`
+}
+
+// profile parses the stored templates again.
+func (sp *storedProfile) profile() (*Profile, error) {
+	p := &Profile{
+		Name:     sp.Name,
+		Settings: sp.Settings,
+		Hashes:   sp.Hashes,
+		Version:  sp.Version,
+		sources:  sp.Templates,
+	}
+	p.Settings.Params = normalizeParams(p.Settings.Params)
+	fields := p.templates()
+	for name, source := range sp.Templates {
+		field, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("version %s of profile %s has unknown template %s", sp.Version, sp.Name, name)
+		}
+		tmpl, err := template.New(name).Funcs(TemplateFuncs).Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s template of version %s of profile %s: %w", name, sp.Version, sp.Name, err)
+		}
+		*field = tmpl
+	}
+	return p, nil
+}
+
+// templateSources returns the template sources of a profile. Templates that
+// weren't read from files are printed from their parse trees.
+func (p *Profile) templateSources() map[string]string {
+	sources := map[string]string{}
+	for name, tmpl := range p.templates() {
+		if *tmpl == nil {
+			continue
+		}
+		if source, ok := p.sources[name]; ok {
+			sources[name] = source
+		} else {
+			sources[name] = (*tmpl).Root.String()
+		}
+	}
+	return sources
+}
+
+// stageProfile keeps a version of a profile, so it can be rolled out and so
+// jobs launched from it still find it. It becomes active if the profile has
+// no active version yet.
+func (s *LauncherService) stageProfile(p *Profile) bool {
+	if p.Version == "" {
+		p.Version = ProfileVersion(p)
+	}
+	staged := s.addVersion(p)
+	if staged {
+		s.storeProfile(p)
+	}
+	if _, ok := s.Profiles[p.Name]; !ok {
+		s.setActiveProfile(p)
+	}
+	s.pruneVersions(p.Name)
+	return staged
+}
+
+// addVersion keeps a version of a profile as its newest, unless it is kept
+// already.
+func (s *LauncherService) addVersion(p *Profile) bool {
+	if s.versions == nil {
+		s.versions = map[string]map[string]*Profile{}
+		s.versionOrder = map[string][]string{}
+	}
+	if s.versions[p.Name] == nil {
+		s.versions[p.Name] = map[string]*Profile{}
+	}
+	if _, ok := s.versions[p.Name][p.Version]; ok {
+		return false
+	}
+	s.versions[p.Name][p.Version] = p
+	s.versionOrder[p.Name] = append(s.versionOrder[p.Name], p.Version)
+	return true
+}
+
+// storeProfile keeps a version of a profile in the Storage, for jobs of it to
+// find it after a restart or on another replica.
+func (s *LauncherService) storeProfile(p *Profile) {
+	if s.Storage == nil {
+		return
+	}
+	stored := &storedProfile{
+		Name:      p.Name,
+		Version:   p.Version,
+		Templates: p.templateSources(),
+		Hashes:    p.Hashes,
+		Settings:  p.Settings,
+		StagedAt:  time.Now().UTC(),
+	}
+	if err := s.Storage.Put(context.Background(), CollectionProfiles, profileVersionKeyPrefix+p.Name+"/"+p.Version, stored); err != nil {
+		log.Printf("error storing version %s of profile %s: %v", p.Version, p.Name, err)
+	}
+}
+
+// pruneVersions drops the oldest versions of a profile beyond
+// MaxProfileVersions, except for the active one.
+func (s *LauncherService) pruneVersions(name string) {
+	order := s.versionOrder[name]
+	for MaxProfileVersions > 0 && len(order) > MaxProfileVersions {
+		i := 0
+		if active, ok := s.Profiles[name]; ok && active.Version == order[0] {
+			i = 1
+		}
+		version := order[i]
+		order = append(order[:i:i], order[i+1:]...)
+		delete(s.versions[name], version)
+		if s.Storage != nil {
+			if err := s.Storage.Delete(context.Background(), CollectionProfiles, profileVersionKeyPrefix+name+"/"+version); err != nil {
+				log.Printf("error deleting version %s of profile %s: %v", version, name, err)
+			}
+		}
+		log.Printf("Dropped version %s of profile %s", version, name)
+	}
+	s.versionOrder[name] = order
+}
+
+// RestoreProfiles reads back the profile versions and rollouts kept in
+// Storage, and stores the loaded versions that aren't kept yet. Rolled out
+// versions stay active across restarts, versions loaded since are staged.
+func (s *LauncherService) RestoreProfiles(ctx context.Context) error {
+	if s.Storage == nil {
+		return nil
+	}
+	entries, err := s.Storage.List(ctx, CollectionProfiles)
+	if err != nil {
+		return fmt.Errorf("error reading profile versions: %w", err)
+	}
+
+	var restored []*storedProfile
+	rolledOut := map[string]string{}
+	for _, entry := range entries {
+		switch {
+		case strings.HasPrefix(entry.Key, profileVersionKeyPrefix):
+			var sp storedProfile
+			if err := json.Unmarshal(entry.Value, &sp); err != nil {
+				log.Printf("skipping invalid profile version %s: %v", entry.Key, err)
+				continue
+			}
+			restored = append(restored, &sp)
+		case strings.HasPrefix(entry.Key, activeProfileKeyPrefix):
+			var record RolloutRecord
+			if err := json.Unmarshal(entry.Value, &record); err != nil {
+				log.Printf("skipping invalid active profile %s: %v", entry.Key, err)
+				continue
+			}
+			rolledOut[record.Profile] = record.To
+		}
+	}
+	sort.SliceStable(restored, func(i, j int) bool {
+		return restored[i].StagedAt.Before(restored[j].StagedAt)
+	})
+
+	s.profilesMu.Lock()
+	defer s.profilesMu.Unlock()
+
+	// The stored versions are older than those loaded on startup
+	loaded := s.versionOrder
+	stored := map[string]bool{}
+	s.versionOrder = map[string][]string{}
+	for _, sp := range restored {
+		stored[sp.Name+"/"+sp.Version] = true
+		if p, ok := s.versions[sp.Name][sp.Version]; ok {
+			s.versionOrder[p.Name] = append(s.versionOrder[p.Name], p.Version)
+			continue
+		}
+		p, err := sp.profile()
+		if err != nil {
+			log.Printf("skipping stored profile version: %v", err)
+			continue
+		}
+		s.addVersion(p)
+	}
+	for name, versions := range loaded {
+		for _, version := range versions {
+			if !stored[name+"/"+version] {
+				s.versionOrder[name] = append(s.versionOrder[name], version)
+				s.storeProfile(s.versions[name][version])
+			}
+		}
+	}
+
+	for name, version := range rolledOut {
+		if _, ok := s.Profiles[name]; !ok {
+			continue
+		}
+		if p, ok := s.versions[name][version]; ok {
+			s.setActiveProfile(p)
+		}
+	}
+	for name := range s.versionOrder {
+		s.pruneVersions(name)
+	}
+	return nil
+}
+
+// setActiveProfile replaces the profiles map instead of modifying it, so
+// snapshots taken by readers stay valid.
+func (s *LauncherService) setActiveProfile(p *Profile) {
+	profiles := make(map[string]*Profile, len(s.Profiles)+1)
+	for name, active := range s.Profiles {
+		profiles[name] = active
+	}
+	profiles[p.Name] = p
+	s.Profiles = profiles
+}
+
+func (s *LauncherService) activeProfiles() map[string]*Profile {
+	s.profilesMu.RLock()
+	defer s.profilesMu.RUnlock()
+	return s.Profiles
+}
+
+// allProfiles returns every loaded version of every profile, as resources of
+// older versions may still exist.
+func (s *LauncherService) allProfiles() []*Profile {
+	s.profilesMu.RLock()
+	defer s.profilesMu.RUnlock()
+	var profiles []*Profile
+	for _, p := range s.Profiles {
+		profiles = append(profiles, p)
+	}
+	for _, versions := range s.versions {
+		for _, p := range versions {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles
+}
+
+// profileVersion returns a version of a profile, reading back versions staged
+// by other replicas from the Storage. Jobs from before profiles had versions
+// get the active version.
+func (s *LauncherService) profileVersion(name string, version string) (*Profile, error) {
+	if name == "" {
+		name = DefaultProfileName
+	}
+	if version == "" {
+		return s.Profile(name)
+	}
+	s.profilesMu.RLock()
+	p, ok := s.versions[name][version]
+	s.profilesMu.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	if s.Storage != nil {
+		var sp storedProfile
+		found, err := s.Storage.Get(context.Background(), CollectionProfiles, profileVersionKeyPrefix+name+"/"+version, &sp)
+		if err != nil {
+			return nil, fmt.Errorf("error reading version %s of profile %s: %w", version, name, err)
+		}
+		if found {
+			p, err := sp.profile()
+			if err != nil {
+				return nil, err
+			}
+			s.profilesMu.Lock()
+			defer s.profilesMu.Unlock()
+			if !s.addVersion(p) {
+				return s.versions[name][version], nil
+			}
+			s.pruneVersions(name)
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: profile %s has no version %q", ErrNotFound, name, version)
+}
+
+// ReloadProfiles loads the profiles of ProfileSource as new versions without
+// activating them, except for profiles that didn't exist before. It returns
+// the versions of every profile.
+func (s *LauncherService) ReloadProfiles() ([]ProfileVersions, error) {
+	if s.ProfileSource == nil {
+		return nil, fmt.Errorf("profiles cannot be reloaded")
+	}
+	profiles, err := s.ProfileSource()
+	if err != nil {
+		return nil, err
+	}
+
+	s.profilesMu.Lock()
+	for _, p := range profiles {
+		if s.stageProfile(p) {
+			log.Printf("Loaded version %s of profile %s", p.Version, p.Name)
+		}
+	}
+	s.profilesMu.Unlock()
+
+	return s.ProfileVersions(), nil
+}
+
+// ProfileVersions returns the loaded versions of every profile.
+func (s *LauncherService) ProfileVersions() []ProfileVersions {
+	s.profilesMu.RLock()
+	defer s.profilesMu.RUnlock()
+
+	list := []ProfileVersions{}
+	for name, versions := range s.versions {
+		info := ProfileVersions{Name: name, Versions: []string{}}
+		if active, ok := s.Profiles[name]; ok {
+			info.Active = active.Version
+		}
+		for version := range versions {
+			info.Versions = append(info.Versions, version)
+		}
+		sort.Strings(info.Versions)
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// RolloutProfile makes a loaded version of a profile the active one. New
+// launches use it right away, existing jobs keep their version.
+func (s *LauncherService) RolloutProfile(name string, version string, tenant string) (*RolloutRecord, error) {
+	s.profilesMu.Lock()
+	defer s.profilesMu.Unlock()
+
+	p, ok := s.versions[name][version]
+	if !ok {
+		return nil, fmt.Errorf("%w: profile %s has no version %q", ErrNotFound, name, version)
+	}
+
+	record := RolloutRecord{
+		Time:    time.Now().UTC(),
+		Profile: name,
+		To:      version,
+		Tenant:  tenant,
+	}
+	if active, ok := s.Profiles[name]; ok {
+		record.From = active.Version
+	}
+	s.setActiveProfile(p)
+	s.rollouts = append(s.rollouts, record)
+	if s.Storage != nil {
+		if err := s.Storage.Put(context.Background(), CollectionAudit, "rollout/"+orderedKey(record.Time), record); err != nil {
+			log.Printf("error storing rollout of profile %s: %v", name, err)
+		}
+		if err := s.Storage.Put(context.Background(), CollectionProfiles, activeProfileKeyPrefix+name, record); err != nil {
+			log.Printf("error storing active version of profile %s: %v", name, err)
+		}
+	}
+	log.Printf("Rolled out version %s of profile %s, replacing %s", version, name, record.From)
+
+	return &record, nil
+}
+
+// Rollouts returns the rollouts since the launcher started, or all stored
+// ones with a Storage, oldest first.
+func (s *LauncherService) Rollouts() []RolloutRecord {
+	if s.Storage != nil {
+		if rollouts, err := s.storedRollouts(); err != nil {
+			log.Printf("error reading stored rollouts: %v", err)
+		} else {
+			return rollouts
+		}
+	}
+	s.profilesMu.RLock()
+	defer s.profilesMu.RUnlock()
+	return append([]RolloutRecord{}, s.rollouts...)
+}
+
+func (s *LauncherService) storedRollouts() ([]RolloutRecord, error) {
+	entries, err := s.Storage.List(context.Background(), CollectionAudit)
+	if err != nil {
+		return nil, err
+	}
+	rollouts := []RolloutRecord{}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Key, "rollout/") {
+			continue
+		}
+		var record RolloutRecord
+		if err := json.Unmarshal(entry.Value, &record); err != nil {
+			return nil, fmt.Errorf("invalid rollout %s: %w", entry.Key, err)
+		}
+		rollouts = append(rollouts, record)
+	}
+	return rollouts, nil
+}
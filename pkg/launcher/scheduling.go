@@ -0,0 +1,102 @@
+package launcher
+
+import (
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// SchedulingConfig holds scheduling hints injected into jobs whose templates
+// don't specify their own, e.g. to spread recordings across nodes or zones.
+// Terms and constraints without a label selector select the launcher's pods.
+type SchedulingConfig struct {
+	Affinity                  *corev1.Affinity                  `json:"affinity,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+}
+
+func LoadSchedulingConfig(path string) (*SchedulingConfig, error) {
+	data, err := ReadToString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &SchedulingConfig{}
+	if err := yaml.UnmarshalStrict([]byte(data), config); err != nil {
+		return nil, fmt.Errorf("error parsing scheduling config %v: %w", path, err)
+	}
+	return config, nil
+}
+
+// InjectScheduling adds the configured affinities and spread constraints to
+// the job's pods, leaving whatever the template set alone.
+func InjectScheduling(job *batchv1.Job, config *SchedulingConfig) {
+	if config == nil {
+		return
+	}
+	pod := &job.Spec.Template
+	injected := false
+
+	if a := config.Affinity; a != nil {
+		if pod.Spec.Affinity == nil {
+			pod.Spec.Affinity = &corev1.Affinity{}
+		}
+		if pod.Spec.Affinity.NodeAffinity == nil && a.NodeAffinity != nil {
+			pod.Spec.Affinity.NodeAffinity = a.NodeAffinity.DeepCopy()
+		}
+		if pod.Spec.Affinity.PodAffinity == nil && a.PodAffinity != nil {
+			pod.Spec.Affinity.PodAffinity = a.PodAffinity.DeepCopy()
+			selectLauncherPods(pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution, pod.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution)
+			injected = true
+		}
+		if pod.Spec.Affinity.PodAntiAffinity == nil && a.PodAntiAffinity != nil {
+			pod.Spec.Affinity.PodAntiAffinity = a.PodAntiAffinity.DeepCopy()
+			selectLauncherPods(pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, pod.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution)
+			injected = true
+		}
+	}
+
+	if len(pod.Spec.TopologySpreadConstraints) == 0 && len(config.TopologySpreadConstraints) > 0 {
+		for _, c := range config.TopologySpreadConstraints {
+			c = *c.DeepCopy()
+			if c.LabelSelector == nil {
+				c.LabelSelector = launcherPodSelector()
+			}
+			pod.Spec.TopologySpreadConstraints = append(pod.Spec.TopologySpreadConstraints, c)
+		}
+		injected = true
+	}
+
+	// Make sure the pods match the default selectors
+	if injected {
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		for k, v := range DefaultLabels {
+			pod.Labels[k] = v
+		}
+	}
+}
+
+func selectLauncherPods(required []corev1.PodAffinityTerm, preferred []corev1.WeightedPodAffinityTerm) {
+	for i := range required {
+		if required[i].LabelSelector == nil {
+			required[i].LabelSelector = launcherPodSelector()
+		}
+	}
+	for i := range preferred {
+		if preferred[i].PodAffinityTerm.LabelSelector == nil {
+			preferred[i].PodAffinityTerm.LabelSelector = launcherPodSelector()
+		}
+	}
+}
+
+func launcherPodSelector() *metav1.LabelSelector {
+	labels := map[string]string{}
+	for k, v := range DefaultLabels {
+		labels[k] = v
+	}
+	return &metav1.LabelSelector{MatchLabels: labels}
+}
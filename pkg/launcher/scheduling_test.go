@@ -0,0 +1,49 @@
+package launcher
+
+import (
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestInjectScheduling(t *testing.T) {
+	config := &SchedulingConfig{
+		Affinity: &corev1.Affinity{
+			PodAntiAffinity: &corev1.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+					Weight:          100,
+					PodAffinityTerm: corev1.PodAffinityTerm{TopologyKey: "kubernetes.io/hostname"},
+				}},
+			},
+		},
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{{
+			MaxSkew:     1,
+			TopologyKey: "topology.kubernetes.io/zone",
+		}},
+	}
+
+	job := &batchv1.Job{}
+	InjectScheduling(job, config)
+	pod := job.Spec.Template
+	term := pod.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm
+	if term.LabelSelector == nil || len(pod.Spec.TopologySpreadConstraints) != 1 {
+		t.Fatalf("scheduling hints were not injected: %+v", pod.Spec)
+	}
+	for k, v := range term.LabelSelector.MatchLabels {
+		if pod.Labels[k] != v {
+			t.Errorf("pod label %s = %q, want %q", k, pod.Labels[k], v)
+		}
+	}
+	if config.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm.LabelSelector != nil {
+		t.Errorf("config was modified")
+	}
+
+	// Templates with their own constraints keep them
+	job = &batchv1.Job{}
+	job.Spec.Template.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{TopologyKey: "custom"}}
+	InjectScheduling(job, config)
+	if c := job.Spec.Template.Spec.TopologySpreadConstraints; len(c) != 1 || c[0].TopologyKey != "custom" {
+		t.Errorf("template constraints were replaced: %+v", c)
+	}
+}
@@ -0,0 +1,108 @@
+package launcher
+
+import (
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Security defaults, named as the securityContext fields they set.
+const (
+	SecurityRunAsNonRoot             = "runAsNonRoot"
+	SecuritySeccompProfile           = "seccompProfile"
+	SecurityReadOnlyRootFilesystem   = "readOnlyRootFilesystem"
+	SecurityAllowPrivilegeEscalation = "allowPrivilegeEscalation"
+	SecurityCapabilities             = "capabilities"
+)
+
+var SecurityDefaults = []string{
+	SecurityRunAsNonRoot,
+	SecuritySeccompProfile,
+	SecurityReadOnlyRootFilesystem,
+	SecurityAllowPrivilegeEscalation,
+	SecurityCapabilities,
+}
+
+func ValidateSecurityExceptions(exceptions []string) error {
+	for _, exception := range exceptions {
+		valid := false
+		for _, name := range SecurityDefaults {
+			valid = valid || exception == name
+		}
+		if !valid {
+			return fmt.Errorf("invalid security exception %q, must be one of %s", exception, strings.Join(SecurityDefaults, ", "))
+		}
+	}
+	return nil
+}
+
+// InjectSecurityDefaults sets the securityContext fields the restricted Pod
+// Security Standard asks for, plus a read-only root filesystem, wherever the
+// job's template leaves them unset. Exceptions name the defaults to skip.
+func InjectSecurityDefaults(job *batchv1.Job, exceptions []string) {
+	skip := map[string]bool{}
+	for _, exception := range exceptions {
+		skip[exception] = true
+	}
+	pod := &job.Spec.Template.Spec
+
+	if pod.SecurityContext == nil {
+		pod.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	if !skip[SecurityRunAsNonRoot] && pod.SecurityContext.RunAsNonRoot == nil {
+		pod.SecurityContext.RunAsNonRoot = boolPtr(true)
+	}
+	if !skip[SecuritySeccompProfile] && pod.SecurityContext.SeccompProfile == nil {
+		pod.SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	}
+
+	for _, containers := range [][]corev1.Container{pod.InitContainers, pod.Containers} {
+		for i := range containers {
+			injectContainerSecurityDefaults(&containers[i], skip)
+		}
+	}
+}
+
+func injectContainerSecurityDefaults(c *corev1.Container, skip map[string]bool) {
+	if c.SecurityContext == nil {
+		c.SecurityContext = &corev1.SecurityContext{}
+	}
+	sc := c.SecurityContext
+
+	if !skip[SecurityReadOnlyRootFilesystem] && sc.ReadOnlyRootFilesystem == nil {
+		sc.ReadOnlyRootFilesystem = boolPtr(true)
+	}
+	if !skip[SecurityCapabilities] {
+		if sc.Capabilities == nil {
+			sc.Capabilities = &corev1.Capabilities{}
+		}
+		if len(sc.Capabilities.Drop) == 0 {
+			sc.Capabilities.Drop = []corev1.Capability{"ALL"}
+		}
+	}
+	// The API server rejects privileged containers and containers adding
+	// CAP_SYS_ADMIN that disallow privilege escalation
+	if !skip[SecurityAllowPrivilegeEscalation] && sc.AllowPrivilegeEscalation == nil && !escalates(sc) {
+		sc.AllowPrivilegeEscalation = boolPtr(false)
+	}
+}
+
+func escalates(sc *corev1.SecurityContext) bool {
+	if sc.Privileged != nil && *sc.Privileged {
+		return true
+	}
+	if sc.Capabilities != nil {
+		for _, capability := range sc.Capabilities.Add {
+			if capability == "SYS_ADMIN" || capability == "CAP_SYS_ADMIN" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
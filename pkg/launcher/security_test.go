@@ -0,0 +1,56 @@
+package launcher
+
+import (
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestInjectSecurityDefaults(t *testing.T) {
+	job := &batchv1.Job{}
+	job.Spec.Template.Spec.Containers = []corev1.Container{
+		{Name: "recorder"},
+		{Name: "privileged", SecurityContext: &corev1.SecurityContext{
+			Privileged:             boolPtr(true),
+			ReadOnlyRootFilesystem: boolPtr(false),
+		}},
+	}
+	InjectSecurityDefaults(job, []string{SecuritySeccompProfile})
+	pod := job.Spec.Template.Spec
+
+	if pod.SecurityContext.RunAsNonRoot == nil || !*pod.SecurityContext.RunAsNonRoot {
+		t.Errorf("runAsNonRoot was not injected")
+	}
+	if pod.SecurityContext.SeccompProfile != nil {
+		t.Errorf("seccompProfile was injected despite the exception")
+	}
+
+	recorder := pod.Containers[0].SecurityContext
+	if recorder.ReadOnlyRootFilesystem == nil || !*recorder.ReadOnlyRootFilesystem {
+		t.Errorf("readOnlyRootFilesystem was not injected")
+	}
+	if recorder.AllowPrivilegeEscalation == nil || *recorder.AllowPrivilegeEscalation {
+		t.Errorf("allowPrivilegeEscalation was not injected")
+	}
+	if len(recorder.Capabilities.Drop) != 1 || recorder.Capabilities.Drop[0] != "ALL" {
+		t.Errorf("capabilities.drop = %v, want [ALL]", recorder.Capabilities.Drop)
+	}
+
+	privileged := pod.Containers[1].SecurityContext
+	if *privileged.ReadOnlyRootFilesystem {
+		t.Errorf("readOnlyRootFilesystem set by the template was overridden")
+	}
+	if privileged.AllowPrivilegeEscalation != nil {
+		t.Errorf("allowPrivilegeEscalation was injected into a privileged container")
+	}
+}
+
+func TestValidateSecurityExceptions(t *testing.T) {
+	if err := ValidateSecurityExceptions([]string{SecurityCapabilities}); err != nil {
+		t.Errorf("ValidateSecurityExceptions() = %v, want nil", err)
+	}
+	if err := ValidateSecurityExceptions([]string{"privileged"}); err == nil {
+		t.Errorf("ValidateSecurityExceptions() = nil, want an error")
+	}
+}
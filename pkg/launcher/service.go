@@ -0,0 +1,1294 @@
+// Package launcher renders, launches and cleans up the Kubernetes resources of
+// per-video jobs. The launcher command serves it over HTTP.
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	typednetworkingv1 "k8s.io/client-go/kubernetes/typed/networking/v1"
+	typedpolicyv1 "k8s.io/client-go/kubernetes/typed/policy/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Tuning controls how hard the launcher hits the API server.
+type Tuning struct {
+	// WatchTimeout bounds each watch call, 0 leaves it to the API server
+	WatchTimeout time.Duration
+	// RelistInterval forces a full relist of jobs, 0 disables it
+	RelistInterval time.Duration
+	// ResyncPeriod of the job cache, 0 disables resyncs
+	ResyncPeriod time.Duration
+	// CleanupWorkers clean up completed jobs in parallel, 0 cleans up in the
+	// watcher, one job at a time
+	CleanupWorkers int
+	// CleanupRetries of a job whose cleanup failed, with exponential backoff
+	CleanupRetries int
+}
+
+type LauncherService struct {
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	Mapper    meta.RESTMapper
+	Namespace string
+	Tenants   *TenantRegistry
+
+	// DryRunValidate validates every launch with a server-side dry run first
+	DryRunValidate bool
+
+	// Params are the global default template parameters
+	Params map[string]any
+
+	// Overlays patch rendered manifests by kind for the current environment
+	Overlays    map[string]*Overlay
+	Environment string
+
+	Tuning Tuning
+
+	// CleanupHistory records deletions done on job completion
+	CleanupHistory *CleanupHistory
+	// LaunchHistory records launches and their outcomes
+	LaunchHistory *LaunchHistory
+	// Storage keeps which jobs were cleaned up across restarts, and the
+	// rollout audit log, when set
+	Storage Storage
+
+	jobListers map[string]batchlisters.JobLister
+	cleanedUp  sync.Map
+
+	// maintenance is set while launching is paused
+	maintenance atomic.Pointer[MaintenanceState]
+
+	cleanupQueue workqueue.RateLimitingInterface
+
+	// Fake is set for in-memory clusters, which cannot dry run requests
+	Fake bool
+
+	// FieldValidation of created objects, one of the FieldValidation levels.
+	// Empty leaves it to the API server.
+	FieldValidation string
+	// ReturnWarnings includes API server warnings in launch results
+	ReturnWarnings bool
+
+	// Credentials issues a secret per launch when set
+	Credentials CredentialsProvider
+
+	// Artifacts registers what succeeded jobs produced when set
+	Artifacts           ArtifactRegistrar
+	registeredArtifacts sync.Map
+
+	// Delivery sends the outbound calls of the integrations above
+	Delivery *Delivery
+
+	// IngressReadiness checks that created ingresses are reachable when set
+	IngressReadiness *IngressReadiness
+
+	// Heartbeats marks launches stale that stopped sending heartbeats when set
+	Heartbeats *HeartbeatMonitor
+
+	// Chaos allows admins to simulate the completion of launches, for staging
+	Chaos bool
+
+	// RestoreWindow retires the resources of completed jobs instead of
+	// deleting them, and deletes them once they were retired this long. 0
+	// deletes them right away.
+	RestoreWindow time.Duration
+
+	// Scheduling hints injected into jobs that lack them
+	Scheduling *SchedulingConfig
+
+	// SecurityDefaults injects restricted securityContext defaults into jobs
+	SecurityDefaults bool
+
+	// Capacity rejects launches while the cluster is full, when set
+	Capacity *CapacityMonitor
+	// Queue holds scheduled launches and those waiting for limits
+	Queue LaunchQueue
+
+	// CheckResourceQuotas rejects jobs that don't fit into the namespace's
+	// ResourceQuotas instead of leaving them unschedulable
+	CheckResourceQuotas bool
+
+	// Profiles by name, launches use DefaultProfileName unless requested.
+	// These are the active versions, replace them with RolloutProfile.
+	Profiles map[string]*Profile
+	// ProfileSource loads new profile versions for ReloadProfiles
+	ProfileSource func() (map[string]*Profile, error)
+	// RemoteProfiles is where the profiles are fetched from, if not from
+	// local files
+	RemoteProfiles *RemoteProfiles
+
+	profilesMu sync.RWMutex
+	versions   map[string]map[string]*Profile
+	// versionOrder lists the versions of each profile, oldest first
+	versionOrder map[string][]string
+	rollouts     []RolloutRecord
+
+	// Set with options, defaulting to the clientset and Prometheus
+	jobs          JobCreator
+	services      ServiceCreator
+	ingresses     IngressCreator
+	watcher       Watcher
+	cleanupPolicy CleanupPolicy
+	naming        NamingStrategy
+	metrics       Metrics
+}
+
+func NewLauncherService(
+	clientset kubernetes.Interface,
+	namespace string,
+	tenants *TenantRegistry,
+	profiles map[string]*Profile,
+	opts ...Option,
+) *LauncherService {
+	backend := clientsetBackend{clientset}
+	s := &LauncherService{
+		Clientset: clientset,
+		Namespace: namespace,
+		Tenants:   tenants,
+
+		Profiles: profiles,
+
+		jobs:          backend,
+		services:      backend,
+		ingresses:     backend,
+		watcher:       backend,
+		cleanupPolicy: CleanupOnSuccess,
+		naming:        HashNaming,
+		metrics:       prometheusMetrics{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for _, p := range profiles {
+		s.stageProfile(p)
+	}
+	return s
+}
+
+// Profile returns the named profile, or the default profile for an empty
+// name.
+func (s *LauncherService) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = DefaultProfileName
+	}
+	s.profilesMu.RLock()
+	defer s.profilesMu.RUnlock()
+	p, ok := s.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown profile %q", ErrInvalidRequest, name)
+	}
+	return p, nil
+}
+
+// NamespaceFor returns the namespace that holds a tenant's resources.
+func (s *LauncherService) NamespaceFor(tenant *Tenant) string {
+	if tenant != nil && tenant.Namespace != "" {
+		return tenant.Namespace
+	}
+	return s.Namespace
+}
+
+func (s *LauncherService) jobClient(namespace string) typedbatchv1.JobInterface {
+	return s.Clientset.BatchV1().Jobs(namespace)
+}
+
+func (s *LauncherService) serviceClient(namespace string) typedcorev1.ServiceInterface {
+	return s.Clientset.CoreV1().Services(namespace)
+}
+
+func (s *LauncherService) ingressClient(namespace string) typednetworkingv1.IngressInterface {
+	return s.Clientset.NetworkingV1().Ingresses(namespace)
+}
+
+func (s *LauncherService) networkPolicyClient(namespace string) typednetworkingv1.NetworkPolicyInterface {
+	return s.Clientset.NetworkingV1().NetworkPolicies(namespace)
+}
+
+func (s *LauncherService) pdbClient(namespace string) typedpolicyv1.PodDisruptionBudgetInterface {
+	return s.Clientset.PolicyV1().PodDisruptionBudgets(namespace)
+}
+
+// render executes all templates of the launch's profile.
+func (s *LauncherService) render(spec *TemplateSpec) (*Manifests, error) {
+	p, err := s.Profile(spec.Profile)
+	if err != nil {
+		return nil, err
+	}
+	spec.Profile = p.Name
+	spec.ProfileVersion = p.Version
+	spec.naming = s.naming
+	spec.Params, _ = s.effectiveParams(p, spec.Tenant, spec.requestParams)
+	m := &Manifests{}
+
+	if p.PreHook != nil {
+		if m.PreHooks, err = NewHookObjectsFromTemplate(p.PreHook, spec); err != nil {
+			return nil, fmt.Errorf("error creating pre-launch hooks from template: %w", err)
+		}
+	}
+	if p.Job != nil {
+		if m.Job, err = NewJobFromTemplate(p.Job, s.Overlays["job"], spec); err != nil {
+			return nil, fmt.Errorf("error creating job from template: %w", err)
+		}
+		InjectScheduling(m.Job, s.Scheduling)
+		if s.SecurityDefaults {
+			InjectSecurityDefaults(m.Job, p.Settings.SecurityExceptions)
+		}
+		if err := setParamsAnnotation(m.Job, spec.Params); err != nil {
+			return nil, err
+		}
+		injectTrace(m.Job, spec)
+	}
+	if p.Service != nil {
+		if m.Service, err = NewServiceFromTemplate(p.Service, s.Overlays["service"], spec); err != nil {
+			return nil, fmt.Errorf("error creating service from template: %w", err)
+		}
+		RewriteService(m.Service, p.Settings)
+	}
+	if p.Ingress != nil {
+		if m.Ingress, err = NewIngressFromTemplate(p.Ingress, s.Overlays["ingress"], spec); err != nil {
+			return nil, fmt.Errorf("error creating ingress from template: %w", err)
+		}
+	}
+	if p.HTTPRoute != nil {
+		if m.HTTPRoute, err = NewUnstructuredFromTemplate("httproute", p.HTTPRoute, spec); err != nil {
+			return nil, fmt.Errorf("error creating HTTP route from template: %w", err)
+		}
+	}
+	if p.VirtualService != nil {
+		if m.VirtualService, err = NewUnstructuredFromTemplate("virtualservice", p.VirtualService, spec); err != nil {
+			return nil, fmt.Errorf("error creating virtual service from template: %w", err)
+		}
+	}
+	if p.DestinationRule != nil {
+		if m.DestinationRule, err = NewUnstructuredFromTemplate("destinationrule", p.DestinationRule, spec); err != nil {
+			return nil, fmt.Errorf("error creating destination rule from template: %w", err)
+		}
+	}
+	if p.PDB != nil {
+		if m.PodDisruptionBudget, err = NewPodDisruptionBudgetFromTemplate(p.PDB, s.Overlays["pdb"], spec); err != nil {
+			return nil, fmt.Errorf("error creating pod disruption budget from template: %w", err)
+		}
+	}
+	if p.NetworkPolicy != nil {
+		if m.NetworkPolicy, err = NewNetworkPolicyFromTemplate(p.NetworkPolicy, s.Overlays["networkpolicy"], spec); err != nil {
+			return nil, fmt.Errorf("error creating network policy from template: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// createOptions are the options of every create request.
+func (s *LauncherService) createOptions() metav1.CreateOptions {
+	return metav1.CreateOptions{FieldValidation: s.FieldValidation}
+}
+
+// logWarnings logs and counts the API server warnings of a launch.
+func (s *LauncherService) logWarnings(tenant *Tenant, videoId string, warnings *warningCollector) []string {
+	list := warnings.list()
+	for _, w := range list {
+		log.Printf("API server warning for video %s of tenant %s: %s", videoId, tenant.Name, w)
+		s.metrics.APIWarning(tenant.Name)
+	}
+	return list
+}
+
+func (s *LauncherService) launchJob(ctx context.Context, namespace string, job *batchv1.Job) (*batchv1.Job, error) {
+	j, err := s.jobs.CreateJob(ctx, namespace, job, s.createOptions())
+	if err != nil {
+		return nil, fmt.Errorf("error creating job %s: %w", job.Name, err)
+	}
+
+	return j, nil
+}
+
+func (s *LauncherService) launchService(ctx context.Context, namespace string, service *corev1.Service) (*corev1.Service, error) {
+	service, err := s.services.CreateService(ctx, namespace, service, s.createOptions())
+	if err != nil {
+		return nil, fmt.Errorf("error creating service: %w", err)
+	}
+
+	return service, nil
+}
+
+func (s *LauncherService) launchIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
+	ingress, err := s.ingresses.CreateIngress(ctx, namespace, ingress, s.createOptions())
+	if err != nil {
+		return nil, fmt.Errorf("error creating ingress: %w", err)
+	}
+
+	return ingress, nil
+}
+
+func (s *LauncherService) launchNetworkPolicy(ctx context.Context, namespace string, policy *networkingv1.NetworkPolicy) (*networkingv1.NetworkPolicy, error) {
+	policy, err := s.networkPolicyClient(namespace).Create(ctx, policy, s.createOptions())
+	if err != nil {
+		return nil, fmt.Errorf("error creating network policy: %w", err)
+	}
+
+	return policy, nil
+}
+
+func (s *LauncherService) launchPDB(ctx context.Context, namespace string, pdb *policyv1.PodDisruptionBudget) (*policyv1.PodDisruptionBudget, error) {
+	pdb, err := s.pdbClient(namespace).Create(ctx, pdb, s.createOptions())
+	if err != nil {
+		return nil, fmt.Errorf("error creating pod disruption budget: %w", err)
+	}
+
+	return pdb, nil
+}
+
+// checkConcurrentLimit counts the tenant's jobs that have not finished yet,
+// leaving out hook jobs.
+func (s *LauncherService) checkConcurrentLimit(ctx context.Context, tenant *Tenant, namespace string) error {
+	if tenant.MaxConcurrent <= 0 {
+		return nil
+	}
+
+	jobs, err := s.jobClient(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: ManagedLabelSelector() + fmt.Sprintf(",%s=%s,!%s", TenantLabel, tenant.Name, HookLabel),
+	})
+	if err != nil {
+		return fmt.Errorf("error listing jobs of tenant %s: %w", tenant.Name, err)
+	}
+
+	var running int
+	for _, job := range jobs.Items {
+		if !IsJobFinished(&job) {
+			running++
+		}
+	}
+	if running >= tenant.MaxConcurrent {
+		return fmt.Errorf("%w: tenant %s has %d concurrent launches", ErrQuotaExceeded, tenant.Name, running)
+	}
+
+	return nil
+}
+
+// checkLimits returns an error if the cluster or the tenant's limits don't
+// allow another launch.
+func (s *LauncherService) checkLimits(ctx context.Context, tenant *Tenant, namespace string) error {
+	if err := s.checkPaused(); err != nil {
+		s.metrics.QuotaRejected(tenant.Name, "maintenance")
+		return err
+	}
+	if err := s.checkCapacityLimit(); err != nil {
+		s.metrics.QuotaRejected(tenant.Name, "capacity")
+		return err
+	}
+	if err := s.Tenants.CheckHourlyLimit(tenant); err != nil {
+		s.metrics.QuotaRejected(tenant.Name, "hourly")
+		return err
+	}
+	if err := s.checkConcurrentLimit(ctx, tenant, namespace); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			s.metrics.QuotaRejected(tenant.Name, "concurrent")
+		}
+		return err
+	}
+	return nil
+}
+
+type LaunchRequest struct {
+	Tenant  *Tenant
+	VideoId string
+	Channel string
+	Profile string
+
+	// Idempotent launches return the existing launch instead of a conflict
+	Idempotent bool
+
+	// Debug includes the rendered manifests in the result
+	Debug bool
+
+	// Params override the profile's and tenant's template parameters
+	Params map[string]any
+
+	// At schedules the launch for later
+	At time.Time
+	// Queue holds the launch back instead of rejecting it while tenant limits
+	// or cluster capacity don't allow it
+	Queue bool
+
+	// TraceParent and RequestId of the HTTP request, passed on to the job
+	TraceParent string
+	RequestId   string
+}
+
+type LaunchResult struct {
+	Job       *JobSummary      `json:"job,omitempty"`
+	Existing  bool             `json:"existing,omitempty"`
+	URL       string           `json:"url,omitempty"`
+	Manifests []runtime.Object `json:"manifests,omitempty"`
+	Warnings  []string         `json:"warnings,omitempty"`
+	// Timings of the launch's phases, for debugging
+	Timings []PhaseTiming `json:"timings,omitempty"`
+
+	// Pending is set instead of Job for scheduled and queued launches
+	Pending *PendingLaunch `json:"pending,omitempty"`
+}
+
+type JobSummary struct {
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace"`
+	Tenant    string       `json:"tenant,omitempty"`
+	VideoId   string       `json:"videoId"`
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	Status    string       `json:"status"`
+
+	// LastHeartbeat is set once the job sent a heartbeat, and Stale while
+	// its heartbeats are overdue
+	LastHeartbeat *metav1.Time `json:"lastHeartbeat,omitempty"`
+	Stale         bool         `json:"stale,omitempty"`
+}
+
+func NewJobSummary(job *batchv1.Job) *JobSummary {
+	summary := &JobSummary{
+		Name:      job.Name,
+		Namespace: job.Namespace,
+		Tenant:    job.Labels[TenantLabel],
+		VideoId:   job.Labels[VideoIdLabel],
+		StartTime: job.Status.StartTime,
+		Status:    JobStatus(job),
+	}
+	if last, ok := lastHeartbeat(job); ok {
+		t := metav1.NewTime(last)
+		summary.LastHeartbeat = &t
+	}
+	_, summary.Stale = job.Annotations[StaleAnnotation]
+	return summary
+}
+
+// errLaunchExisting stops an idempotent launch whose job already exists.
+var errLaunchExisting = errors.New("launch exists")
+
+// LaunchExistsError is returned when the job of a launch already exists.
+type LaunchExistsError struct {
+	Job *JobSummary
+}
+
+func (e *LaunchExistsError) Error() string {
+	return fmt.Sprintf("launch of video %s already exists as job %s (%s)", e.Job.VideoId, e.Job.Name, e.Job.Status)
+}
+
+func (s *LauncherService) Launch(ctx context.Context, req *LaunchRequest) (result *LaunchResult, err error) {
+	if req.VideoId == "" {
+		return nil, fmt.Errorf("%w: video ID cannot be empty", ErrInvalidRequest)
+	}
+	tenant := req.Tenant
+
+	inflightLaunches.Inc()
+	defer inflightLaunches.Dec()
+
+	ctx, warnings := withWarningCollector(ctx)
+	defer func() {
+		list := s.logWarnings(tenant, req.VideoId, warnings)
+		if result != nil && s.ReturnWarnings {
+			result.Warnings = list
+		}
+	}()
+
+	defer func() {
+		outcome := "success"
+		var existsErr *LaunchExistsError
+		if errors.As(err, &existsErr) {
+			outcome = "conflict"
+		} else if err != nil {
+			outcome = "error"
+		} else if result.Pending != nil {
+			outcome = "pending"
+		}
+		s.metrics.Launched(tenant.Name, outcome)
+	}()
+
+	spec := &TemplateSpec{
+		VideoId:   req.VideoId,
+		Channel:   req.Channel,
+		Tenant:    tenant.Name,
+		Profile:   req.Profile,
+		Namespace: s.NamespaceFor(tenant),
+
+		TraceParent: req.TraceParent,
+		RequestId:   req.RequestId,
+
+		requestParams: req.Params,
+	}
+
+	if req.At.After(time.Now()) {
+		return s.enqueue(ctx, req, PendingScheduled, nil)
+	}
+
+	// Check tenant limits. The tenant is locked until the job is created, so
+	// concurrent launches can't both pass them, but not while waiting for
+	// readiness, or launches would queue up behind each other's pods.
+	lock := &tenantLock{registry: s.Tenants, tenant: tenant}
+	lock.acquire()
+	defer lock.release()
+	if err := s.checkLimits(ctx, tenant, spec.Namespace); err != nil {
+		if req.Queue && retryable(err) {
+			return s.enqueue(ctx, req, PendingQueued, err)
+		}
+		return nil, err
+	}
+
+	timer := &phaseTimer{}
+	if req.Debug {
+		defer func() {
+			if result != nil {
+				result.Timings = timer.phases
+			}
+		}()
+	}
+
+	start := time.Now()
+	manifests, err := s.renderUnique(ctx, spec)
+	timer.observe(PhaseRender, start)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateManifests(manifests); err != nil {
+		return nil, err
+	}
+
+	result = &LaunchResult{URL: spec.URL}
+	if req.Debug {
+		result.Manifests = RedactManifests(manifests.Objects())
+	}
+
+	// The video may have a job under an older name, which creating the job
+	// wouldn't run into
+	if manifests.Job != nil {
+		existing, err := s.renamedJob(ctx, spec, manifests.Job.Name)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			if req.Idempotent {
+				result.Job = NewJobSummary(existing)
+				result.Existing = true
+				return result, nil
+			}
+			return nil, &LaunchExistsError{Job: NewJobSummary(existing)}
+		}
+	}
+
+	if s.DryRunValidate {
+		start := time.Now()
+		errs := s.serverDryRun(ctx, spec.Namespace, manifests)
+		timer.observe(PhaseDryRun, start)
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+	}
+
+	if s.CheckResourceQuotas && manifests.Job != nil {
+		start := time.Now()
+		err := s.checkResourceQuotas(ctx, spec.Namespace, manifests.Job)
+		timer.observe(PhaseResourceQuotas, start)
+		if err != nil {
+			if errors.Is(err, ErrQuotaExceeded) {
+				s.metrics.QuotaRejected(tenant.Name, "resourcequota")
+			}
+			return nil, err
+		}
+	}
+
+	p, err := s.Profile(spec.Profile)
+	if err != nil {
+		return nil, err
+	}
+	var credentials *corev1.Secret
+	tasks := map[string]*creationTask{}
+
+	if len(manifests.PreHooks) > 0 {
+		var created []HookRef
+		tasks[StepPreHooks] = &creationTask{
+			Create: func(ctx context.Context) error {
+				refs, createdHooks, err := s.launchPreHooks(ctx, spec.Namespace, manifests.PreHooks)
+				created = createdHooks
+				if err != nil {
+					return fmt.Errorf("error creating pre-launch hooks: %w", err)
+				}
+				if manifests.Job != nil {
+					data, err := json.Marshal(refs)
+					if err != nil {
+						return err
+					}
+					if manifests.Job.Annotations == nil {
+						manifests.Job.Annotations = map[string]string{}
+					}
+					manifests.Job.Annotations[HookResourcesAnnotation] = string(data)
+				}
+				return nil
+			},
+			Undo: func(ctx context.Context) error {
+				return s.deleteHooks(ctx, spec.Namespace, created)
+			},
+		}
+	}
+
+	// Restrict the network before the job's pods start
+	if manifests.NetworkPolicy != nil {
+		var created bool
+		tasks[StepNetworkPolicy] = &creationTask{
+			Create: func(ctx context.Context) error {
+				_, err := s.launchNetworkPolicy(ctx, spec.Namespace, manifests.NetworkPolicy)
+				if apierrors.IsAlreadyExists(err) {
+					return nil
+				} else if err != nil {
+					return fmt.Errorf("error creating network policy: %w", err)
+				}
+				created = true
+				return nil
+			},
+			Undo: func(ctx context.Context) error {
+				if !created {
+					return nil
+				}
+				err := s.networkPolicyClient(spec.Namespace).Delete(ctx, manifests.NetworkPolicy.Name, metav1.DeleteOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					return fmt.Errorf("error deleting network policy %s: %w", manifests.NetworkPolicy.Name, err)
+				}
+				return nil
+			},
+		}
+	}
+
+	// Issue credentials before the job's pods need them, credentials is only
+	// set when they were issued for this launch
+	if s.Credentials != nil {
+		tasks[StepCredentials] = &creationTask{
+			Create: func(ctx context.Context) (err error) {
+				credentials, err = s.provisionCredentials(ctx, spec)
+				return err
+			},
+			Undo: func(ctx context.Context) error {
+				if credentials == nil {
+					return nil
+				}
+				// Don't leave live credentials without a job behind
+				s.revokeCredentials(ctx, credentials.Annotations[CredentialsLeaseAnnotation])
+				err := s.Clientset.CoreV1().Secrets(spec.Namespace).Delete(ctx, credentials.Name, metav1.DeleteOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					return fmt.Errorf("error deleting credentials %s: %w", credentials.Name, err)
+				}
+				return nil
+			},
+		}
+	}
+
+	tasks[StepJob] = &creationTask{
+		Create: func(ctx context.Context) error {
+			defer lock.release()
+			if !lock.held() {
+				// Other launches went ahead while a step waited
+				lock.acquire()
+				if err := s.checkLimits(ctx, tenant, spec.Namespace); err != nil {
+					return err
+				}
+			}
+			if manifests.Job != nil {
+				job, err := s.launchJob(ctx, spec.Namespace, manifests.Job)
+				if apierrors.IsAlreadyExists(err) {
+					existing, getErr := s.jobClient(spec.Namespace).Get(ctx, manifests.Job.Name, metav1.GetOptions{})
+					if getErr != nil {
+						return fmt.Errorf("error getting existing job %s: %w", manifests.Job.Name, getErr)
+					}
+					if req.Idempotent {
+						result.Job = NewJobSummary(existing)
+						result.Existing = true
+						return errLaunchExisting
+					}
+					return &LaunchExistsError{Job: NewJobSummary(existing)}
+				} else if err != nil {
+					return fmt.Errorf("error creating job: %w", err)
+				}
+				result.Job = NewJobSummary(job)
+				s.LaunchHistory.Add(&LaunchRecord{
+					Namespace: job.Namespace,
+					Job:       job.Name,
+					VideoId:   req.VideoId,
+					Channel:   req.Channel,
+					Tenant:    tenant.Name,
+					StartedAt: job.CreationTimestamp.Time,
+					Outcome:   OutcomeRunning,
+				})
+			}
+			s.Tenants.RecordLaunch(tenant)
+			return nil
+		},
+		Ready: func(ctx context.Context) (bool, error) {
+			if manifests.Job == nil {
+				return true, nil
+			}
+			return s.jobReady(ctx, spec.Namespace, manifests.Job.Name)
+		},
+	}
+
+	if manifests.Service != nil {
+		tasks[StepService] = &creationTask{
+			Create: func(ctx context.Context) error {
+				if _, err := s.launchService(ctx, spec.Namespace, manifests.Service); err != nil {
+					return fmt.Errorf("error creating service: %w", err)
+				}
+				return nil
+			},
+			Ready: func(ctx context.Context) (bool, error) {
+				return s.serviceReady(ctx, spec.Namespace, manifests.Service.Name)
+			},
+		}
+	}
+	if manifests.Ingress != nil {
+		tasks[StepIngress] = &creationTask{
+			Create: func(ctx context.Context) error {
+				createdAt := time.Now()
+				ingress, err := s.launchIngress(ctx, spec.Namespace, manifests.Ingress)
+				if err != nil {
+					return fmt.Errorf("error creating ingress: %w", err)
+				}
+				if s.IngressReadiness != nil {
+					s.checkIngressReadiness(spec.Namespace, ingress, createdAt)
+				}
+				return nil
+			},
+			Ready: func(ctx context.Context) (bool, error) {
+				return s.ingressReady(ctx, spec.Namespace, manifests.Ingress.Name)
+			},
+		}
+	}
+	for _, custom := range []struct {
+		step string
+		obj  *unstructured.Unstructured
+		what string
+	}{
+		{StepHTTPRoute, manifests.HTTPRoute, "HTTP route"},
+		{StepDestinationRule, manifests.DestinationRule, "destination rule"},
+		{StepVirtualService, manifests.VirtualService, "virtual service"},
+	} {
+		if custom.obj == nil {
+			continue
+		}
+		custom := custom
+		tasks[custom.step] = &creationTask{Create: func(ctx context.Context) error {
+			if _, err := s.launchUnstructured(ctx, spec.Namespace, custom.obj); err != nil {
+				return fmt.Errorf("error creating %s: %w", custom.what, err)
+			}
+			return nil
+		}}
+	}
+	if manifests.PodDisruptionBudget != nil {
+		tasks[StepPDB] = &creationTask{Create: func(ctx context.Context) error {
+			if _, err := s.launchPDB(ctx, spec.Namespace, manifests.PodDisruptionBudget); err != nil {
+				return fmt.Errorf("error creating pod disruption budget: %w", err)
+			}
+			return nil
+		}}
+	}
+
+	if err := runCreation(ctx, p.Settings.Creation, tasks, timer, lock.release); errors.Is(err, errLaunchExisting) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *LauncherService) CleanupWatcher(ctx context.Context, namespace string) error {
+	labelSelector := ManagedLabelSelector()
+
+	var relist <-chan time.Time
+	if s.Tuning.RelistInterval > 0 {
+		ticker := time.NewTicker(s.Tuning.RelistInterval)
+		defer ticker.Stop()
+		relist = ticker.C
+	}
+
+	// Resource version to resume watching from, empty when a relist is due
+	resourceVersion := ""
+	relistReason := "start"
+
+	for ctx.Err() == nil {
+		if resourceVersion == "" {
+			// List jobs to clean up completions missed while not watching
+			s.metrics.Relisted(namespace, relistReason)
+			jobs, err := s.watcher.ListJobs(ctx, namespace, metav1.ListOptions{
+				LabelSelector: labelSelector,
+			})
+			if err != nil {
+				return fmt.Errorf("error listing jobs: %w", err)
+			}
+			watchBacklog.Add(float64(len(jobs.Items)))
+			for i := range jobs.Items {
+				s.handleJob(ctx, namespace, &jobs.Items[i])
+				watchBacklog.Dec()
+			}
+			resourceVersion = jobs.ResourceVersion
+		}
+
+		// Start watching for jobs
+		opts := metav1.ListOptions{
+			LabelSelector:       labelSelector,
+			ResourceVersion:     resourceVersion,
+			AllowWatchBookmarks: true,
+		}
+		if s.Tuning.WatchTimeout > 0 {
+			timeout := int64(s.Tuning.WatchTimeout.Seconds())
+			opts.TimeoutSeconds = &timeout
+		}
+		w, err := s.watcher.WatchJobs(ctx, namespace, opts)
+		if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+			resourceVersion, relistReason = "", "expired"
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error watching jobs: %w", err)
+		}
+
+	events:
+		for {
+			select {
+			case event, ok := <-w.ResultChan():
+				if !ok {
+					// Timed out, resume from the last seen resource version
+					break events
+				}
+				s.metrics.WatchEvent(namespace, string(event.Type))
+
+				switch event.Type {
+				case watch.Bookmark:
+					if obj, err := meta.Accessor(event.Object); err == nil {
+						resourceVersion = obj.GetResourceVersion()
+					}
+				case watch.Error:
+					err := apierrors.FromObject(event.Object)
+					log.Printf("CleanupWatcher got error watching jobs in namespace %s: %v", namespace, err)
+					if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+						resourceVersion, relistReason = "", "expired"
+					}
+					break events
+				case watch.Deleted:
+					// Deleted jobs were cleaned up or torn down already, and
+					// relists don't see them either. Handling them would bring
+					// back the records of purged videos.
+					if obj, err := meta.Accessor(event.Object); err == nil {
+						resourceVersion = obj.GetResourceVersion()
+					}
+				default:
+					job, ok := event.Object.(*batchv1.Job)
+					if !ok {
+						log.Printf("CleanupWatcher got unexpected object type: %T", event.Object)
+						continue
+					}
+					resourceVersion = job.ResourceVersion
+					watchBacklog.Inc()
+					s.handleJob(ctx, namespace, job)
+					watchBacklog.Dec()
+				}
+			case <-relist:
+				resourceVersion, relistReason = "", "interval"
+				break events
+			case <-ctx.Done():
+				break events
+			}
+		}
+		w.Stop()
+	}
+
+	return nil
+}
+
+func (s *LauncherService) handleJob(ctx context.Context, namespace string, job *batchv1.Job) {
+	if _, ok := job.Labels[HookLabel]; ok {
+		s.handleHookJob(ctx, namespace, job)
+		return
+	}
+
+	if IsJobFinished(job) {
+		s.LaunchHistory.Finish(job)
+	}
+	if job.Status.Succeeded > 0 && s.Artifacts != nil {
+		s.registerArtifacts(ctx, namespace, job)
+	}
+
+	if s.cleanupPolicy(job) {
+		// Only clean up once per job, jobs keep getting updated after completion
+		if s.markCleanedUp(ctx, job) {
+			return
+		}
+		s.scheduleCleanup(ctx, namespace, job)
+	}
+}
+
+// cleanupTarget lists, retires and deletes one kind of resource created for a
+// job.
+type cleanupTarget struct {
+	Kind   string
+	List   func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]metav1.Object, error)
+	Patch  func(ctx context.Context, namespace string, name string, patch []byte) error
+	Delete func(ctx context.Context, namespace string, name string) error
+}
+
+func objects[T any, PT object[T]](items []T) []metav1.Object {
+	objects := make([]metav1.Object, 0, len(items))
+	for i := range items {
+		objects = append(objects, PT(&items[i]))
+	}
+	return objects
+}
+
+func (s *LauncherService) cleanupTargets() []cleanupTarget {
+	targets := []cleanupTarget{
+		{
+			Kind: "Service",
+			List: func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+				list, err := s.serviceClient(namespace).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(list.Items), nil
+			},
+			Patch: func(ctx context.Context, namespace string, name string, patch []byte) error {
+				_, err := s.serviceClient(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+			Delete: func(ctx context.Context, namespace string, name string) error {
+				return s.serviceClient(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			Kind: "Ingress",
+			List: func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+				list, err := s.ingressClient(namespace).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(list.Items), nil
+			},
+			Patch: func(ctx context.Context, namespace string, name string, patch []byte) error {
+				_, err := s.ingressClient(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+			Delete: func(ctx context.Context, namespace string, name string) error {
+				return s.ingressClient(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			Kind: "NetworkPolicy",
+			List: func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+				list, err := s.networkPolicyClient(namespace).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(list.Items), nil
+			},
+			Patch: func(ctx context.Context, namespace string, name string, patch []byte) error {
+				_, err := s.networkPolicyClient(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+			Delete: func(ctx context.Context, namespace string, name string) error {
+				return s.networkPolicyClient(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			Kind: "PodDisruptionBudget",
+			List: func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]metav1.Object, error) {
+				list, err := s.pdbClient(namespace).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(list.Items), nil
+			},
+			Patch: func(ctx context.Context, namespace string, name string, patch []byte) error {
+				_, err := s.pdbClient(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+				return err
+			},
+			Delete: func(ctx context.Context, namespace string, name string) error {
+				return s.pdbClient(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+	}
+
+	// Only look for custom resources that are used, their CRDs may be missing
+	used := map[schema.GroupKind]bool{}
+	for _, p := range s.allProfiles() {
+		used[HTTPRouteGroupKind] = used[HTTPRouteGroupKind] || p.HTTPRoute != nil
+		used[VirtualServiceGroupKind] = used[VirtualServiceGroupKind] || p.VirtualService != nil
+		used[DestinationRuleGroupKind] = used[DestinationRuleGroupKind] || p.DestinationRule != nil
+	}
+	for _, gk := range []schema.GroupKind{HTTPRouteGroupKind, VirtualServiceGroupKind, DestinationRuleGroupKind} {
+		if used[gk] {
+			targets = append(targets, s.unstructuredCleanupTarget(gk))
+		}
+	}
+
+	return targets
+}
+
+// cleanup deletes the resources associated with a completed job. It can be
+// retried, resources that are gone already are skipped.
+func (s *LauncherService) cleanup(ctx context.Context, namespace string, job *batchv1.Job) error {
+	log.Printf("job %s has completed, deleting associated resources", job.Name)
+	errs := []error{
+		s.deleteResources(ctx, namespace, job, s.RestoreWindow > 0),
+		s.deleteCredentials(ctx, namespace, job),
+	}
+
+	// Remove pre-launch hook resources and start the post-launch hook
+	errs = append(errs, s.deleteHookResources(ctx, namespace, job, true))
+	if err := s.launchPostHook(ctx, namespace, job); err != nil {
+		log.Printf("error launching post-launch hook of job %s: %v", job.Name, err)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// deleteResources deletes the resources of every cleanup target that belong
+// to the job's video. With retire, they are only retired, and resources that
+// are retired or restored already are left alone.
+func (s *LauncherService) deleteResources(ctx context.Context, namespace string, job *batchv1.Job, retire bool) error {
+	videoLabelSelector := ManagedLabelSelector() + fmt.Sprintf(",%s=%s", VideoIdLabel, job.Labels[VideoIdLabel])
+	if tenant, ok := job.Labels[TenantLabel]; ok {
+		videoLabelSelector += fmt.Sprintf(",%s=%s", TenantLabel, tenant)
+	}
+	if retire {
+		videoLabelSelector += ",!" + RetirementLabel
+	}
+
+	var errs []error
+	for _, target := range s.cleanupTargets() {
+		// Find the resources
+		objects, err := target.List(ctx, namespace, metav1.ListOptions{
+			LabelSelector: videoLabelSelector,
+		})
+		if err != nil {
+			log.Printf("error listing %s: %v", target.Kind, err)
+			errs = append(errs, fmt.Errorf("error listing %s: %w", target.Kind, err))
+			continue
+		}
+
+		// Delete or retire the resources
+		for _, obj := range objects {
+			name, verb, action := obj.GetName(), "deleting", CleanupDelete
+			if retire {
+				verb, action = "retiring", CleanupRetire
+				err = s.retire(ctx, target, namespace, name)
+			} else {
+				err = target.Delete(ctx, namespace, name)
+			}
+			if apierrors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				log.Printf("error %s %s %s: %v", verb, target.Kind, name, err)
+				errs = append(errs, fmt.Errorf("error %s %s %s: %w", verb, target.Kind, name, err))
+			}
+			s.recordCleanup(cleanupActionOf(job, action, target.Kind, name), err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cleanupActionOf describes an action on a resource of the job's video.
+func cleanupActionOf(job *batchv1.Job, action string, kind string, name string) CleanupAction {
+	return CleanupAction{
+		Namespace: job.Namespace,
+		Tenant:    job.Labels[TenantLabel],
+		VideoId:   job.Labels[VideoIdLabel],
+		Job:       job.Name,
+		Action:    action,
+		Kind:      kind,
+		Name:      name,
+	}
+}
+
+func (s *LauncherService) recordCleanup(action CleanupAction, err error) {
+	action.Time = time.Now().UTC()
+	action.Success = err == nil
+	if err != nil {
+		action.Error = err.Error()
+	}
+	s.CleanupHistory.Record(action)
+}
+
+type LaunchStatus struct {
+	Job       *JobSummary `json:"job"`
+	Services  []string    `json:"services"`
+	Ingresses []string    `json:"ingresses"`
+	Progress  *Progress   `json:"progress,omitempty"`
+
+	// IngressReady is set while ingress readiness is checked, once every
+	// ingress is reachable
+	IngressReady   *bool        `json:"ingressReady,omitempty"`
+	IngressReadyAt *metav1.Time `json:"ingressReadyAt,omitempty"`
+
+	// ETag changes with the resource versions of the job and its resources,
+	// and with the progress
+	ETag string `json:"-"`
+}
+
+func (s *LauncherService) Status(ctx context.Context, tenant *Tenant, videoId string) (*LaunchStatus, error) {
+	job, err := s.FindJob(ctx, tenant, videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &LaunchStatus{
+		Job:       NewJobSummary(job),
+		Services:  []string{},
+		Ingresses: []string{},
+	}
+	selector := ManagedLabelSelector() + fmt.Sprintf(",%s=%s,%s=%s", VideoIdLabel, videoId, TenantLabel, job.Labels[TenantLabel])
+	etagParts := []string{jobETagPart(job)}
+
+	services, err := s.serviceClient(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("error listing services: %w", err)
+	}
+	for _, svc := range services.Items {
+		status.Services = append(status.Services, svc.Name)
+		etagParts = append(etagParts, "service/"+svc.Name+"/"+svc.ResourceVersion)
+	}
+
+	ingresses, err := s.ingressClient(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("error listing ingresses: %w", err)
+	}
+	for _, ing := range ingresses.Items {
+		status.Ingresses = append(status.Ingresses, ing.Name)
+		etagParts = append(etagParts, "ingress/"+ing.Name+"/"+ing.ResourceVersion)
+	}
+	if s.IngressReadiness != nil && len(ingresses.Items) > 0 {
+		ready := true
+		var readyAt time.Time
+		for i := range ingresses.Items {
+			t, ok := ingressReachable(&ingresses.Items[i])
+			ready = ready && ok
+			if t.After(readyAt) {
+				readyAt = t
+			}
+		}
+		status.IngressReady = &ready
+		if ready {
+			status.IngressReadyAt = &metav1.Time{Time: readyAt}
+		}
+	}
+
+	if status.Progress, err = s.JobProgress(ctx, job); err != nil {
+		return nil, err
+	}
+	if status.Progress != nil {
+		progress, err := json.Marshal(status.Progress)
+		if err != nil {
+			return nil, err
+		}
+		etagParts = append(etagParts, "progress/"+string(progress))
+	}
+	status.ETag = newETag(etagParts)
+
+	return status, nil
+}
+
+// scopedJobs lists the launched jobs of a tenant, or of every tenant for a nil
+// tenant, that also match the label selector requirements in filter, if any.
+// Hook jobs share the labels of the job they belong to, and are left out.
+func (s *LauncherService) scopedJobs(ctx context.Context, tenant *Tenant, filter string) ([]*batchv1.Job, error) {
+	selector := ManagedLabelSelector() + ",!" + HookLabel + filter
+	namespaces := s.Tenants.Namespaces(s.Namespace)
+	if tenant != nil {
+		selector += fmt.Sprintf(",%s=%s", TenantLabel, tenant.Name)
+		namespaces = []string{s.NamespaceFor(tenant)}
+	}
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*batchv1.Job
+	for _, ns := range namespaces {
+		list, err := s.listJobs(ctx, ns, parsed)
+		if err != nil {
+			return nil, fmt.Errorf("error listing jobs: %w", err)
+		}
+		jobs = append(jobs, list...)
+	}
+	return jobs, nil
+}
+
+// MaxStatuses limits how many videos one Statuses call may ask for.
+const MaxStatuses = 1000
+
+// Statuses returns the latest job of each of the videos of a tenant, or of
+// every tenant for a nil tenant, in one list from the job cache. Videos
+// without a job map to nil.
+func (s *LauncherService) Statuses(ctx context.Context, tenant *Tenant, videoIds []string) (map[string]*JobSummary, error) {
+	if len(videoIds) > MaxStatuses {
+		return nil, fmt.Errorf("%w: at most %d video IDs per request, got %d", ErrInvalidRequest, MaxStatuses, len(videoIds))
+	}
+	statuses := make(map[string]*JobSummary, len(videoIds))
+	if len(videoIds) == 0 {
+		return statuses, nil
+	}
+	requirement, err := labels.NewRequirement(VideoIdLabel, selection.In, videoIds)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid video IDs: %v", ErrInvalidRequest, err)
+	}
+	jobs, err := s.scopedJobs(ctx, tenant, ","+requirement.String())
+	if err != nil {
+		return nil, err
+	}
+
+	latest := map[string]*batchv1.Job{}
+	for _, job := range jobs {
+		videoId := job.Labels[VideoIdLabel]
+		if prev, ok := latest[videoId]; !ok || job.CreationTimestamp.After(prev.CreationTimestamp.Time) {
+			latest[videoId] = job
+		}
+	}
+	for _, videoId := range videoIds {
+		statuses[videoId] = nil
+		if job, ok := latest[videoId]; ok {
+			statuses[videoId] = NewJobSummary(job)
+		}
+	}
+	return statuses, nil
+}
+
+// List returns the jobs of a tenant, or of every tenant for a nil tenant.
+func (s *LauncherService) List(ctx context.Context, tenant *Tenant) ([]*JobSummary, error) {
+	jobs, err := s.scopedJobs(ctx, tenant, "")
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]*JobSummary, 0, len(jobs))
+	for _, job := range jobs {
+		summaries = append(summaries, NewJobSummary(job))
+	}
+	return summaries, nil
+}
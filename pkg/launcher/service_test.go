@@ -0,0 +1,1425 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testJobTemplate = `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: recorder-{{ .UniqueName }}
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: recorder
+        image: busybox
+`
+	testServiceTemplate = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: recorder-svc-{{ .UniqueName }}
+spec:
+  selector:
+    {{ .VideoIdLabel }}: "{{ .VideoId }}"
+  ports:
+  - port: 80
+`
+)
+
+func newTestService(t *testing.T, opts ...Option) *LauncherService {
+	t.Helper()
+
+	tenants, err := NewTenantRegistry(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := NewCleanupHistory(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiles := map[string]*Profile{
+		DefaultProfileName: {
+			Name:    DefaultProfileName,
+			Job:     template.Must(template.New("job").Parse(testJobTemplate)),
+			Service: template.Must(template.New("service").Parse(testServiceTemplate)),
+		},
+	}
+	s := NewFakeCluster("test", tenants, profiles, opts...)
+	s.CleanupHistory = history
+	return s
+}
+
+func testLaunchRequest(s *LauncherService, videoId string) *LaunchRequest {
+	return &LaunchRequest{
+		Tenant:  s.Tenants.tenants[DefaultTenantName],
+		VideoId: videoId,
+	}
+}
+
+func TestLaunch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if result.Job.VideoId != "abc" || result.Job.Status != "active" {
+		t.Errorf("unexpected job summary %+v", result.Job)
+	}
+
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	for label, want := range map[string]string{
+		VideoIdLabel: "abc",
+		TenantLabel:  DefaultTenantName,
+		ProfileLabel: DefaultProfileName,
+	} {
+		if got := job.Labels[label]; got != want {
+			t.Errorf("job label %s = %q, want %q", label, got, want)
+		}
+	}
+
+	services, err := s.serviceClient("test").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list services: %v", err)
+	}
+	if len(services.Items) != 1 {
+		t.Errorf("got %d services, want 1", len(services.Items))
+	}
+}
+
+func TestLaunchExisting(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+
+	var existsErr *LaunchExistsError
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); !errors.As(err, &existsErr) {
+		t.Fatalf("second Launch error = %v, want LaunchExistsError", err)
+	}
+
+	req := testLaunchRequest(s, "abc")
+	req.Idempotent = true
+	result, err := s.Launch(ctx, req)
+	if err != nil {
+		t.Fatalf("idempotent Launch: %v", err)
+	}
+	if !result.Existing {
+		t.Errorf("idempotent Launch did not return the existing job")
+	}
+}
+
+func TestLaunchExistingRenamed(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	// A job launched before the hash got longer
+	defer func(length int) { NameHashLength = length }(NameHashLength)
+	NameHashLength = 8
+	old, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	NameHashLength = 16
+
+	var existsErr *LaunchExistsError
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); !errors.As(err, &existsErr) {
+		t.Fatalf("Launch after the upgrade error = %v, want LaunchExistsError", err)
+	}
+	if existsErr.Job == nil || existsErr.Job.Name != old.Job.Name {
+		t.Errorf("LaunchExistsError job = %+v, want %s", existsErr.Job, old.Job.Name)
+	}
+	req := testLaunchRequest(s, "abc")
+	req.Idempotent = true
+	if result, err := s.Launch(ctx, req); err != nil || !result.Existing || result.Job.Name != old.Job.Name {
+		t.Errorf("idempotent Launch = %+v, %v, want the existing job %s", result, err, old.Job.Name)
+	}
+	if jobs, _ := s.jobClient("test").List(ctx, metav1.ListOptions{}); len(jobs.Items) != 1 {
+		t.Errorf("%d jobs, want only the old one", len(jobs.Items))
+	}
+}
+
+func TestLaunchTimings(t *testing.T) {
+	s := newTestService(t)
+
+	req := testLaunchRequest(s, "abc")
+	req.Debug = true
+	result, err := s.Launch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	var phases []string
+	for _, timing := range result.Timings {
+		phases = append(phases, timing.Phase)
+	}
+	if want := []string{PhaseRender, StepJob, StepService}; !reflect.DeepEqual(phases, want) {
+		t.Errorf("timed phases = %v, want %v", phases, want)
+	}
+}
+
+func TestLaunchTrace(t *testing.T) {
+	s := newTestService(t)
+
+	req := testLaunchRequest(s, "abc")
+	req.TraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req.RequestId = "req-1"
+	result, err := s.Launch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	job, err := s.jobClient(result.Job.Namespace).Get(context.Background(), result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get job: %v", err)
+	}
+	if got := job.Spec.Template.Annotations[TraceParentAnnotation]; got != req.TraceParent {
+		t.Errorf("pod traceparent annotation = %q, want %q", got, req.TraceParent)
+	}
+	env := map[string]string{}
+	for _, v := range job.Spec.Template.Spec.Containers[0].Env {
+		env[v.Name] = v.Value
+	}
+	if env[TraceParentEnv] != req.TraceParent || env[RequestIdEnv] != "req-1" {
+		t.Errorf("container env = %v, want the trace context", env)
+	}
+
+	if ValidTraceParent("00-00000000000000000000000000000000-00f067aa0ba902b7-01") {
+		t.Errorf("traceparent with an all-zero trace ID is valid")
+	}
+}
+
+func TestLaunchInvalidLabel(t *testing.T) {
+	s := newTestService(t)
+	s.Profiles[DefaultProfileName].Job = template.Must(template.New("job").Parse(`
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: recorder-{{ .UniqueName }}
+  labels:
+    stream: "{{ .VideoId }}-"
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: recorder
+        image: busybox
+`))
+
+	_, err := s.Launch(context.Background(), testLaunchRequest(s, "abc"))
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), `metadata.labels[stream]: Invalid value: "abc-"`) {
+		t.Fatalf("Launch error = %v, want ErrValidation naming the label", err)
+	}
+	if jobs, _ := s.jobClient("test").List(context.Background(), metav1.ListOptions{}); len(jobs.Items) != 0 {
+		t.Errorf("created %d jobs, want none", len(jobs.Items))
+	}
+}
+
+func TestRedactHookSecrets(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.Profiles[DefaultProfileName].PreHook = template.Must(template.New("pre-hook").Parse(`
+apiVersion: v1
+kind: Secret
+metadata:
+  name: recorder-secret-{{ .UniqueName }}
+data:
+  token: aHVudGVyMg==
+stringData:
+  password: hunter2
+`))
+
+	leaked := func(objects []runtime.Object) bool {
+		data, err := json.Marshal(objects)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Contains(string(data), "hunter2") || strings.Contains(string(data), "aHVudGVyMg==")
+	}
+
+	req := testLaunchRequest(s, "abc")
+	req.Debug = true
+	result, err := s.Launch(ctx, req)
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if len(result.Manifests) == 0 || leaked(result.Manifests) {
+		t.Errorf("debug launch returned hook Secret data")
+	}
+
+	dryRun, err := s.DryRun(ctx, testLaunchRequest(s, "def"))
+	if err != nil {
+		t.Fatalf("DryRun: %v", err)
+	}
+	if len(dryRun.Manifests) == 0 || leaked(dryRun.Manifests) {
+		t.Errorf("dry run returned hook Secret data")
+	}
+}
+
+func TestLaunchConcurrentReadiness(t *testing.T) {
+	CreationPollInterval = time.Millisecond
+	defer func() { CreationPollInterval = time.Second }()
+	ctx := context.Background()
+	s := newTestService(t)
+	s.Profiles[DefaultProfileName].Settings.Creation = []CreationStep{
+		{Step: StepJob, WaitFor: []string{StepService}, Timeout: "5s"},
+	}
+	s.Tenants.tenants[DefaultTenantName].MaxConcurrent = 2
+
+	errs := make(chan error, 2)
+	launch := func(videoId string) {
+		go func() {
+			_, err := s.Launch(ctx, testLaunchRequest(s, videoId))
+			errs <- err
+		}()
+	}
+	waitService := func(videoId string) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			services, err := s.serviceClient("test").List(ctx, metav1.ListOptions{LabelSelector: VideoIdLabel + "=" + videoId})
+			if err == nil && len(services.Items) > 0 {
+				return
+			}
+		}
+		t.Fatalf("service of %s was not created while another launch waited", videoId)
+	}
+
+	// The second launch gets going while the first waits for endpoints
+	launch("abc")
+	waitService("abc")
+	launch("def")
+	waitService("def")
+
+	for _, videoId := range []string{"abc", "def"} {
+		endpoints := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "recorder-svc-" + s.naming(videoId, 0)},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+		}
+		if _, err := s.Clientset.CoreV1().Endpoints("test").Create(ctx, endpoints, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Launch: %v", err)
+		}
+	}
+}
+
+func TestLaunchUnknownProfile(t *testing.T) {
+	s := newTestService(t)
+
+	req := testLaunchRequest(s, "abc")
+	req.Profile = "missing"
+	if _, err := s.Launch(context.Background(), req); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Launch error = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "def")); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Status.Active = 0
+	job.Status.Succeeded = 1
+	s.handleJob(ctx, "test", job)
+
+	// Only the service of the finished video is deleted
+	services, err := s.serviceClient("test").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list services: %v", err)
+	}
+	if len(services.Items) != 1 || services.Items[0].Labels[VideoIdLabel] != "def" {
+		t.Errorf("unexpected services after cleanup: %v", services.Items)
+	}
+
+	actions := s.CleanupHistory.List("", "abc")
+	if len(actions) != 1 || actions[0].Kind != "Service" || !actions[0].Success {
+		t.Errorf("unexpected cleanup history %+v", actions)
+	}
+}
+
+func TestRestoreWindow(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.RestoreWindow = time.Hour
+	tenant, _ := s.Tenants.Tenant(DefaultTenantName)
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Status.Active = 0
+	job.Status.Succeeded = 1
+	s.handleJob(ctx, "test", job)
+
+	state := func() string {
+		services, err := s.serviceClient("test").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("list services: %v", err)
+		}
+		if len(services.Items) == 0 {
+			return "deleted"
+		}
+		return services.Items[0].Labels[RetirementLabel]
+	}
+	if got := state(); got != RetirementRetired {
+		t.Fatalf("service after cleanup is %q, want retired", got)
+	}
+
+	restored, err := s.Restore(ctx, tenant, "abc")
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if len(restored.Resources) != 1 || restored.Resources[0].Kind != "Service" {
+		t.Errorf("unexpected restored resources %+v", restored.Resources)
+	}
+	if got := state(); got != RetirementRestored {
+		t.Fatalf("service after restore is %q, want restored", got)
+	}
+
+	// Once the window passed, restored resources are retired, then deleted
+	s.RestoreWindow = 0
+	for _, want := range []string{RetirementRetired, "deleted"} {
+		if err := s.reapRetired(ctx, []string{"test"}); err != nil {
+			t.Fatalf("reapRetired: %v", err)
+		}
+		if got := state(); got != want {
+			t.Errorf("service after reaping is %q, want %s", got, want)
+		}
+	}
+
+	// Newest first
+	var actions []string
+	for _, action := range s.CleanupHistory.List("", "abc") {
+		actions = append(actions, action.Action)
+	}
+	if want := []string{CleanupDelete, CleanupRetire, CleanupRestore, CleanupRetire}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("cleanup actions = %v, want %v", actions, want)
+	}
+	if _, err := s.Restore(ctx, tenant, "abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore of deleted resources error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCleanupWorkersRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestService(t)
+	s.Tuning.CleanupWorkers = 2
+	s.Tuning.CleanupRetries = 3
+	s.StartCleanupWorkers(ctx)
+
+	// The first deletion fails
+	var deletes int32
+	s.Clientset.(*fake.Clientset).PrependReactor("delete", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.AddInt32(&deletes, 1) == 1 {
+			return true, nil, errors.New("etcdserver: request timed out")
+		}
+		return false, nil, nil
+	})
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Status.Active = 0
+	job.Status.Succeeded = 1
+	s.handleJob(ctx, "test", job)
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		services, err := s.serviceClient("test").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("list services: %v", err)
+		}
+		if len(services.Items) == 0 {
+			return
+		}
+	}
+	t.Errorf("service was not deleted after %d attempts", atomic.LoadInt32(&deletes))
+}
+
+func TestCleanupWorkersGiveUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestService(t)
+	s.Storage = NewMemoryStorage()
+	s.Tuning.CleanupWorkers = 1
+	s.Tuning.CleanupRetries = 0
+	s.StartCleanupWorkers(ctx)
+
+	var deletes int32
+	s.Clientset.(*fake.Clientset).PrependReactor("delete", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&deletes, 1)
+		return true, nil, errors.New("etcdserver: request timed out")
+	})
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	simulateFinish(job, batchv1.JobComplete)
+
+	// Once the cleanup gave up, the next update of the job cleans it up again
+	for attempt := int32(1); attempt <= 2; attempt++ {
+		s.handleJob(ctx, "test", job)
+		for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&deletes) < attempt && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := atomic.LoadInt32(&deletes); n != attempt {
+			t.Fatalf("%d deletions after update %d, want %d", n, attempt, attempt)
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if marks, _ := s.Storage.List(ctx, CollectionDedup); len(marks) == 0 {
+				break
+			}
+		}
+		if marks, _ := s.Storage.List(ctx, CollectionDedup); len(marks) != 0 {
+			t.Fatalf("cleanup marks after giving up = %d, want none", len(marks))
+		}
+	}
+}
+
+func TestCleanupPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	for name, tc := range map[string]struct {
+		opts []Option
+		want int
+	}{
+		"on success": {want: 1},
+		"on finish":  {opts: []Option{WithCleanupPolicy(CleanupOnFinish)}, want: 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(t, tc.opts...)
+
+			result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+			if err != nil {
+				t.Fatalf("Launch: %v", err)
+			}
+			job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get job: %v", err)
+			}
+			job.Status.Active = 0
+			job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+				Type:   batchv1.JobFailed,
+				Status: corev1.ConditionTrue,
+			})
+			s.handleJob(ctx, "test", job)
+
+			services, err := s.serviceClient("test").List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("list services: %v", err)
+			}
+			if len(services.Items) != tc.want {
+				t.Errorf("got %d services after failure, want %d", len(services.Items), tc.want)
+			}
+		})
+	}
+}
+
+func TestNamingStrategy(t *testing.T) {
+	s := newTestService(t, WithNamingStrategy(func(videoId string, salt int) string {
+		return DNSLabel(videoId)
+	}))
+
+	result, err := s.Launch(context.Background(), testLaunchRequest(s, "ABC"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if result.Job.Name != "recorder-abc" {
+		t.Errorf("job name = %q, want recorder-abc", result.Job.Name)
+	}
+}
+
+func TestStatusProgress(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+
+	// A retried pod's report is superseded by the newer pod's
+	for name, progress := range map[string]string{
+		"old": `{"bytesWritten": 100, "segments": 1, "updatedAt": "2023-01-01T00:00:00Z"}`,
+		"new": `{"bytesWritten": 50, "segments": 2, "updatedAt": "2023-01-01T01:00:00Z"}`,
+	} {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{"job-name": result.Job.Name},
+			Annotations: map[string]string{ProgressAnnotation: progress},
+		}}
+		if _, err := s.Clientset.CoreV1().Pods("test").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := s.Status(ctx, testLaunchRequest(s, "abc").Tenant, "abc")
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if p := status.Progress; p == nil || p.Pod != "new" || p.BytesWritten != 50 || p.Segments != 2 {
+		t.Errorf("unexpected progress %+v", p)
+	}
+}
+
+func TestTeardownPurge(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.LaunchHistory, _ = NewLaunchHistory("")
+
+	req := testLaunchRequest(s, "abc")
+	if _, err := s.Launch(ctx, req); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "def")); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+
+	result, err := s.Teardown(ctx, req.Tenant, "abc", true)
+	if err != nil {
+		t.Fatalf("Teardown: %v", err)
+	}
+	if result.Job == "" || result.Purged.LaunchRecords != 1 || result.Purged.CleanupActions != 1 {
+		t.Errorf("unexpected teardown result %+v %+v", result, result.Purged)
+	}
+
+	if _, err := s.FindJob(ctx, req.Tenant, "abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindJob after teardown error = %v, want ErrNotFound", err)
+	}
+	if records := s.LaunchHistory.Records(""); len(records) != 1 || records[0].VideoId != "def" {
+		t.Errorf("unexpected launch records after purge %+v", records)
+	}
+	if actions := s.CleanupHistory.List("", ""); len(actions) != 0 {
+		t.Errorf("unexpected cleanup actions after purge %+v", actions)
+	}
+
+	// Purging again only finds nothing to remove
+	if _, err := s.Teardown(ctx, req.Tenant, "abc", true); err != nil {
+		t.Errorf("second Teardown: %v", err)
+	}
+	if _, err := s.Teardown(ctx, req.Tenant, "abc", false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Teardown without job error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.LaunchHistory, _ = NewLaunchHistory("")
+	s.Clientset.(*fake.Clientset).PrependReactor("delete", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("unavailable")
+	})
+
+	req := testLaunchRequest(s, "abc")
+	if _, err := s.Launch(ctx, req); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if _, err := s.Teardown(ctx, req.Tenant, "abc", false); err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("Teardown error = %v, want the failed service deletion", err)
+	}
+	if records := s.LaunchHistory.Records(""); len(records) != 1 || records[0].Outcome != OutcomeCancelled || records[0].FinishedAt == nil {
+		t.Errorf("launch records = %+v, want the launch cancelled", records)
+	}
+}
+
+// testWatcher hands the cleanup watcher the events of a test.
+type testWatcher struct {
+	events *watch.FakeWatcher
+}
+
+func (w *testWatcher) ListJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.JobList, error) {
+	return &batchv1.JobList{}, nil
+}
+
+func (w *testWatcher) WatchJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return w.events, nil
+}
+
+func TestPurgeReplayedDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := &testWatcher{events: watch.NewFake()}
+	s := newTestService(t, WithWatcher(watcher))
+	s.LaunchHistory, _ = NewLaunchHistory("")
+	s.Storage = NewMemoryStorage()
+	deadLetters, err := NewDeadLetterLog(10, filepath.Join(t.TempDir(), "dead-letters.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Delivery = NewDelivery(deadLetters)
+	deadLetters.Record(DeadLetter{Destination: "artifacts", Payload: json.RawMessage(`{"tenant":"default","videoId":"abc"}`)})
+	deadLetters.Record(DeadLetter{Destination: "artifacts", Payload: json.RawMessage(`{"tenant":"default","videoId":"def"}`)})
+
+	req := testLaunchRequest(s, "abc")
+	result, err := s.Launch(ctx, req)
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	simulateFinish(job, batchv1.JobComplete)
+	go s.CleanupWatcher(ctx, "test")
+
+	purged, err := s.Teardown(ctx, req.Tenant, "abc", true)
+	if err != nil {
+		t.Fatalf("Teardown: %v", err)
+	}
+	if purged.Purged.CleanupMarks != 1 || purged.Purged.DeadLetters != 1 {
+		t.Errorf("purge result = %+v, want a cleanup mark and a dead letter", purged.Purged)
+	}
+
+	// The second event is only taken once the watcher handled the first
+	watcher.events.Delete(job)
+	watcher.events.Action(watch.Bookmark, &batchv1.Job{})
+	cancel()
+
+	if records := s.LaunchHistory.Records(""); len(records) != 0 {
+		t.Errorf("launch records after replayed delete = %+v, want none", records)
+	}
+	if marks, _ := s.Storage.List(context.Background(), CollectionDedup); len(marks) != 0 {
+		t.Errorf("cleanup marks after purge = %d, want none", len(marks))
+	}
+	if letters := deadLetters.List(); len(letters) != 1 || !strings.Contains(string(letters[0].Payload), "def") {
+		t.Errorf("dead letters after purge = %+v, want only the other video's", letters)
+	}
+}
+
+type testCredentialsProvider struct {
+	revoked []string
+}
+
+func (p *testCredentialsProvider) Issue(ctx context.Context, spec *TemplateSpec) (*Credentials, error) {
+	return &Credentials{
+		Data:    map[string]string{"PASSWORD": "secret-" + spec.VideoId},
+		LeaseID: "lease-" + spec.VideoId,
+	}, nil
+}
+
+func (p *testCredentialsProvider) Revoke(ctx context.Context, leaseID string) error {
+	p.revoked = append(p.revoked, leaseID)
+	return nil
+}
+
+func TestCredentials(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	provider := &testCredentialsProvider{}
+	s.Credentials = provider
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	secrets, err := s.Clientset.CoreV1().Secrets("test").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 1 || secrets.Items[0].StringData["PASSWORD"] != "secret-abc" {
+		t.Fatalf("unexpected credentials secrets %+v", secrets.Items)
+	}
+
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Status.Succeeded = 1
+	s.handleJob(ctx, "test", job)
+
+	if secrets, _ := s.Clientset.CoreV1().Secrets("test").List(ctx, metav1.ListOptions{}); len(secrets.Items) != 0 {
+		t.Errorf("credentials secret was not deleted")
+	}
+	if len(provider.revoked) != 1 || provider.revoked[0] != "lease-abc" {
+		t.Errorf("revoked leases = %v, want [lease-abc]", provider.revoked)
+	}
+}
+
+func TestLaunchRollback(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	provider := &testCredentialsProvider{}
+	s.Credentials = provider
+	s.Profiles[DefaultProfileName].PreHook = template.Must(template.New("pre-hook").Parse(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: recorder-config-{{ .UniqueName }}
+`))
+	s.Profiles[DefaultProfileName].NetworkPolicy = template.Must(template.New("networkpolicy").Parse(`
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: recorder-netpol-{{ .UniqueName }}
+spec:
+  podSelector: {}
+`))
+	s.Clientset.(*fake.Clientset).PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("unavailable")
+	})
+
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); err == nil {
+		t.Fatalf("Launch succeeded without a job")
+	}
+	configMaps := s.Dynamic.Resource(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}).Namespace("test")
+	if _, err := configMaps.Get(ctx, "recorder-config-"+s.naming("abc", 0), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("get pre-launch hook = %v, want it deleted", err)
+	}
+	if policies, err := s.networkPolicyClient("test").List(ctx, metav1.ListOptions{}); err != nil || len(policies.Items) != 0 {
+		t.Errorf("network policies = %v (%v), want it deleted", policies, err)
+	}
+	if secrets, err := s.Clientset.CoreV1().Secrets("test").List(ctx, metav1.ListOptions{}); err != nil || len(secrets.Items) != 0 {
+		t.Errorf("credentials = %v (%v), want them deleted", secrets, err)
+	}
+	if len(provider.revoked) != 1 || provider.revoked[0] != "lease-abc" {
+		t.Errorf("revoked leases = %v, want [lease-abc]", provider.revoked)
+	}
+}
+
+func TestCheckResourceQuotas(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.CheckResourceQuotas = true
+
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "pods"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("2")},
+			Used: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")},
+		},
+	}
+	if _, err := s.Clientset.CoreV1().ResourceQuotas("test").Create(ctx, quota, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); err != nil {
+		t.Fatalf("Launch within quota: %v", err)
+	}
+
+	quota.Status.Used[corev1.ResourcePods] = resource.MustParse("2")
+	if _, err := s.Clientset.CoreV1().ResourceQuotas("test").Update(ctx, quota, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var shortfallErr *QuotaShortfallError
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "def")); !errors.As(err, &shortfallErr) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Launch over quota error = %v, want QuotaShortfallError", err)
+	}
+	if s := shortfallErr.Shortfalls; len(s) != 1 || s[0].Resource != "pods" || s[0].Available != "0" {
+		t.Errorf("unexpected shortfalls %+v", s)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestService(t)
+	s.Capacity = &CapacityMonitor{Threshold: time.Minute, Interval: time.Hour}
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "pending",
+			Labels: map[string]string{jobNameLabel: result.Job.Name},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionFalse,
+				Reason:             corev1.PodReasonUnschedulable,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+			}},
+		},
+	}
+	if _, err := s.Clientset.CoreV1().Pods("test").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.StartCapacityMonitor(ctx, []string{"test"}); err != nil {
+		t.Fatalf("StartCapacityMonitor: %v", err)
+	}
+	s.checkCapacity(ctx)
+
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "def")); !errors.Is(err, ErrAtCapacity) {
+		t.Errorf("Launch at capacity error = %v, want ErrAtCapacity", err)
+	}
+}
+
+func TestPause(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.Queue = NewLaunchQueue()
+
+	s.Pause("cluster upgrade", "admin")
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); !errors.Is(err, ErrPaused) {
+		t.Errorf("Launch while paused error = %v, want ErrPaused", err)
+	}
+	req := testLaunchRequest(s, "def")
+	req.Queue = true
+	result, err := s.Launch(ctx, req)
+	if err != nil || result.Pending == nil {
+		t.Fatalf("queued Launch while paused = %+v, %v, want a pending launch", result, err)
+	}
+	s.processQueue(ctx)
+	if pending, _ := s.Queue.List(""); len(pending) != 1 {
+		t.Errorf("pending launches while paused = %d, want 1", len(pending))
+	}
+
+	s.Resume("admin")
+	s.processQueue(ctx)
+	if pending, _ := s.Queue.List(""); len(pending) != 0 {
+		t.Errorf("pending launches after resume = %d, want 0", len(pending))
+	}
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); err != nil {
+		t.Errorf("Launch after resume: %v", err)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	for name, phase := range map[string]corev1.PodPhase{"running": corev1.PodRunning, "failed": corev1.PodFailed} {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{jobNameLabel: result.Job.Name},
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+		if _, err := s.Clientset.CoreV1().Pods("test").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	finished, err := s.Finish(ctx, s.Tenants.tenants[DefaultTenantName], "abc", 30*time.Second)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if !reflect.DeepEqual(finished.Signalled, []string{"running"}) {
+		t.Errorf("signalled pods = %v, want only the running one", finished.Signalled)
+	}
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Annotations[FinishRequestedAnnotation] == "" {
+		t.Errorf("job annotations = %v, want the finish request", job.Annotations)
+	}
+	if _, err := s.Clientset.CoreV1().Pods("test").Get(ctx, "failed", metav1.GetOptions{}); err != nil {
+		t.Errorf("finished pod was deleted: %v", err)
+	}
+}
+
+func TestPostHookRunning(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.Profiles[DefaultProfileName].PostHook = template.Must(template.New("post-hook").Parse(strings.Replace(testJobTemplate, "recorder-", "post-hook-", 1)))
+	tenant := s.Tenants.tenants[DefaultTenantName]
+	tenant.MaxConcurrent = 1
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Succeeded = 1
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if job, err = s.jobClient("test").UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s.handleJob(ctx, "test", job)
+	hooks, err := s.jobClient("test").List(ctx, metav1.ListOptions{LabelSelector: HookLabel})
+	if err != nil || len(hooks.Items) != 1 {
+		t.Fatalf("hook jobs = %v, %v, want the post-launch hook", hooks, err)
+	}
+
+	// The running hook is neither the video's job nor a concurrent launch
+	if found, err := s.FindJob(ctx, tenant, "abc"); err != nil || found.Name != job.Name {
+		t.Errorf("FindJob = %v, %v, want job %s", found, err, job.Name)
+	}
+	statuses, err := s.Statuses(ctx, tenant, []string{"abc"})
+	if err != nil || statuses["abc"] == nil || statuses["abc"].Name != job.Name {
+		t.Errorf("Statuses = %v, %v, want job %s", statuses, err, job.Name)
+	}
+	if _, err := s.Finish(ctx, tenant, "abc", 0); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Finish = %v, want ErrJobFinished", err)
+	}
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "def")); err != nil {
+		t.Errorf("Launch with a running hook: %v", err)
+	}
+
+	// The hook job is deleted once it has succeeded
+	hook := &hooks.Items[0]
+	hook.Status.Succeeded = 1
+	s.handleJob(ctx, "test", hook)
+	if _, err := s.jobClient("test").Get(ctx, hook.Name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("get hook job = %v, want not found", err)
+	}
+	actions := s.CleanupHistory.List("", "abc")
+	if len(actions) == 0 || actions[0].Kind != "Job" || actions[0].Name != hook.Name {
+		t.Errorf("cleanup history = %+v, want the hook job deletion first", actions)
+	}
+}
+
+func TestScheduledLaunchCancellation(t *testing.T) {
+	for name, newQueue := range map[string]func(s *LauncherService) (LaunchQueue, error){
+		"memory": func(s *LauncherService) (LaunchQueue, error) {
+			return NewLaunchQueue(), nil
+		},
+		"configmap": func(s *LauncherService) (LaunchQueue, error) {
+			q := NewConfigMapQueue(s.Clientset, "test")
+			return q, q.Start(context.Background())
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestService(t)
+			queue, err := newQueue(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Queue = queue
+
+			req := testLaunchRequest(s, "abc")
+			req.At = time.Now().Add(time.Hour)
+			result, err := s.Launch(ctx, req)
+			if err != nil {
+				t.Fatalf("Launch: %v", err)
+			}
+			if result.Pending == nil || result.Pending.Reason != PendingScheduled || result.Job != nil {
+				t.Fatalf("expected a scheduled launch, got %+v", result)
+			}
+
+			teardown, err := s.Teardown(ctx, req.Tenant, "abc", false)
+			if err != nil {
+				t.Fatalf("Teardown: %v", err)
+			}
+			if teardown.Cancelled != CancelledPreCreation || teardown.Job != "" {
+				t.Errorf("expected pre-creation cancellation, got %+v", teardown)
+			}
+			if p, _ := s.Queue.Remove(ctx, req.Tenant.Name, "abc"); p != nil {
+				t.Errorf("expected the cancelled launch to be gone, got %+v", p)
+			}
+
+			// Due launches are started by the queue
+			if err := s.Queue.Add(ctx, &PendingLaunch{Tenant: req.Tenant.Name, VideoId: "def", Reason: PendingQueued}); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			// Wait for the informer to see the ConfigMap
+			for i := 0; i < 100; i++ {
+				if pending, _ := s.Queue.List(""); len(pending) == 1 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			s.processQueue(ctx)
+			teardown, err = s.Teardown(ctx, req.Tenant, "def", false)
+			if err != nil {
+				t.Fatalf("Teardown: %v", err)
+			}
+			if teardown.Cancelled != CancelledPostCreation || teardown.Job == "" {
+				t.Errorf("expected post-creation cancellation, got %+v", teardown)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	s := newTestService(t, WithCleanupPolicy(CleanupOnFinish))
+
+	config := s.Config()
+	if config.CleanupPolicy != "CleanupOnFinish" {
+		t.Errorf("expected cleanup policy CleanupOnFinish, got %q", config.CleanupPolicy)
+	}
+	if len(config.Profiles) != 1 || len(config.Profiles[0].Templates) != 2 {
+		t.Errorf("expected the default profile with 2 templates, got %+v", config.Profiles)
+	}
+	if !config.Features.Fake {
+		t.Errorf("expected the fake cluster feature to be set")
+	}
+}
+
+func TestRequiredResources(t *testing.T) {
+	s := newTestService(t)
+	s.CheckResourceQuotas = true
+
+	required := s.requiredResources()
+	for gr, verb := range map[schema.GroupResource]string{
+		{Group: "batch", Resource: "jobs"}: "watch",
+		{Resource: "services"}:             "create",
+		{Resource: "resourcequotas"}:       "list",
+	} {
+		if !required[gr][verb] {
+			t.Errorf("expected %s to require %s", gr, verb)
+		}
+	}
+	if required[schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}]["create"] {
+		t.Errorf("expected ingresses not to require create without an ingress template")
+	}
+}
+
+func TestBuiltinProfiles(t *testing.T) {
+	s := newTestService(t)
+	for _, name := range BuiltinProfiles() {
+		p, err := LoadBuiltinProfile(name, name)
+		if err != nil {
+			t.Fatalf("LoadBuiltinProfile(%s): %v", name, err)
+		}
+		s.Profiles[name] = p
+		if _, err := s.render(&TemplateSpec{VideoId: "abc", Tenant: DefaultTenantName, Profile: name, Namespace: "test"}); err != nil {
+			t.Errorf("rendering builtin profile %s: %v", name, err)
+		}
+	}
+	if _, err := LoadBuiltinProfile("missing", "missing"); err == nil {
+		t.Errorf("expected an error for an unknown builtin profile")
+	}
+}
+
+func TestListETag(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	tenant := s.Tenants.tenants[DefaultTenantName]
+
+	before, err := s.ListETag(ctx, tenant)
+	if err != nil {
+		t.Fatalf("ListETag: %v", err)
+	}
+	if again, _ := s.ListETag(ctx, tenant); again != before {
+		t.Errorf("expected a stable ETag, got %s and %s", before, again)
+	}
+
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if after, _ := s.ListETag(ctx, tenant); after == before {
+		t.Errorf("expected the ETag to change after a launch")
+	}
+}
+
+func TestProfileRollout(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	v1 := s.Profiles[DefaultProfileName].Version
+
+	s.ProfileSource = func() (map[string]*Profile, error) {
+		return map[string]*Profile{
+			DefaultProfileName: {
+				Name: DefaultProfileName,
+				Job:  template.Must(template.New("job").Parse(testJobTemplate + "  backoffLimit: 1\n")),
+			},
+		}, nil
+	}
+	profiles, err := s.ReloadProfiles()
+	if err != nil {
+		t.Fatalf("ReloadProfiles: %v", err)
+	}
+	if len(profiles) != 1 || len(profiles[0].Versions) != 2 || profiles[0].Active != v1 {
+		t.Fatalf("expected a second, inactive version, got %+v", profiles)
+	}
+	var v2 string
+	for _, version := range profiles[0].Versions {
+		if version != v1 {
+			v2 = version
+		}
+	}
+
+	if _, err := s.RolloutProfile(DefaultProfileName, v2, DefaultTenantName); err != nil {
+		t.Fatalf("RolloutProfile: %v", err)
+	}
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	if got := job.Annotations[ProfileVersionAnnotation]; got != v2 {
+		t.Errorf("job profile version = %q, want %q", got, v2)
+	}
+	if rollouts := s.Rollouts(); len(rollouts) != 1 || rollouts[0].From != v1 {
+		t.Errorf("unexpected rollouts %+v", rollouts)
+	}
+}
+
+func TestProfileVersionsStorage(t *testing.T) {
+	defer func(max int) { MaxProfileVersions = max }(MaxProfileVersions)
+	MaxProfileVersions = 2
+	store := NewMemoryStorage()
+	s := newTestService(t)
+	s.Storage = store
+	if err := s.RestoreProfiles(context.Background()); err != nil {
+		t.Fatalf("RestoreProfiles: %v", err)
+	}
+	v1 := s.Profiles[DefaultProfileName].Version
+
+	backoffLimit := 1
+	s.ProfileSource = func() (map[string]*Profile, error) {
+		backoffLimit++
+		return map[string]*Profile{
+			DefaultProfileName: {
+				Name: DefaultProfileName,
+				Job:  template.Must(template.New("job").Parse(testJobTemplate + fmt.Sprintf("  backoffLimit: %d\n", backoffLimit))),
+			},
+		}, nil
+	}
+	if _, err := s.ReloadProfiles(); err != nil {
+		t.Fatalf("ReloadProfiles: %v", err)
+	}
+	v2 := s.versionOrder[DefaultProfileName][1]
+	if _, err := s.RolloutProfile(DefaultProfileName, v2, DefaultTenantName); err != nil {
+		t.Fatalf("RolloutProfile: %v", err)
+	}
+
+	// After a restart, the rolled out version stays active
+	restarted := newTestService(t)
+	restarted.Storage = store
+	if err := restarted.RestoreProfiles(context.Background()); err != nil {
+		t.Fatalf("RestoreProfiles: %v", err)
+	}
+	if active := restarted.Profiles[DefaultProfileName]; active.Version != v2 || active.Job == nil {
+		t.Errorf("active version after restart = %s, want %s", active.Version, v2)
+	}
+	if _, err := restarted.profileVersion(DefaultProfileName, v1); err != nil {
+		t.Errorf("profileVersion %s after restart: %v", v1, err)
+	}
+	if _, err := restarted.profileVersion(DefaultProfileName, "0123456789ab"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("profileVersion of an unknown version error = %v, want ErrNotFound", err)
+	}
+
+	// A third version drops the oldest, inactive one
+	if _, err := s.ReloadProfiles(); err != nil {
+		t.Fatalf("ReloadProfiles: %v", err)
+	}
+	if _, ok := s.versions[DefaultProfileName][v1]; ok || len(s.versions[DefaultProfileName]) != 2 {
+		t.Errorf("versions = %v, want 2 without %s", s.versionOrder[DefaultProfileName], v1)
+	}
+	if found, _ := store.Get(context.Background(), CollectionProfiles, profileVersionKeyPrefix+DefaultProfileName+"/"+v1, &storedProfile{}); found {
+		t.Errorf("version %s is still stored", v1)
+	}
+}
+
+type testArtifactRegistrar struct {
+	registrations []*ArtifactRegistration
+}
+
+func (r *testArtifactRegistrar) Register(ctx context.Context, reg *ArtifactRegistration) error {
+	r.registrations = append(r.registrations, reg)
+	return nil
+}
+
+func TestArtifacts(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	registrar := &testArtifactRegistrar{}
+	s.Artifacts = registrar
+	s.Profiles[DefaultProfileName].Artifacts = template.Must(template.New("artifacts").Parse(`
+- name: recording
+  path: {{ .Channel }}/{{ .VideoId }}.mkv
+`))
+
+	req := testLaunchRequest(s, "abc")
+	req.Channel = "chan"
+	result, err := s.Launch(ctx, req)
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Status.Succeeded = 1
+	s.handleJob(ctx, "test", job)
+
+	// The annotated job is not registered again
+	job, err = s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	job.Status.Succeeded = 1
+	s.handleJob(ctx, "test", job)
+
+	if len(registrar.registrations) != 1 {
+		t.Fatalf("got %d registrations, want 1", len(registrar.registrations))
+	}
+	reg := registrar.registrations[0]
+	if reg.Channel != "chan" || len(reg.Artifacts) != 1 || reg.Artifacts[0].Path != "chan/abc.mkv" {
+		t.Errorf("unexpected registration %+v", reg)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.Heartbeats = &HeartbeatMonitor{Window: time.Minute}
+	tenant := s.Tenants.tenants[DefaultTenantName]
+
+	result, err := s.Launch(ctx, testLaunchRequest(s, "abc"))
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+
+	// Jobs without heartbeats are stale a window after their start
+	job, err := s.jobClient("test").Get(ctx, result.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+	started := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	job.Status.StartTime = &started
+	if _, err := s.jobClient("test").Update(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+	s.checkHeartbeats(ctx, []string{"test"})
+
+	status, err := s.Status(ctx, tenant, "abc")
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !status.Job.Stale {
+		t.Errorf("job without heartbeats is not stale")
+	}
+
+	heartbeat, err := s.Heartbeat(ctx, tenant, "abc")
+	if err != nil {
+		t.Fatalf("Heartbeat: %v", err)
+	}
+	if heartbeat.Job.Stale || heartbeat.Job.LastHeartbeat == nil {
+		t.Errorf("heartbeat did not revive the job: %+v", heartbeat.Job)
+	}
+	s.checkHeartbeats(ctx, []string{"test"})
+	if status, _ := s.Status(ctx, tenant, "abc"); status.Job.Stale {
+		t.Errorf("job is stale right after a heartbeat")
+	}
+}
+
+func TestTenantScope(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	tenant := s.Tenants.tenants[DefaultTenantName]
+	other := &Tenant{Name: "other"}
+
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if _, err := s.Launch(ctx, &LaunchRequest{Tenant: other, VideoId: "def"}); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		tenant *Tenant
+		want   int
+	}{
+		"tenant": {tenant: tenant, want: 1},
+		"other":  {tenant: other, want: 1},
+		"all":    {tenant: nil, want: 2},
+	} {
+		jobs, err := s.List(ctx, tc.tenant)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(jobs) != tc.want {
+			t.Errorf("%s scope lists %d jobs, want %d", name, len(jobs), tc.want)
+		}
+	}
+
+	if _, err := s.Status(ctx, tenant, "def"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Status of another tenant's video = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Status(ctx, nil, "def"); err != nil {
+		t.Errorf("Status in all scope: %v", err)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	tenant := s.Tenants.tenants[DefaultTenantName]
+
+	if _, err := s.Launch(ctx, testLaunchRequest(s, "abc")); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	if _, err := s.Simulate(ctx, tenant, "abc", "explode"); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Simulate error = %v, want ErrInvalidRequest", err)
+	}
+
+	result, err := s.Simulate(ctx, tenant, "abc", SimulateSucceeded)
+	if err != nil {
+		t.Fatalf("Simulate: %v", err)
+	}
+	if result.Job.Status != "succeeded" {
+		t.Errorf("simulated job status = %q, want succeeded", result.Job.Status)
+	}
+	if _, err := s.Simulate(ctx, tenant, "abc", SimulateFailed); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Simulate of a finished job = %v, want ErrJobFinished", err)
+	}
+}
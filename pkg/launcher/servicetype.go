@@ -0,0 +1,64 @@
+package launcher
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ServiceTypes are the service types a profile can switch to.
+var ServiceTypes = []corev1.ServiceType{
+	corev1.ServiceTypeClusterIP,
+	corev1.ServiceTypeNodePort,
+	corev1.ServiceTypeLoadBalancer,
+}
+
+func ValidateServiceType(t corev1.ServiceType) error {
+	if t == "" {
+		return nil
+	}
+	for _, valid := range ServiceTypes {
+		if t == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid service type %q, must be ClusterIP, NodePort or LoadBalancer", t)
+}
+
+// RewriteService applies a profile's service settings, e.g. so the same
+// template serves NodePort in development and ClusterIP in production.
+func RewriteService(svc *corev1.Service, settings ProfileSettings) {
+	if settings.ServiceType != "" && settings.ServiceType != svc.Spec.Type {
+		svc.Spec.Type = settings.ServiceType
+
+		// Drop the fields the API server rejects for the new type
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			svc.Spec.LoadBalancerIP = ""
+			svc.Spec.LoadBalancerSourceRanges = nil
+			svc.Spec.LoadBalancerClass = nil
+			svc.Spec.AllocateLoadBalancerNodePorts = nil
+			svc.Spec.HealthCheckNodePort = 0
+		}
+		if svc.Spec.Type == corev1.ServiceTypeClusterIP {
+			svc.Spec.ExternalTrafficPolicy = ""
+			for i := range svc.Spec.Ports {
+				svc.Spec.Ports[i].NodePort = 0
+			}
+		}
+	}
+
+	if settings.NameServicePorts {
+		for i, port := range svc.Spec.Ports {
+			if port.Name != "" {
+				continue
+			}
+			protocol := string(port.Protocol)
+			if port.AppProtocol != nil {
+				protocol = *port.AppProtocol
+			} else if protocol == "" {
+				protocol = string(corev1.ProtocolTCP)
+			}
+			svc.Spec.Ports[i].Name = DNSLabel(fmt.Sprintf("%s-%d", protocol, port.Port))
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package launcher
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRewriteService(t *testing.T) {
+	http := "HTTP"
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{
+		Type:                  corev1.ServiceTypeNodePort,
+		ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+		Ports: []corev1.ServicePort{
+			{Port: 80, NodePort: 30080},
+			{Port: 8080, AppProtocol: &http},
+			{Name: "metrics", Port: 9090},
+		},
+	}}
+
+	RewriteService(svc, ProfileSettings{
+		ServiceType:      corev1.ServiceTypeClusterIP,
+		NameServicePorts: true,
+	})
+
+	if svc.Spec.Type != corev1.ServiceTypeClusterIP || svc.Spec.ExternalTrafficPolicy != "" {
+		t.Errorf("service was not switched to ClusterIP: %+v", svc.Spec)
+	}
+	for i, want := range []string{"tcp-80", "http-8080", "metrics"} {
+		if got := svc.Spec.Ports[i].Name; got != want {
+			t.Errorf("port %d name = %q, want %q", i, got, want)
+		}
+		if svc.Spec.Ports[i].NodePort != 0 {
+			t.Errorf("port %d kept node port %d", i, svc.Spec.Ports[i].NodePort)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package launcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Values of the RetirementLabel of resources whose job has completed.
+const (
+	RetirementRetired  = "retired"
+	RetirementRestored = "restored"
+)
+
+type RestoredResource struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+type RestoreResult struct {
+	VideoId   string             `json:"videoId"`
+	Resources []RestoredResource `json:"resources"`
+	// Until is when the resources are retired again
+	Until time.Time `json:"until"`
+}
+
+// retirementPatch labels a resource with its retirement state and records
+// since when it is in that state.
+func retirementPatch(state string, now time.Time) ([]byte, error) {
+	annotations := map[string]any{
+		RetiredAnnotation:  nil,
+		RestoredAnnotation: nil,
+	}
+	if state == RetirementRetired {
+		annotations[RetiredAnnotation] = now.Format(time.RFC3339)
+	} else {
+		annotations[RestoredAnnotation] = now.Format(time.RFC3339)
+	}
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels":      map[string]string{RetirementLabel: state},
+			"annotations": annotations,
+		},
+	})
+}
+
+func (s *LauncherService) retire(ctx context.Context, target cleanupTarget, namespace string, name string) error {
+	patch, err := retirementPatch(RetirementRetired, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return target.Patch(ctx, namespace, name, patch)
+}
+
+// retiredSince returns when a retired or restored resource entered its state,
+// resources missing the annotation count as expired.
+func retiredSince(obj metav1.Object) time.Time {
+	annotation := RetiredAnnotation
+	if obj.GetLabels()[RetirementLabel] == RetirementRestored {
+		annotation = RestoredAnnotation
+	}
+	t, _ := time.Parse(time.RFC3339, obj.GetAnnotations()[annotation])
+	return t
+}
+
+// objectCleanupAction describes an action on a resource whose job may be
+// gone already.
+func objectCleanupAction(obj metav1.Object, namespace string, action string, kind string) CleanupAction {
+	return CleanupAction{
+		Namespace: namespace,
+		Tenant:    obj.GetLabels()[TenantLabel],
+		VideoId:   obj.GetLabels()[VideoIdLabel],
+		Action:    action,
+		Kind:      kind,
+		Name:      obj.GetName(),
+	}
+}
+
+// Restore brings back the retired resources of a video, for post-processing
+// that needs its networking. They are retired again after the restore window,
+// restoring them again extends it.
+func (s *LauncherService) Restore(ctx context.Context, tenant *Tenant, videoId string) (*RestoreResult, error) {
+	namespace := s.NamespaceFor(tenant)
+	selector := ManagedLabelSelector() + fmt.Sprintf(",%s=%s,%s=%s,%s", VideoIdLabel, videoId, TenantLabel, tenant.Name, RetirementLabel)
+	now := time.Now().UTC().Truncate(time.Second)
+	patch, err := retirementPatch(RetirementRestored, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{
+		VideoId:   videoId,
+		Resources: []RestoredResource{},
+		Until:     now.Add(s.RestoreWindow),
+	}
+	for _, target := range s.cleanupTargets() {
+		objects, err := target.List(ctx, namespace, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %w", target.Kind, err)
+		}
+		for _, obj := range objects {
+			err := target.Patch(ctx, namespace, obj.GetName(), patch)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			s.recordCleanup(objectCleanupAction(obj, namespace, CleanupRestore, target.Kind), err)
+			if err != nil {
+				return nil, fmt.Errorf("error restoring %s %s: %w", target.Kind, obj.GetName(), err)
+			}
+			result.Resources = append(result.Resources, RestoredResource{Kind: target.Kind, Name: obj.GetName()})
+		}
+	}
+	if len(result.Resources) == 0 {
+		return nil, fmt.Errorf("%w: no retired resources of video %s", ErrNotFound, videoId)
+	}
+
+	log.Printf("restored %d resources of video %s of tenant %s until %s", len(result.Resources), videoId, tenant.Name, result.Until.Format(time.RFC3339))
+	return result, nil
+}
+
+// StartRetirementReaper deletes retired resources in every namespace, and
+// retires restored ones again, once they have been in that state for the
+// restore window. It checks every interval.
+func (s *LauncherService) StartRetirementReaper(ctx context.Context, namespaces []string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.reapRetired(ctx, namespaces); err != nil {
+					log.Printf("error reaping retired resources: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *LauncherService) reapRetired(ctx context.Context, namespaces []string) error {
+	selector := ManagedLabelSelector() + "," + RetirementLabel
+	now := time.Now()
+	counts := map[string]int{RetirementRetired: 0, RetirementRestored: 0}
+
+	var errs []error
+	for _, ns := range namespaces {
+		for _, target := range s.cleanupTargets() {
+			objects, err := target.List(ctx, ns, metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error listing %s: %w", target.Kind, err))
+				continue
+			}
+			for _, obj := range objects {
+				state := obj.GetLabels()[RetirementLabel]
+				if now.Sub(retiredSince(obj)) < s.RestoreWindow {
+					counts[state]++
+					continue
+				}
+
+				action := CleanupDelete
+				if state == RetirementRestored {
+					action = CleanupRetire
+					err = s.retire(ctx, target, ns, obj.GetName())
+					counts[RetirementRetired]++
+				} else {
+					err = target.Delete(ctx, ns, obj.GetName())
+				}
+				if apierrors.IsNotFound(err) {
+					continue
+				} else if err != nil {
+					errs = append(errs, fmt.Errorf("error reaping %s %s: %w", target.Kind, obj.GetName(), err))
+				}
+				s.recordCleanup(objectCleanupAction(obj, ns, action, target.Kind), err)
+			}
+		}
+	}
+
+	for state, count := range counts {
+		retiredResources.WithLabelValues(state).Set(float64(count))
+	}
+	return errors.Join(errs...)
+}
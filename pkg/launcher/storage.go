@@ -0,0 +1,433 @@
+package launcher
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// Collections of the launcher's persisted state.
+const (
+	CollectionLaunches  = "launches"
+	CollectionCleanups  = "cleanups"
+	CollectionSchedules = "schedules"
+	CollectionDedup     = "dedup"
+	CollectionAudit     = "audit"
+	CollectionProfiles  = "profiles"
+)
+
+// Storage persists the launcher's state as collections of JSON documents,
+// keyed by strings that also order them. Launch and cleanup history,
+// scheduled and queued launches, cleanup deduplication, profile versions and
+// the rollout audit log can all be kept in one.
+type Storage interface {
+	// Put stores the JSON encoding of value, replacing the document at key.
+	Put(ctx context.Context, collection string, key string, value any) error
+	// Create stores the JSON encoding of value unless there is a document at
+	// key already, it returns false if there was. Replicas sharing a storage
+	// use it to claim work.
+	Create(ctx context.Context, collection string, key string, value any) (bool, error)
+	// Get decodes the document at key into value, it returns false if there
+	// is none.
+	Get(ctx context.Context, collection string, key string, value any) (bool, error)
+	// Delete removes the document at key, if any.
+	Delete(ctx context.Context, collection string, key string) error
+	// List returns the documents of a collection in key order.
+	List(ctx context.Context, collection string) ([]StorageEntry, error)
+	Close() error
+}
+
+type StorageEntry struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// memoryStorage keeps documents in memory, they are lost on restart.
+type memoryStorage struct {
+	mu          sync.Mutex
+	collections map[string]map[string]json.RawMessage
+}
+
+func NewMemoryStorage() Storage {
+	return &memoryStorage{
+		collections: map[string]map[string]json.RawMessage{},
+	}
+}
+
+func (m *memoryStorage) Put(ctx context.Context, collection string, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(collection, key, data)
+	return nil
+}
+
+func (m *memoryStorage) Create(ctx context.Context, collection string, key string, value any) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.collections[collection][key]; ok {
+		return false, nil
+	}
+	m.put(collection, key, data)
+	return true, nil
+}
+
+func (m *memoryStorage) put(collection string, key string, data json.RawMessage) {
+	if m.collections[collection] == nil {
+		m.collections[collection] = map[string]json.RawMessage{}
+	}
+	m.collections[collection][key] = data
+}
+
+func (m *memoryStorage) Get(ctx context.Context, collection string, key string, value any) (bool, error) {
+	m.mu.Lock()
+	data, ok := m.collections[collection][key]
+	m.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+func (m *memoryStorage) Delete(ctx context.Context, collection string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.collections[collection], key)
+	return nil
+}
+
+func (m *memoryStorage) List(ctx context.Context, collection string) ([]StorageEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]StorageEntry, 0, len(m.collections[collection]))
+	for key, data := range m.collections[collection] {
+		entries = append(entries, StorageEntry{Key: key, Value: data})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
+}
+
+// BoltStorage keeps documents in a BoltDB file, a bucket per collection, so a
+// single replica keeps its state without running a database.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	// Another process holding the file blocks opening it, fail instead
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening storage %v: %w", path, err)
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+func (s *BoltStorage) Put(ctx context.Context, collection string, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+}
+
+func (s *BoltStorage) Create(ctx context.Context, collection string, key string, value any) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	created := false
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(key)) != nil {
+			return nil
+		}
+		created = true
+		return bucket.Put([]byte(key), data)
+	})
+	return created && err == nil, err
+}
+
+func (s *BoltStorage) Get(ctx context.Context, collection string, key string, value any) (bool, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(collection)); bucket != nil {
+			// Values are only valid during the transaction
+			if v := bucket.Get([]byte(key)); v != nil {
+				data = append([]byte{}, v...)
+			}
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+func (s *BoltStorage) Delete(ctx context.Context, collection string, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(collection)); bucket != nil {
+			return bucket.Delete([]byte(key))
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) List(ctx context.Context, collection string) ([]StorageEntry, error) {
+	entries := []StorageEntry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(collection))
+		if bucket == nil {
+			return nil
+		}
+		// Bolt iterates in byte order of the keys
+		return bucket.ForEach(func(k, v []byte) error {
+			entries = append(entries, StorageEntry{Key: string(k), Value: append(json.RawMessage{}, v...)})
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// SQLStorage keeps documents in a PostgreSQL table, for durable state that
+// replicas share and that can be queried directly. Embedders open the
+// database with the driver of their choice.
+type SQLStorage struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLStorage creates the table if it doesn't exist yet.
+func NewSQLStorage(ctx context.Context, db *sql.DB, table string) (*SQLStorage, error) {
+	s := &SQLStorage{db: db, table: table}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	collection TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value JSONB NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (collection, key)
+)`, table))
+	if err != nil {
+		return nil, fmt.Errorf("error creating storage table %s: %w", table, err)
+	}
+	return s, nil
+}
+
+func (s *SQLStorage) Put(ctx context.Context, collection string, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (collection, key, value) VALUES ($1, $2, $3)
+ON CONFLICT (collection, key) DO UPDATE SET value = EXCLUDED.value, updated_at = now()`, s.table), collection, key, string(data))
+	return err
+}
+
+func (s *SQLStorage) Create(ctx context.Context, collection string, key string, value any) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (collection, key, value) VALUES ($1, $2, $3)
+ON CONFLICT (collection, key) DO NOTHING`, s.table), collection, key, string(data))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLStorage) Get(ctx context.Context, collection string, key string, value any) (bool, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT value FROM %s WHERE collection = $1 AND key = $2`, s.table), collection, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+func (s *SQLStorage) Delete(ctx context.Context, collection string, key string) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE collection = $1 AND key = $2`, s.table), collection, key)
+	return err
+}
+
+func (s *SQLStorage) List(ctx context.Context, collection string) ([]StorageEntry, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT key, value FROM %s WHERE collection = $1 ORDER BY key`, s.table), collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []StorageEntry{}
+	for rows.Next() {
+		var entry StorageEntry
+		var data []byte
+		if err := rows.Scan(&entry.Key, &data); err != nil {
+			return nil, err
+		}
+		entry.Value = data
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLStorage) Close() error {
+	return s.db.Close()
+}
+
+// DedupRetention is how long stored cleanup marks are kept, jobs are
+// expected to be gone by then.
+var DedupRetention = 7 * 24 * time.Hour
+
+const unmarkTimeout = 10 * time.Second
+
+type dedupMark struct {
+	Job     string    `json:"job"`
+	Tenant  string    `json:"tenant,omitempty"`
+	VideoId string    `json:"videoId,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// markCleanedUp marks a job cleaned up and tells whether it was already. The
+// stored mark is created only if there is none, so of replicas sharing a
+// storage only one cleans up the job.
+func (s *LauncherService) markCleanedUp(ctx context.Context, job *batchv1.Job) bool {
+	if _, done := s.cleanedUp.LoadOrStore(job.UID, true); done {
+		return true
+	}
+	if s.Storage == nil {
+		return false
+	}
+
+	mark := &dedupMark{
+		Job:     job.Namespace + "/" + job.Name,
+		Tenant:  job.Labels[TenantLabel],
+		VideoId: job.Labels[VideoIdLabel],
+		Time:    time.Now().UTC(),
+	}
+	created, err := s.Storage.Create(ctx, CollectionDedup, dedupKey(job), mark)
+	if err != nil {
+		log.Printf("error storing cleanup mark of job %s: %v", job.Name, err)
+		return false
+	}
+	return !created
+}
+
+// unmarkCleanedUp clears the marks of a job whose cleanup failed, so that it
+// is cleaned up again on its next update. Cleanups also give up on shutdown,
+// so the stored mark is deleted without the launcher's context.
+func (s *LauncherService) unmarkCleanedUp(job *batchv1.Job) {
+	s.cleanedUp.Delete(job.UID)
+	if s.Storage == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), unmarkTimeout)
+	defer cancel()
+	if err := s.Storage.Delete(ctx, CollectionDedup, dedupKey(job)); err != nil {
+		log.Printf("error deleting cleanup mark of job %s: %v", job.Name, err)
+	}
+}
+
+func dedupKey(job *batchv1.Job) string {
+	return "cleanup/" + string(job.UID)
+}
+
+// forgetDedup deletes the stored cleanup marks of a tenant's video, and of
+// the given jobs, whose marks may predate the video being recorded. The
+// marks kept in memory stay, so this replica still won't clean up a job it
+// tore down.
+func (s *LauncherService) forgetDedup(ctx context.Context, tenant string, videoId string, jobs ...string) (int, error) {
+	if s.Storage == nil {
+		return 0, nil
+	}
+	entries, err := s.Storage.List(ctx, CollectionDedup)
+	if err != nil {
+		return 0, err
+	}
+	purgedJobs := map[string]bool{}
+	for _, job := range jobs {
+		purgedJobs[job] = true
+	}
+	removed := 0
+	for _, entry := range entries {
+		var mark dedupMark
+		if err := json.Unmarshal(entry.Value, &mark); err != nil {
+			continue
+		}
+		if !(mark.Tenant == tenant && mark.VideoId == videoId) && !purgedJobs[mark.Job] {
+			continue
+		}
+		if err := s.Storage.Delete(ctx, CollectionDedup, entry.Key); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// PruneDedup deletes the stored cleanup marks older than DedupRetention.
+func (s *LauncherService) PruneDedup(ctx context.Context) error {
+	entries, err := s.Storage.List(ctx, CollectionDedup)
+	if err != nil {
+		return err
+	}
+	pruned := 0
+	for _, entry := range entries {
+		var mark dedupMark
+		if err := json.Unmarshal(entry.Value, &mark); err == nil && time.Since(mark.Time) < DedupRetention {
+			continue
+		}
+		if err := s.Storage.Delete(ctx, CollectionDedup, entry.Key); err != nil {
+			return err
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		log.Printf("pruned %d cleanup marks", pruned)
+	}
+	return nil
+}
+
+var orderedKeySeq atomic.Uint32
+
+// orderedKey returns a unique key that sorts in the order of t.
+func orderedKey(t time.Time) string {
+	return fmt.Sprintf("%020d-%08d", t.UnixNano(), orderedKeySeq.Add(1)%100000000)
+}
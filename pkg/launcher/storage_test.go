@@ -0,0 +1,211 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBoltStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "launcher.db")
+	store, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatalf("NewBoltStorage: %v", err)
+	}
+
+	launches, err := NewStorageLaunchHistory(ctx, store)
+	if err != nil {
+		t.Fatalf("NewStorageLaunchHistory: %v", err)
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	launches.Add(&LaunchRecord{Namespace: "test", Job: "a", VideoId: "abc", Tenant: "default", StartedAt: start, Outcome: OutcomeRunning})
+	launches.Add(&LaunchRecord{Namespace: "test", Job: "b", VideoId: "def", Tenant: "default", StartedAt: start.Add(time.Minute), Outcome: OutcomeRunning})
+	if _, err := launches.Forget("default", "def"); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+
+	cleanups, err := NewStorageCleanupHistory(ctx, 2, store)
+	if err != nil {
+		t.Fatalf("NewStorageCleanupHistory: %v", err)
+	}
+	for i, name := range []string{"one", "two", "three"} {
+		cleanups.Record(CleanupAction{Time: start.Add(time.Duration(i) * time.Second), VideoId: "abc", Kind: "Service", Name: name, Success: true})
+	}
+
+	// Only the first of two replicas claims a job
+	for i, want := range []bool{true, false} {
+		if created, err := store.Create(ctx, CollectionDedup, "cleanup/uid", &dedupMark{Job: "test/a"}); err != nil || created != want {
+			t.Errorf("Create %d = %v, %v, want %v", i, created, err, want)
+		}
+	}
+
+	queue := NewStorageQueue(store)
+	if err := queue.Add(ctx, &PendingLaunch{Tenant: "default", VideoId: "ghi", Reason: "scheduled", CreatedAt: start}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Everything comes back after a restart
+	store, err = NewBoltStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage: %v", err)
+	}
+	defer store.Close()
+
+	launches, err = NewStorageLaunchHistory(ctx, store)
+	if err != nil {
+		t.Fatalf("NewStorageLaunchHistory: %v", err)
+	}
+	if records := launches.Records(""); len(records) != 1 || records[0].Job != "a" {
+		t.Errorf("launch records = %+v, want only job a", records)
+	}
+
+	cleanups, err = NewStorageCleanupHistory(ctx, 2, store)
+	if err != nil {
+		t.Fatalf("NewStorageCleanupHistory: %v", err)
+	}
+	actions := cleanups.List("", "")
+	if len(actions) != 2 || actions[0].Name != "three" || actions[1].Name != "two" {
+		t.Errorf("cleanup actions = %+v, want three and two", actions)
+	}
+	entries, err := store.List(ctx, CollectionCleanups)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("stored cleanup actions = %d (%v), want 2", len(entries), err)
+	}
+
+	queue = NewStorageQueue(store)
+	p, err := queue.Remove(ctx, "default", "ghi")
+	if err != nil || p == nil || p.Reason != "scheduled" {
+		t.Fatalf("Remove = %+v, %v, want the scheduled launch", p, err)
+	}
+	if p, err := queue.Remove(ctx, "default", "ghi"); err != nil || p != nil {
+		t.Errorf("second Remove = %+v, %v, want nil", p, err)
+	}
+}
+
+// failingStorage fails to delete while err is set.
+type failingStorage struct {
+	Storage
+	err error
+}
+
+func (s *failingStorage) Delete(ctx context.Context, collection string, key string) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.Storage.Delete(ctx, collection, key)
+}
+
+func TestCleanupHistoryForgetFailure(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStorage{Storage: NewMemoryStorage()}
+	h, err := NewStorageCleanupHistory(ctx, 10, store)
+	if err != nil {
+		t.Fatalf("NewStorageCleanupHistory: %v", err)
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, videoId := range []string{"abc", "def", "abc"} {
+		h.Record(CleanupAction{Time: start.Add(time.Duration(i) * time.Second), Tenant: "default", VideoId: videoId, Kind: "Service", Name: videoId})
+	}
+
+	store.err = errors.New("unavailable")
+	if _, err := h.Forget("default", "abc"); err == nil {
+		t.Fatalf("Forget succeeded with a failing store")
+	}
+	if actions := h.List("", ""); len(actions) != 3 {
+		t.Errorf("actions after a failed Forget = %+v, want all 3 kept", actions)
+	}
+
+	store.err = nil
+	if removed, err := h.Forget("default", "abc"); err != nil || removed != 2 {
+		t.Fatalf("Forget = %d, %v, want 2 removed", removed, err)
+	}
+	if actions := h.List("", ""); len(actions) != 1 || actions[0].VideoId != "def" {
+		t.Errorf("actions = %+v, want only def", actions)
+	}
+	if entries, err := store.List(ctx, CollectionCleanups); err != nil || len(entries) != 1 {
+		t.Errorf("stored actions = %d (%v), want 1", len(entries), err)
+	}
+}
+
+func TestLaunchHistoryForgetFailure(t *testing.T) {
+	ctx := context.Background()
+	store := &failingStorage{Storage: NewMemoryStorage()}
+	h, err := NewStorageLaunchHistory(ctx, store)
+	if err != nil {
+		t.Fatalf("NewStorageLaunchHistory: %v", err)
+	}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	h.Add(&LaunchRecord{Namespace: "test", Job: "a", VideoId: "abc", Tenant: "default", StartedAt: start, Outcome: OutcomeRunning})
+	h.Add(&LaunchRecord{Namespace: "test", Job: "b", VideoId: "def", Tenant: "default", StartedAt: start, Outcome: OutcomeRunning})
+
+	store.err = errors.New("unavailable")
+	if _, err := h.Forget("default", "abc"); err == nil {
+		t.Fatalf("Forget succeeded with a failing store")
+	}
+	entries, err := store.List(ctx, CollectionLaunches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := h.Records(""); len(records) != len(entries) {
+		t.Errorf("%d records in memory, %d stored, want them to agree", len(records), len(entries))
+	}
+
+	store.err = nil
+	if removed, err := h.Forget("default", "abc"); err != nil || removed != 1 {
+		t.Fatalf("Forget = %d, %v, want 1 removed", removed, err)
+	}
+	if entries, err := store.List(ctx, CollectionLaunches); err != nil || len(entries) != 1 {
+		t.Errorf("stored records = %d (%v), want 1", len(entries), err)
+	}
+}
+
+func TestStorageHistoryReplicas(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Two replicas sharing a storage, started before anything was recorded
+	var launches [2]*LaunchHistory
+	var cleanups [2]*CleanupHistory
+	for i := range launches {
+		var err error
+		if launches[i], err = NewStorageLaunchHistory(ctx, store); err != nil {
+			t.Fatalf("NewStorageLaunchHistory: %v", err)
+		}
+		if cleanups[i], err = NewStorageCleanupHistory(ctx, 10, store); err != nil {
+			t.Fatalf("NewStorageCleanupHistory: %v", err)
+		}
+	}
+
+	launches[0].Add(&LaunchRecord{Namespace: "test", Job: "a", VideoId: "abc", Channel: "UC1", Tenant: "default", StartedAt: start, Outcome: OutcomeRunning})
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "a"}}
+	simulateFinish(job, batchv1.JobComplete)
+	launches[1].Finish(job)
+	if records := launches[0].Records(""); len(records) != 1 || records[0].Outcome != OutcomeSucceeded || records[0].Channel != "UC1" {
+		t.Errorf("records of the first replica = %+v, want the launch finished by the second", records)
+	}
+
+	cleanups[0].Record(CleanupAction{Time: start, Tenant: "default", VideoId: "abc", Kind: "Service", Name: "abc"})
+	if actions := cleanups[1].List("", ""); len(actions) != 1 {
+		t.Errorf("actions of the second replica = %+v, want the first replica's", actions)
+	}
+
+	if removed, err := launches[1].Forget("default", "abc"); err != nil || removed != 1 {
+		t.Errorf("Forget launches = %d, %v, want 1 removed", removed, err)
+	}
+	if removed, err := cleanups[1].Forget("default", "abc"); err != nil || removed != 1 {
+		t.Errorf("Forget cleanups = %d, %v, want 1 removed", removed, err)
+	}
+	if records, actions := launches[0].Records(""), cleanups[0].List("", ""); len(records) != 0 || len(actions) != 0 {
+		t.Errorf("first replica after Forget = %+v, %+v, want nothing", records, actions)
+	}
+}
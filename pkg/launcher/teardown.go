@@ -0,0 +1,123 @@
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	CancelledPreCreation  = "pre-creation"
+	CancelledPostCreation = "post-creation"
+)
+
+type TeardownResult struct {
+	// Cancelled tells whether a pending launch was cancelled before its job
+	// was created, or an existing job was deleted
+	Cancelled string `json:"cancelled,omitempty"`
+	// Pending is the cancelled pending launch
+	Pending *PendingLaunch `json:"pending,omitempty"`
+
+	// Job is the deleted job, empty if only history was purged
+	Job    string       `json:"job,omitempty"`
+	Purged *PurgeResult `json:"purged,omitempty"`
+}
+
+// PurgeResult counts the persisted entries removed for a video.
+type PurgeResult struct {
+	LaunchRecords  int `json:"launchRecords"`
+	CleanupActions int `json:"cleanupActions"`
+	CleanupMarks   int `json:"cleanupMarks"`
+	DeadLetters    int `json:"deadLetters"`
+}
+
+// Teardown cancels the pending launch of a video or, if there is none,
+// deletes its job together with its resources, without starting the
+// post-launch hook. With purge, everything the launcher keeps about the video
+// is forgotten as well, even when it no longer has a job.
+func (s *LauncherService) Teardown(ctx context.Context, tenant *Tenant, videoId string, purge bool) (*TeardownResult, error) {
+	result := &TeardownResult{}
+
+	p, err := s.removePending(ctx, tenant.Name, videoId)
+	if err != nil {
+		return nil, fmt.Errorf("error cancelling pending launch: %w", err)
+	}
+	if p != nil {
+		log.Printf("pending launch of video %s of tenant %s was cancelled", videoId, tenant.Name)
+		result.Cancelled = CancelledPreCreation
+		result.Pending = p
+		if !purge {
+			return result, nil
+		}
+	}
+
+	job, err := s.FindJob(ctx, tenant, videoId)
+	if err != nil && !((purge || result.Pending != nil) && errors.Is(err, ErrNotFound)) {
+		return nil, err
+	}
+
+	if job != nil {
+		// Keep the watcher from treating the deletion as a completion
+		s.markCleanedUp(ctx, job)
+
+		propagation := metav1.DeletePropagationBackground
+		err := s.jobClient(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error deleting job %s: %w", job.Name, err)
+		}
+		log.Printf("job %s of video %s was torn down, deleting associated resources", job.Name, videoId)
+		s.LaunchHistory.Cancel(job)
+
+		err = errors.Join(
+			s.deleteResources(ctx, job.Namespace, job, false),
+			s.deleteCredentials(ctx, job.Namespace, job),
+			s.deleteHookResources(ctx, job.Namespace, job, false),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("job %s was deleted, but not all of its resources: %w", job.Name, err)
+		}
+		result.Job = job.Name
+		result.Cancelled = CancelledPostCreation
+	}
+
+	if purge {
+		launchRecords, err := s.LaunchHistory.Forget(tenant.Name, videoId)
+		if err != nil {
+			return nil, err
+		}
+		cleanupActions, err := s.CleanupHistory.Forget(tenant.Name, videoId)
+		if err != nil {
+			return nil, err
+		}
+		var jobs []string
+		if job != nil {
+			jobs = append(jobs, job.Namespace+"/"+job.Name)
+		}
+		cleanupMarks, err := s.forgetDedup(ctx, tenant.Name, videoId, jobs...)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting cleanup marks: %w", err)
+		}
+		var deadLetters int
+		if s.Delivery != nil {
+			if deadLetters, err = s.Delivery.DeadLetters.Forget(tenant.Name, videoId); err != nil {
+				return nil, err
+			}
+		}
+		result.Purged = &PurgeResult{
+			LaunchRecords:  launchRecords,
+			CleanupActions: cleanupActions,
+			CleanupMarks:   cleanupMarks,
+			DeadLetters:    deadLetters,
+		}
+		log.Printf("purged %d launch records, %d cleanup actions, %d cleanup marks and %d dead letters of video %s",
+			launchRecords, cleanupActions, cleanupMarks, deadLetters, videoId)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,251 @@
+package launcher
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"text/template"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+type TemplateSpec struct {
+	VideoId string `json:"videoId"`
+	Channel string `json:"channel"`
+	Tenant  string `json:"tenant"`
+	Profile string `json:"profile"`
+
+	// ProfileVersion is the version of the profile the launch is rendered from
+	ProfileVersion string `json:"profileVersion,omitempty"`
+
+	Namespace    string
+	UniqueName   string
+	JobName      string
+	VideoIdLabel string
+
+	// CredentialsSecret is the secret per-launch credentials are written to
+	CredentialsSecret string
+
+	// Public hostname, path and URL of the launch, see HostnameConfig
+	IngressHost string
+	IngressPath string
+	URL         string
+
+	// Params are the merged parameters of the launch, see MergeParams
+	Params map[string]any `json:"params,omitempty"`
+	// requestParams are the launch request's own parameters
+	requestParams map[string]any
+
+	// TraceParent and RequestId identify the launch request in traces and logs
+	TraceParent string `json:"traceParent,omitempty"`
+	RequestId   string `json:"requestId,omitempty"`
+
+	// NameSalt is bumped when UniqueName collides with another video
+	NameSalt int `json:"-"`
+
+	// naming generates UniqueName, HashNaming when unset
+	naming NamingStrategy
+}
+
+// NameHashLength is the number of hex characters of UniqueName.
+var NameHashLength = 16
+
+// HTTPRouteGroupKind is the Gateway API route an HTTPRoute template renders.
+var HTTPRouteGroupKind = schema.GroupKind{Group: "gateway.networking.k8s.io", Kind: "HTTPRoute"}
+
+// Istio kinds rendered by the VirtualService and DestinationRule templates.
+var (
+	VirtualServiceGroupKind  = schema.GroupKind{Group: "networking.istio.io", Kind: "VirtualService"}
+	DestinationRuleGroupKind = schema.GroupKind{Group: "networking.istio.io", Kind: "DestinationRule"}
+)
+
+type Manifests struct {
+	PreHooks []*unstructured.Unstructured
+
+	Job                 *batchv1.Job
+	Service             *corev1.Service
+	Ingress             *networkingv1.Ingress
+	PodDisruptionBudget *policyv1.PodDisruptionBudget
+	NetworkPolicy       *networkingv1.NetworkPolicy
+	HTTPRoute           *unstructured.Unstructured
+	VirtualService      *unstructured.Unstructured
+	DestinationRule     *unstructured.Unstructured
+}
+
+func (m *Manifests) Objects() []runtime.Object {
+	var objects []runtime.Object
+	for _, obj := range m.PreHooks {
+		objects = append(objects, obj)
+	}
+	if m.NetworkPolicy != nil {
+		objects = append(objects, m.NetworkPolicy)
+	}
+	if m.Job != nil {
+		objects = append(objects, m.Job)
+	}
+	if m.Service != nil {
+		objects = append(objects, m.Service)
+	}
+	if m.Ingress != nil {
+		objects = append(objects, m.Ingress)
+	}
+	if m.HTTPRoute != nil {
+		objects = append(objects, m.HTTPRoute)
+	}
+	if m.DestinationRule != nil {
+		objects = append(objects, m.DestinationRule)
+	}
+	if m.VirtualService != nil {
+		objects = append(objects, m.VirtualService)
+	}
+	if m.PodDisruptionBudget != nil {
+		objects = append(objects, m.PodDisruptionBudget)
+	}
+	return objects
+}
+
+// NamingStrategy generates the UniqueName of a video. The salt is bumped
+// while the name collides with another video's, and must change the name.
+type NamingStrategy func(videoId string, salt int) string
+
+// HashNaming names videos after the SHA-1 of their ID, truncated to
+// NameHashLength.
+func HashNaming(videoId string, salt int) string {
+	// Hash video ID, salted after a collision
+	input := videoId
+	if salt > 0 {
+		input = fmt.Sprintf("%s#%d", videoId, salt)
+	}
+	hash := sha1.Sum([]byte(input))
+	hashString := fmt.Sprintf("%x", hash)
+
+	return hashString[:NameHashLength]
+}
+
+func GenTemplateSpec(spec *TemplateSpec) {
+	naming := spec.naming
+	if naming == nil {
+		naming = HashNaming
+	}
+
+	spec.UniqueName = naming(spec.VideoId, spec.NameSalt)
+	spec.VideoIdLabel = VideoIdLabel
+	spec.CredentialsSecret = "credentials-" + spec.UniqueName
+	Hostnames.setHostnames(spec)
+}
+
+// SetLaunchMetadata adds the labels and annotations that tie an object to its
+// launch.
+func SetLaunchMetadata(o metav1.Object, spec *TemplateSpec) {
+	// Add labels
+	labels := o.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range DefaultLabels {
+		labels[k] = v
+	}
+	labels[VideoIdLabel] = spec.VideoId
+	labels[TenantLabel] = spec.Tenant
+	labels[ProfileLabel] = spec.Profile
+	o.SetLabels(labels)
+
+	// Add annotations
+	annotations := o.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[VideoIdAnnotation] = spec.VideoId
+	if spec.Channel != "" {
+		annotations[ChannelAnnotation] = spec.Channel
+	}
+	if spec.ProfileVersion != "" {
+		annotations[ProfileVersionAnnotation] = spec.ProfileVersion
+	}
+	o.SetAnnotations(annotations)
+}
+
+// object is a pointer to a typed Kubernetes object
+type object[T any] interface {
+	*T
+	metav1.Object
+}
+
+func newObjectFromTemplate[T any, PT object[T]](kind string, tmpl *template.Template, overlay *Overlay, spec *TemplateSpec) (PT, error) {
+	// Generate template
+	GenTemplateSpec(spec)
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, spec); err != nil {
+		return nil, fmt.Errorf("error executing %s template: %w", kind, err)
+	}
+
+	// Parse resulting YAML
+	var obj *T
+	if err := yaml.NewYAMLOrJSONDecoder(buf, 100).Decode(&obj); err != nil {
+		return nil, fmt.Errorf("error parsing %s YAML: %w", kind, err)
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("%s template is empty", kind)
+	}
+
+	// Apply environment overlay
+	obj, err := ApplyOverlay(overlay, spec, obj)
+	if err != nil {
+		return nil, err
+	}
+	o := PT(obj)
+
+	SetLaunchMetadata(o, spec)
+	return o, nil
+}
+
+func NewJobFromTemplate(tmpl *template.Template, overlay *Overlay, spec *TemplateSpec) (*batchv1.Job, error) {
+	return newObjectFromTemplate[batchv1.Job]("job", tmpl, overlay, spec)
+}
+
+func NewServiceFromTemplate(tmpl *template.Template, overlay *Overlay, spec *TemplateSpec) (*corev1.Service, error) {
+	return newObjectFromTemplate[corev1.Service]("service", tmpl, overlay, spec)
+}
+
+func NewIngressFromTemplate(tmpl *template.Template, overlay *Overlay, spec *TemplateSpec) (*networkingv1.Ingress, error) {
+	return newObjectFromTemplate[networkingv1.Ingress]("ingress", tmpl, overlay, spec)
+}
+
+func NewPodDisruptionBudgetFromTemplate(tmpl *template.Template, overlay *Overlay, spec *TemplateSpec) (*policyv1.PodDisruptionBudget, error) {
+	return newObjectFromTemplate[policyv1.PodDisruptionBudget]("pdb", tmpl, overlay, spec)
+}
+
+func NewNetworkPolicyFromTemplate(tmpl *template.Template, overlay *Overlay, spec *TemplateSpec) (*networkingv1.NetworkPolicy, error) {
+	return newObjectFromTemplate[networkingv1.NetworkPolicy]("networkpolicy", tmpl, overlay, spec)
+}
+
+// NewUnstructuredFromTemplate renders a single object of a kind without typed
+// client support.
+func NewUnstructuredFromTemplate(kind string, tmpl *template.Template, spec *TemplateSpec) (*unstructured.Unstructured, error) {
+	// Generate template
+	GenTemplateSpec(spec)
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, spec); err != nil {
+		return nil, fmt.Errorf("error executing %s template: %w", kind, err)
+	}
+
+	// Parse resulting YAML
+	obj := &unstructured.Unstructured{}
+	if err := yaml.NewYAMLOrJSONDecoder(buf, 100).Decode(&obj.Object); err != nil {
+		return nil, fmt.Errorf("error parsing %s YAML: %w", kind, err)
+	}
+	if len(obj.Object) == 0 {
+		return nil, fmt.Errorf("%s template is empty", kind)
+	}
+
+	SetLaunchMetadata(obj, spec)
+	return obj, nil
+}
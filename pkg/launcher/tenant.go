@@ -0,0 +1,244 @@
+package launcher
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrQuotaExceeded  = errors.New("quota exceeded")
+	DefaultTenantName = "default"
+)
+
+type Tenant struct {
+	Name    string   `yaml:"name" json:"name"`
+	APIKeys []string `yaml:"apiKeys" json:"-"`
+
+	// Namespace overrides the launcher namespace for this tenant's resources
+	Namespace string `yaml:"namespace" json:"namespace,omitempty"`
+
+	// Limits, 0 means unlimited
+	MaxConcurrent int `yaml:"maxConcurrent" json:"maxConcurrent,omitempty"`
+	MaxPerHour    int `yaml:"maxPerHour" json:"maxPerHour,omitempty"`
+
+	// AllowDebug permits requesting rendered manifests with X-Debug
+	AllowDebug bool `yaml:"allowDebug" json:"allowDebug,omitempty"`
+
+	// Params are the tenant's default template parameters
+	Params map[string]any `yaml:"params" json:"params,omitempty"`
+
+	// Admin permits reading the launcher's configuration
+	Admin bool `yaml:"admin" json:"admin,omitempty"`
+}
+
+type TenantConfig struct {
+	Tenants []*Tenant `yaml:"tenants"`
+}
+
+type TenantRegistry struct {
+	// Header that selects a tenant by name when no API key is given. Only
+	// enable this when the launcher sits behind a proxy that sets it.
+	Header string
+
+	tenants map[string]*Tenant
+	byKey   map[string]*Tenant
+
+	mu       sync.Mutex
+	launches map[string][]time.Time
+	locks    map[string]*sync.Mutex
+}
+
+func NewTenantRegistry(config *TenantConfig, header string) (*TenantRegistry, error) {
+	r := &TenantRegistry{
+		Header:   header,
+		tenants:  map[string]*Tenant{},
+		byKey:    map[string]*Tenant{},
+		launches: map[string][]time.Time{},
+		locks:    map[string]*sync.Mutex{},
+	}
+
+	if config != nil {
+		for _, t := range config.Tenants {
+			if t.Name == "" {
+				return nil, fmt.Errorf("tenant name cannot be empty")
+			}
+			if _, ok := r.tenants[t.Name]; ok {
+				return nil, fmt.Errorf("duplicate tenant %q", t.Name)
+			}
+			t.Params = normalizeParams(t.Params)
+			r.tenants[t.Name] = t
+			for _, key := range t.APIKeys {
+				if _, ok := r.byKey[key]; ok {
+					return nil, fmt.Errorf("API key of tenant %q is already in use", t.Name)
+				}
+				r.byKey[key] = t
+			}
+		}
+	}
+
+	// Without any configured tenants, everyone shares the default tenant
+	if len(r.tenants) == 0 {
+		r.tenants[DefaultTenantName] = &Tenant{Name: DefaultTenantName, AllowDebug: true, Admin: true}
+	}
+
+	return r, nil
+}
+
+func LoadTenantRegistry(path string, header string) (*TenantRegistry, error) {
+	if path == "" {
+		return NewTenantRegistry(nil, header)
+	}
+
+	data, err := ReadToString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config TenantConfig
+	if err := yaml.UnmarshalStrict([]byte(data), &config); err != nil {
+		return nil, fmt.Errorf("error parsing tenant config %v: %w", path, err)
+	}
+
+	return NewTenantRegistry(&config, header)
+}
+
+// Resolve finds the tenant of an incoming request, first by API key and then
+// by the tenant header.
+func (r *TenantRegistry) Resolve(req *http.Request) (*Tenant, error) {
+	key := req.Header.Get("X-API-Key")
+	if auth := req.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key != "" {
+		if t, ok := r.byKey[key]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("%w: invalid API key", ErrUnauthorized)
+	}
+
+	if r.Header != "" {
+		if name := req.Header.Get(r.Header); name != "" {
+			if t, ok := r.tenants[name]; ok {
+				return t, nil
+			}
+			return nil, fmt.Errorf("%w: unknown tenant %q", ErrUnauthorized, name)
+		}
+	}
+
+	// Fall back to the default tenant only when no API keys are configured
+	if len(r.byKey) == 0 {
+		if t, ok := r.tenants[DefaultTenantName]; ok {
+			return t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: missing API key", ErrUnauthorized)
+}
+
+// Tenant returns the tenant with the given name.
+func (r *TenantRegistry) Tenant(name string) (*Tenant, bool) {
+	t, ok := r.tenants[name]
+	return t, ok
+}
+
+// List returns the tenants sorted by name.
+func (r *TenantRegistry) List() []*Tenant {
+	tenants := make([]*Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].Name < tenants[j].Name
+	})
+	return tenants
+}
+
+// Namespaces returns the distinct tenant namespaces, with defaultNamespace
+// standing in for tenants without an override.
+func (r *TenantRegistry) Namespaces(defaultNamespace string) []string {
+	seen := map[string]bool{defaultNamespace: true}
+	namespaces := []string{defaultNamespace}
+	for _, t := range r.tenants {
+		if t.Namespace != "" && !seen[t.Namespace] {
+			seen[t.Namespace] = true
+			namespaces = append(namespaces, t.Namespace)
+		}
+	}
+	return namespaces
+}
+
+// Lock serializes quota checks and launches of a single tenant.
+func (r *TenantRegistry) Lock(t *Tenant) func() {
+	r.mu.Lock()
+	l, ok := r.locks[t.Name]
+	if !ok {
+		l = &sync.Mutex{}
+		r.locks[t.Name] = l
+	}
+	r.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// tenantLock is the Lock of a tenant, which can be released early and taken
+// again.
+type tenantLock struct {
+	registry *TenantRegistry
+	tenant   *Tenant
+	unlock   func()
+}
+
+func (l *tenantLock) acquire() {
+	if l.unlock == nil {
+		l.unlock = l.registry.Lock(l.tenant)
+	}
+}
+
+func (l *tenantLock) release() {
+	if l.unlock != nil {
+		l.unlock()
+		l.unlock = nil
+	}
+}
+
+func (l *tenantLock) held() bool {
+	return l.unlock != nil
+}
+
+// CheckHourlyLimit returns an error if the tenant has used up its launches
+// for the past hour.
+func (r *TenantRegistry) CheckHourlyLimit(t *Tenant) error {
+	if t.MaxPerHour <= 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Hour)
+	launches := r.launches[t.Name]
+	for len(launches) > 0 && launches[0].Before(cutoff) {
+		launches = launches[1:]
+	}
+	r.launches[t.Name] = launches
+
+	if len(launches) >= t.MaxPerHour {
+		return fmt.Errorf("%w: tenant %s reached %d launches per hour", ErrQuotaExceeded, t.Name, t.MaxPerHour)
+	}
+	return nil
+}
+
+func (r *TenantRegistry) RecordLaunch(t *Tenant) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.launches[t.Name] = append(r.launches[t.Name], time.Now())
+}
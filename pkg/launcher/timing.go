@@ -0,0 +1,35 @@
+package launcher
+
+import (
+	"time"
+)
+
+// Launch phases besides the creation steps, see CreationSteps.
+const (
+	PhaseRender         = "render"
+	PhaseDryRun         = "dryRun"
+	PhaseResourceQuotas = "resourceQuotas"
+)
+
+// PhaseTiming is how long a phase of a launch took. Waiting for the
+// readiness of a step is a phase of its own, named after the step with a
+// Ready suffix.
+type PhaseTiming struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+// phaseTimer records the phases of a launch, in the order they finished.
+type phaseTimer struct {
+	phases []PhaseTiming
+}
+
+// observe records a phase that started at start and just finished. A nil
+// timer only updates the metrics.
+func (t *phaseTimer) observe(phase string, start time.Time) {
+	elapsed := time.Since(start)
+	launchPhaseDuration.WithLabelValues(phase).Observe(elapsed.Seconds())
+	if t != nil {
+		t.phases = append(t.phases, PhaseTiming{Phase: phase, Seconds: elapsed.Seconds()})
+	}
+}
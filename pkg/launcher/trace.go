@@ -0,0 +1,92 @@
+package launcher
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Headers a launch request is traced by, and the environment variables they
+// are passed to the job's containers in.
+const (
+	TraceParentHeader = "traceparent"
+	RequestIdHeader   = "X-Request-Id"
+
+	TraceParentEnv = "TRACEPARENT"
+	RequestIdEnv   = "REQUEST_ID"
+)
+
+var traceParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// ValidTraceParent tells whether a traceparent header follows the W3C Trace
+// Context format, invalid ones are dropped instead of propagated.
+func ValidTraceParent(traceParent string) bool {
+	if !traceParentPattern.MatchString(traceParent) {
+		return false
+	}
+	parts := strings.Split(traceParent, "-")
+	return parts[0] != "ff" && parts[1] != strings.Repeat("0", 32) && parts[2] != strings.Repeat("0", 16)
+}
+
+// NewRequestId returns a random ID for requests that don't bring their own.
+func NewRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// injectTrace records the launch request's trace context on the job and its
+// pods, and passes it to every container that doesn't set it itself, so the
+// recorder's logs and traces can be joined with the request.
+func injectTrace(job *batchv1.Job, spec *TemplateSpec) {
+	values := map[string]string{}
+	var env []corev1.EnvVar
+	if spec.TraceParent != "" {
+		values[TraceParentAnnotation] = spec.TraceParent
+		env = append(env, corev1.EnvVar{Name: TraceParentEnv, Value: spec.TraceParent})
+	}
+	if spec.RequestId != "" {
+		values[RequestIdAnnotation] = spec.RequestId
+		env = append(env, corev1.EnvVar{Name: RequestIdEnv, Value: spec.RequestId})
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	if job.Spec.Template.Annotations == nil {
+		job.Spec.Template.Annotations = map[string]string{}
+	}
+	for k, v := range values {
+		job.Annotations[k] = v
+		job.Spec.Template.Annotations[k] = v
+	}
+
+	podSpec := &job.Spec.Template.Spec
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for i := range containers {
+			containers[i].Env = appendMissingEnv(containers[i].Env, env)
+		}
+	}
+}
+
+func appendMissingEnv(env []corev1.EnvVar, add []corev1.EnvVar) []corev1.EnvVar {
+	for _, v := range add {
+		found := false
+		for _, existing := range env {
+			found = found || existing.Name == v.Name
+		}
+		if !found {
+			env = append(env, v)
+		}
+	}
+	return env
+}
@@ -0,0 +1,66 @@
+package launcher
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func ReadToString(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading file %v: %w", path, err)
+	}
+	return string(data), nil
+}
+
+func GetCurrentNamespaceOrDefault() string {
+	if ns, err := ReadToString("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err != nil {
+		log.Printf("Error reading namespace file: %v", err)
+		return "default"
+	} else {
+		return ns
+	}
+}
+
+// ManagedLabelSelector selects all resources created by the launcher.
+func ManagedLabelSelector() string {
+	var selectors []string
+	for k, v := range DefaultLabels {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(selectors)
+	return strings.Join(selectors, ",")
+}
+
+func IsJobFinished(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func JobStatus(job *batchv1.Job) string {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return "succeeded"
+		case batchv1.JobFailed:
+			return "failed"
+		}
+	}
+	if job.Status.Active > 0 {
+		return "active"
+	}
+	return "pending"
+}
@@ -0,0 +1,70 @@
+package launcher
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// Field validation levels of create requests, see
+// metav1.CreateOptions.FieldValidation.
+const (
+	FieldValidationStrict = "Strict"
+	FieldValidationWarn   = "Warn"
+	FieldValidationIgnore = "Ignore"
+)
+
+type warningsKey struct{}
+
+// warningCollector gathers the API server warnings of the requests made with
+// its context.
+type warningCollector struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func withWarningCollector(ctx context.Context) (context.Context, *warningCollector) {
+	c := &warningCollector{}
+	return context.WithValue(ctx, warningsKey{}, c), c
+}
+
+func (c *warningCollector) add(text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warnings = append(c.warnings, text)
+}
+
+func (c *warningCollector) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.warnings...)
+}
+
+type warningRoundTripper struct {
+	next http.RoundTripper
+}
+
+// WarningRecorder wraps the transport of a rest.Config, so API server
+// warnings reach the launch that caused them. Use it with config.Wrap.
+func WarningRecorder(rt http.RoundTripper) http.RoundTripper {
+	return &warningRoundTripper{next: rt}
+}
+
+func (t *warningRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	c, ok := req.Context().Value(warningsKey{}).(*warningCollector)
+	if !ok {
+		return resp, nil
+	}
+	warnings, _ := utilnet.ParseWarningHeaders(resp.Header["Warning"])
+	for _, w := range warnings {
+		c.add(w.Text)
+	}
+	return resp, nil
+}
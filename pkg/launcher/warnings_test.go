@@ -0,0 +1,34 @@
+package launcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWarningRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Warning", `299 - "spec.foo: deprecated"`)
+		w.Header().Add("Warning", `299 - "unknown field \"spec.bar\""`)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: WarningRecorder(http.DefaultTransport)}
+	ctx, warnings := withWarningCollector(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := []string{"spec.foo: deprecated", `unknown field "spec.bar"`}
+	if got := warnings.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("warnings = %q, want %q", got, want)
+	}
+}